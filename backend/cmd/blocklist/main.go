@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// blocklist builds the password blocklist file from plain text password lists (one per line)
+//
+// the output holds uppercase sha1 hashes sorted ascending with the number of lists containing
+// the password ("HASH:COUNT", the format of the pwned passwords "ordered by hash" download).
+// the full pwned passwords file can be used as PASSWORD_BLOCKLIST_FILE directly
+func main() {
+	out := flag.String("out", "blocklist/passwords.txt", "output file")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		log.Fatalf("usage: blocklist [-out file] list.txt [list.txt ...]")
+	}
+
+	counts := make(map[string]int)
+	for _, path := range flag.Args() {
+		if err := readList(path, counts); err != nil {
+			log.Fatalf("could not read %s: %v", path, err)
+		}
+	}
+
+	hashes := make([]string, 0, len(counts))
+	for hash := range counts {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	file, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("could not create %s: %v", *out, err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, hash := range hashes {
+		fmt.Fprintf(writer, "%s:%d\n", hash, counts[hash])
+	}
+	if err := writer.Flush(); err != nil {
+		log.Fatalf("could not write %s: %v", *out, err)
+	}
+
+	log.Printf("wrote %d hashes to %s", len(hashes), *out)
+}
+
+// add the hash of every password in the list (duplicates inside one list count once)
+func readList(path string, counts map[string]int) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		password := strings.TrimRight(scanner.Text(), "\r")
+		if password == "" || strings.HasPrefix(password, "#") {
+			continue
+		}
+
+		sum := sha1.Sum([]byte(password))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		if !seen[hash] {
+			seen[hash] = true
+			counts[hash]++
+		}
+	}
+	return scanner.Err()
+}
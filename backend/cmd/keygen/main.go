@@ -0,0 +1,33 @@
+package main
+
+import (
+	"backend/pkg/utils"
+
+	"flag"
+	"log"
+	"time"
+)
+
+// keygen creates a new jwt or qr signing key file for key rotation
+//
+// rotation: generate a new key, add the old file to JWT_VERIFICATION_KEY_FILES and point
+// JWT_SIGNING_KEY_FILE to the new one. remove the old file once its refresh tokens expired
+//
+// qr keys (ed25519 only) rotate the same way with QR_SIGNING_KEY_FILE and QR_VERIFICATION_KEY_FILES.
+// list the new key under QR_VERIFICATION_KEY_FILES first so offline clients fetch it before it signs
+// codes, and keep the old key until every code printed with it was replaced
+func main() {
+	keyType := flag.String("type", "ed25519", "key type (ed25519 or rsa)")
+	out := flag.String("out", "keys/jwt_signing.pem", "output pem file (must not exist)")
+	flag.Parse()
+
+	if err := utils.GenerateSigningKeyFile(*out, *keyType); err != nil {
+		log.Fatalf("key generation failed: %v", err)
+	}
+
+	keys, err := utils.LoadKeySet(*out, nil, false, "", time.Time{})
+	if err != nil {
+		log.Fatalf("generated key is unreadable: %v", err)
+	}
+	log.Printf("wrote %s key %s to %s", *keyType, keys.SigningKeyID(), *out)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockoidc is a minimal openid connect provider for local development and tests.
+// every authorization request is approved immediately for the email in ?login_hint=
+// (append &email_verified=false to simulate an unverified address). never deploy it
+func main() {
+	issuer := getEnv("MOCK_OIDC_ISSUER", "http://localhost:9000")
+	addr := getEnv("MOCK_OIDC_ADDR", ":9000")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatalf("key generation failed: %v", err)
+	}
+
+	p := &provider{
+		issuer: issuer,
+		key:    key,
+		codes:  make(map[string]*authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	mux.HandleFunc("/jwks", p.jwks)
+
+	log.Printf("mock oidc provider %s listening on %s", issuer, addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// provider keeps issued authorization codes in memory
+type provider struct {
+	issuer string
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+// an approved authorization request waiting for the token request
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	emailVerified bool
+	expires       time.Time
+}
+
+const keyID = "mock"
+
+func (p *provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.issuer,
+		"authorization_endpoint":                p.issuer + "/authorize",
+		"token_endpoint":                        p.issuer + "/token",
+		"jwks_uri":                              p.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "only the code flow with S256 pkce is supported", http.StatusBadRequest)
+		return
+	}
+
+	email := query.Get("login_hint")
+	if email == "" {
+		email = "player@example.com"
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = &authorization{
+		clientID:      query.Get("client_id"),
+		redirectURI:   redirectURI.String(),
+		codeChallenge: query.Get("code_challenge"),
+		nonce:         query.Get("nonce"),
+		email:         email,
+		emailVerified: query.Get("email_verified") != "false",
+		expires:       time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+		return
+	}
+
+	// clients may authenticate with basic auth or form parameters
+	clientID, _, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if unescaped, err := url.QueryUnescape(clientID); err == nil {
+		clientID = unescaped
+	}
+
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	auth, exists := p.codes[code]
+	delete(p.codes, code) // codes are single use
+	p.mu.Unlock()
+
+	if !exists || time.Now().After(auth.expires) || auth.clientID != clientID || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "pkce verification failed"})
+		return
+	}
+
+	// the subject is derived from the email so repeated logins map to the same identity
+	subject := sha256.Sum256([]byte(auth.email))
+	now := time.Now()
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.issuer,
+		"sub":            base64.RawURLEncoding.EncodeToString(subject[:16]),
+		"aud":            clientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.email,
+		"email_verified": auth.emailVerified,
+	})
+	idToken.Header["kid"] = keyID
+
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     signed,
+	})
+}
+
+func (p *provider) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func getEnv(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
@@ -4,14 +4,19 @@ import (
 	"backend/internal/api"
 	"backend/pkg/config"
 	"backend/pkg/db"
+	"backend/pkg/mail"
+	"backend/pkg/utils"
 
 	"context"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 // main entry point
@@ -37,8 +42,79 @@ func main() {
 	}
 	logger.Println("database migrations applied")
 
+	// init mailer for verification and notification emails
+	mailer, err := mail.NewMailer(cfg, logger)
+	if err != nil {
+		logger.Fatalf("mailer setup failed: %v", err)
+	}
+	logger.Printf("mail driver: %s", cfg.MailDriver)
+
+	// load jwt keys (signing key + rotated out keys still accepted for verification)
+	var legacyUntil time.Time
+	if cfg.JWTLegacyUntil != "" {
+		if legacyUntil, err = time.Parse(time.RFC3339, cfg.JWTLegacyUntil); err != nil {
+			logger.Fatalf("invalid JWT_LEGACY_UNTIL: %v", err)
+		}
+	}
+	keys, err := utils.LoadKeySet(cfg.JWTSigningKeyFile, strings.Split(cfg.JWTVerificationKeyFiles, ","), cfg.JWTGenerateSigningKey, cfg.JWTSecret, legacyUntil)
+	if err != nil {
+		logger.Fatalf("jwt key setup failed: %v", err)
+	}
+	logger.Printf("jwt signing key: %s", keys.SigningKeyID())
+
+	// load qr keys (printed codes stay valid as long as their key is configured)
+	qrKeys, err := utils.LoadQRKeySet(cfg.QRSigningKeyFile, strings.Split(cfg.QRVerificationKeyFiles, ","), cfg.QRGenerateSigningKey)
+	if err != nil {
+		logger.Fatalf("qr key setup failed: %v", err)
+	}
+	logger.Printf("qr signing key: %s", qrKeys.SigningKeyID())
+
+	// argon2 parameters for new password hashes (existing hashes keep theirs until the next login)
+	passwordParams := utils.Argon2Params{
+		Time:      uint32(cfg.PasswordHashTime),
+		Memory:    uint32(cfg.PasswordHashMemory),
+		Threads:   uint8(cfg.PasswordHashThreads),
+		KeyLength: 32,
+	}
+	if err := passwordParams.Validate(); err != nil {
+		logger.Fatalf("password hash setup failed: %v", err)
+	}
+
+	// rules for new passwords, the blocklist file is searched on disk and never loaded into memory
+	passwordPolicy := &utils.PasswordPolicy{
+		MinLength:            cfg.PasswordMinLength,
+		MaxLength:            cfg.PasswordMaxLength,
+		RequireUpper:         cfg.PasswordRequireUpper,
+		RequireLower:         cfg.PasswordRequireLower,
+		RequireDigit:         cfg.PasswordRequireDigit,
+		RequireSymbol:        cfg.PasswordRequireSymbol,
+		MaxRepeated:          cfg.PasswordMaxRepeated,
+		ForbidEmailLocalPart: cfg.PasswordForbidEmail,
+	}
+	if cfg.PasswordBlocklistFile != "" {
+		passwordPolicy.Blocklist, err = utils.OpenPasswordBlocklist(cfg.PasswordBlocklistFile)
+		if err != nil {
+			logger.Fatalf("password policy setup failed: %v", err)
+		}
+		logger.Printf("password blocklist: %s", cfg.PasswordBlocklistFile)
+	}
+
+	// webauthn relying party for passkeys (origins default to the public url)
+	origins := []string{cfg.PublicBaseURL}
+	if cfg.WebAuthnRPOrigins != "" {
+		origins = strings.Split(cfg.WebAuthnRPOrigins, ",")
+	}
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.WebAuthnRPID,
+		RPDisplayName: cfg.WebAuthnRPName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		logger.Fatalf("webauthn setup failed: %v", err)
+	}
+
 	// initialize http router with all api endpoints
-	router := api.SetupRouter(dbSession, cfg, logger)
+	router := api.SetupRouter(dbSession, cfg, keys, qrKeys, relyingParty, passwordParams, passwordPolicy, mailer, logger)
 
 	// configure http server with timeouts
 	server := &http.Server{
@@ -1,16 +1,30 @@
 module backend
 
-go 1.24
+go 1.24.0
 
-require github.com/gocql/gocql v1.7.0
+require (
+	github.com/coreos/go-oidc/v3 v3.10.0
+	github.com/go-webauthn/webauthn v0.15.0
+	github.com/gocql/gocql v1.7.0
+	golang.org/x/oauth2 v0.30.0
+)
 
-require golang.org/x/sys v0.34.0 // indirect
+require (
+	github.com/fxamacker/cbor/v2 v2.9.0 // indirect
+	github.com/go-jose/go-jose/v4 v4.1.3 // indirect
+	github.com/go-viper/mapstructure/v2 v2.4.0 // indirect
+	github.com/go-webauthn/x v0.1.26 // indirect
+	github.com/google/go-tpm v0.9.6 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/sys v0.37.0 // indirect
+)
 
 require (
 	github.com/golang-jwt/jwt/v5 v5.3.0
 	github.com/golang/snappy v0.0.3 // indirect
 	github.com/gorilla/mux v1.8.1
 	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
-	golang.org/x/crypto v0.40.0
+	golang.org/x/crypto v0.43.0
 	gopkg.in/inf.v0 v0.9.1 // indirect
 )
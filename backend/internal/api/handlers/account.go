@@ -3,6 +3,7 @@ package handlers
 import (
 	"backend/internal/repository"
 	"backend/internal/service"
+	"backend/pkg/utils"
 
 	"encoding/json"
 	"net/http"
@@ -12,13 +13,15 @@ import (
 
 // AccountHandler handles account-related HTTP requests
 type AccountHandler struct {
-	accountService *service.AccountService
+	accountService  *service.AccountService
+	deletionService *service.AccountDeletionService
 }
 
 // NewAccountHandler creates a new account handler
-func NewAccountHandler(accountService *service.AccountService) *AccountHandler {
+func NewAccountHandler(accountService *service.AccountService, deletionService *service.AccountDeletionService) *AccountHandler {
 	return &AccountHandler{
-		accountService: accountService,
+		accountService:  accountService,
+		deletionService: deletionService,
 	}
 }
 
@@ -37,6 +40,9 @@ func (h *AccountHandler) Register(w http.ResponseWriter, r *http.Request) {
 
 	// create account
 	account, err := h.accountService.RegisterAccount(req.Email, req.Password)
+	if respondPasswordPolicyError(w, err) {
+		return
+	}
 	if err != nil {
 		status := http.StatusInternalServerError
 		if err == repository.ErrEmailExists || err.Error() == "invalid email format" {
@@ -46,18 +52,71 @@ func (h *AccountHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// send verification link (account stays usable for login if this fails, the user can request a new link)
+	mailErr := h.accountService.SendVerificationEmail(account)
+
 	// response without sensitive data
 	respondJSON(w, http.StatusCreated, map[string]interface{}{
-		"id":         account.ID.String(),
-		"email":      account.Email,
-		"created_at": account.CreatedAt,
+		"id":                account.ID.String(),
+		"email":             account.Email,
+		"created_at":        account.CreatedAt,
+		"verified":          account.Verified,
+		"verification_sent": mailErr == nil,
+	})
+}
+
+// VerifyEmail redeems an email verification token (GET from the mailed link or POST with json body)
+func (h *AccountHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token, ok := readLinkToken(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := h.accountService.VerifyEmail(token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == service.ErrInvalidVerificationToken || err == service.ErrAlreadyVerified {
+			status = http.StatusBadRequest
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "email verified",
+		"id":       account.ID.String(),
+		"email":    account.Email,
+		"verified": account.Verified,
 	})
 }
 
+// ResendVerification sends a new verification link to the authenticated user
+func (h *AccountHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	// get user ID from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.accountService.ResendVerificationEmail(userID); err != nil {
+		status := http.StatusInternalServerError
+		if err == service.ErrAlreadyVerified {
+			status = http.StatusBadRequest
+		} else if err == service.ErrGuestAccount {
+			status = http.StatusForbidden
+		}
+		respondError(w, "could not send verification email - "+err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "verification email sent"})
+}
+
 // ChangePassword handles password updates
 func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	// get user ID from context
-	userID, ok := r.Context().Value("userID").(string)
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
 	if !ok {
 		respondError(w, "authentication required", http.StatusUnauthorized)
 		return
@@ -74,18 +133,16 @@ func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// convert to UUID
-	uuid, err := gocql.ParseUUID(userID)
-	if err != nil {
-		respondError(w, "invalid user ID", http.StatusBadRequest)
-		return
-	}
-
 	// update password
-	if err := h.accountService.ChangePassword(uuid, req.OldPassword, req.NewPassword); err != nil {
+	if err := h.accountService.ChangePassword(userID, req.OldPassword, req.NewPassword); err != nil {
+		if respondPasswordPolicyError(w, err) {
+			return
+		}
 		status := http.StatusInternalServerError
 		if err.Error() == "invalid current password" {
 			status = http.StatusUnauthorized
+		} else if err == service.ErrGuestAccount || err == service.ErrPasswordNotSet {
+			status = http.StatusForbidden
 		}
 		respondError(w, err.Error(), status)
 		return
@@ -94,7 +151,93 @@ func (h *AccountHandler) ChangePassword(w http.ResponseWriter, r *http.Request)
 	respondJSON(w, http.StatusOK, map[string]string{"status": "password updated"})
 }
 
-// Delete handles account deletion
+// ChangeEmail requests a new email address, it only applies after the new address confirmed it
+func (h *AccountHandler) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	// get user ID from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+		NewEmail string `json:"new_email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.accountService.RequestEmailChange(userID, req.Password, req.NewEmail); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case err.Error() == "invalid current password":
+			status = http.StatusUnauthorized
+		case err == repository.ErrEmailExists:
+			status = http.StatusConflict
+		case err == service.ErrEmailUnchanged || err == service.ErrPasswordNotSet || err.Error() == "invalid email format":
+			status = http.StatusBadRequest
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusAccepted, map[string]string{"status": "confirmation email sent"})
+}
+
+// ConfirmEmailChange redeems the link mailed to the new address (GET from the link or POST with json body)
+func (h *AccountHandler) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token, ok := readLinkToken(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := h.accountService.ConfirmEmailChange(token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case service.ErrInvalidEmailChangeToken:
+			status = http.StatusBadRequest
+		case repository.ErrEmailExists:
+			status = http.StatusConflict // taken by another account after the request
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "email changed",
+		"id":       account.ID.String(),
+		"email":    account.Email,
+		"verified": account.Verified,
+	})
+}
+
+// CancelEmailChange redeems the link mailed to the previous address (GET from the link or POST with json body)
+func (h *AccountHandler) CancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	token, ok := readLinkToken(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.accountService.CancelEmailChange(token); err != nil {
+		status := http.StatusInternalServerError
+		switch err {
+		case service.ErrInvalidEmailChangeToken:
+			status = http.StatusBadRequest
+		case repository.ErrEmailExists:
+			status = http.StatusConflict // previous address was taken by another account in between
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "email change cancelled"})
+}
+
+// Delete schedules the account for deletion, it can be restored through the mailed link until the purge
 func (h *AccountHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	// get user ID from context
 	userID, ok := r.Context().Value("userID").(gocql.UUID)
@@ -104,11 +247,60 @@ func (h *AccountHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// delete account
-	if err := h.accountService.DeleteAccount(userID); err != nil {
+	mailSent, err := h.deletionService.RequestDeletion(userID, utils.ClientIP(r))
+	if err != nil {
 		status := http.StatusInternalServerError
 		respondError(w, err.Error(), status)
 		return
 	}
 
-	respondJSON(w, http.StatusOK, map[string]string{"status": "account deleted"})
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":             "account scheduled for deletion",
+		"restore_email_sent": mailSent,
+	})
+}
+
+// RestoreAccount redeems the link from the deletion email (GET from the link or POST with json body)
+func (h *AccountHandler) RestoreAccount(w http.ResponseWriter, r *http.Request) {
+	token, ok := readLinkToken(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := h.deletionService.RestoreAccount(token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == service.ErrInvalidRestoreToken {
+			status = http.StatusBadRequest
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "account restored",
+		"id":     account.ID.String(),
+		"email":  account.Email,
+	})
+}
+
+// read the token of a mailed link from the query or, for POST requests, from the json body
+func readLinkToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	token := r.URL.Query().Get("token")
+	if token == "" && r.Method == http.MethodPost {
+		var req struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, "invalid request format", http.StatusBadRequest)
+			return "", false
+		}
+		token = req.Token
+	}
+
+	if token == "" {
+		respondError(w, "token required", http.StatusBadRequest)
+		return "", false
+	}
+	return token, true
 }
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/service"
+
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// APIKeyHandler exposes service account and api key management
+type APIKeyHandler struct {
+	apiKeyService *service.APIKeyService
+}
+
+// NewAPIKeyHandler creates a new api key handler
+func NewAPIKeyHandler(apiKeyService *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// ListServiceAccounts returns all service accounts
+func (h *APIKeyHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.apiKeyService.ListServiceAccounts()
+	if err != nil {
+		respondError(w, "could not get service accounts - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if accounts == nil {
+		accounts = []models.ServiceAccount{}
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"service_accounts": accounts,
+	})
+}
+
+// CreateServiceAccount registers a new service account
+func (h *APIKeyHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	adminID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	account, err := h.apiKeyService.CreateServiceAccount(truncate(req.Name, 100), truncate(req.Description, 500), adminID)
+	if err != nil {
+		respondError(w, err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, account)
+}
+
+// DeleteServiceAccount revokes all keys of a service account and deletes it
+func (h *APIKeyHandler) DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	adminID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ServiceAccountID string `json:"service_account_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	serviceAccountID, err := gocql.ParseUUID(req.ServiceAccountID)
+	if err != nil {
+		respondError(w, "invalid service_account_id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.apiKeyService.DeleteServiceAccount(serviceAccountID, adminID); err != nil {
+		respondError(w, "could not delete service account - "+err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "service account deleted"})
+}
+
+// ListKeys returns the keys of a service account (?service_account_id=)
+func (h *APIKeyHandler) ListKeys(w http.ResponseWriter, r *http.Request) {
+	serviceAccountID, err := gocql.ParseUUID(r.URL.Query().Get("service_account_id"))
+	if err != nil {
+		respondError(w, "invalid service_account_id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	keys, err := h.apiKeyService.ListKeys(serviceAccountID)
+	if err != nil {
+		respondError(w, "could not get api keys - "+err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	now := time.Now()
+	result := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, apiKeyResponse(&key, now))
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": result,
+	})
+}
+
+// CreateKey issues a new api key, the plain key is only part of this response
+func (h *APIKeyHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	adminID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ServiceAccountID string              `json:"service_account_id"`
+		Name             string              `json:"name"`
+		Scopes           []models.Permission `json:"scopes"`
+		ExpiresAt        time.Time           `json:"expires_at"` // optional, never expires if omitted
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	serviceAccountID, err := gocql.ParseUUID(req.ServiceAccountID)
+	if err != nil {
+		respondError(w, "invalid service_account_id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	key, plain, err := h.apiKeyService.CreateKey(serviceAccountID, truncate(req.Name, 100), req.Scopes, req.ExpiresAt, adminID)
+	if err != nil {
+		respondError(w, "could not create api key - "+err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	response := apiKeyResponse(key, time.Now())
+	response["key"] = plain
+	respondJSON(w, http.StatusCreated, response)
+}
+
+// RotateKey replaces a key, the old key keeps working for the configured grace period
+func (h *APIKeyHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	adminID, keyID, ok := parseAPIKeyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	key, plain, old, err := h.apiKeyService.RotateKey(keyID, adminID)
+	if err != nil {
+		respondError(w, "could not rotate api key - "+err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	response := apiKeyResponse(key, time.Now())
+	response["key"] = plain
+	response["replaced_key"] = apiKeyResponse(old, time.Now())
+	respondJSON(w, http.StatusCreated, response)
+}
+
+// RevokeKey disables a key immediately
+func (h *APIKeyHandler) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	adminID, keyID, ok := parseAPIKeyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.apiKeyService.RevokeKey(keyID, adminID); err != nil {
+		respondError(w, "could not revoke api key - "+err.Error(), apiKeyErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "api key revoked"})
+}
+
+// parse the acting admin from the context and the key id from the body
+func parseAPIKeyRequest(w http.ResponseWriter, r *http.Request) (gocql.UUID, string, bool) {
+	// get user id from context
+	adminID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return gocql.UUID{}, "", false
+	}
+
+	var req struct {
+		KeyID string `json:"key_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyID == "" {
+		respondError(w, "key_id is required", http.StatusBadRequest)
+		return gocql.UUID{}, "", false
+	}
+	return adminID, req.KeyID, true
+}
+
+// key metadata without the secret hash
+func apiKeyResponse(key *models.APIKey, now time.Time) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":                 key.ID,
+		"service_account_id": key.ServiceAccountID.String(),
+		"name":               key.Name,
+		"scopes":             key.Scopes,
+		"created_by":         key.CreatedBy.String(),
+		"created_at":         key.CreatedAt,
+		"active":             key.Active(now),
+	}
+	if !key.ExpiresAt.IsZero() {
+		response["expires_at"] = key.ExpiresAt
+	}
+	if !key.LastUsedAt.IsZero() {
+		response["last_used_at"] = key.LastUsedAt
+	}
+	if !key.RevokedAt.IsZero() {
+		response["revoked_at"] = key.RevokedAt
+	}
+	return response
+}
+
+// map api key errors to http status codes
+func apiKeyErrorStatus(err error) int {
+	switch err {
+	case service.ErrServiceAccountNotFound, repository.ErrAPIKeyNotFound:
+		return http.StatusNotFound
+	case service.ErrServiceAccountName, service.ErrNoScopes, service.ErrAPIKeyExpiryInPast:
+		return http.StatusBadRequest
+	case service.ErrScopeNotAllowed:
+		return http.StatusForbidden
+	case service.ErrAPIKeyInactive:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
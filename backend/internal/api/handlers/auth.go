@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"backend/internal/models"
 	"backend/internal/service"
 	"backend/pkg/config"
 	"backend/pkg/utils"
 
 	"encoding/json"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,17 +18,33 @@ import (
 
 // AuthHandler manages authentication endpoints
 type AuthHandler struct {
-	accountService *service.AccountService
-	sessionService *service.SessionService
-	cfg            *config.Config
+	accountService         *service.AccountService
+	sessionService         *service.SessionService
+	twoFactorService       *service.TwoFactorService
+	loginProtectionService *service.LoginProtectionService
+	revocationService      *service.TokenRevocationService
+	oidcService            *service.OIDCService
+	passkeyService         *service.PasskeyService
+	guestService           *service.GuestService
+	magicLinkService       *service.MagicLinkService
+	keys                   *utils.KeySet
+	cfg                    *config.Config
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(accountService *service.AccountService, sessionService *service.SessionService, cfg *config.Config) *AuthHandler {
+func NewAuthHandler(accountService *service.AccountService, sessionService *service.SessionService, twoFactorService *service.TwoFactorService, loginProtectionService *service.LoginProtectionService, revocationService *service.TokenRevocationService, oidcService *service.OIDCService, passkeyService *service.PasskeyService, guestService *service.GuestService, magicLinkService *service.MagicLinkService, keys *utils.KeySet, cfg *config.Config) *AuthHandler {
 	return &AuthHandler{
-		accountService: accountService,
-		sessionService: sessionService,
-		cfg:            cfg,
+		accountService:         accountService,
+		sessionService:         sessionService,
+		twoFactorService:       twoFactorService,
+		loginProtectionService: loginProtectionService,
+		revocationService:      revocationService,
+		oidcService:            oidcService,
+		passkeyService:         passkeyService,
+		guestService:           guestService,
+		magicLinkService:       magicLinkService,
+		keys:                   keys,
+		cfg:                    cfg,
 	}
 }
 
@@ -33,9 +52,10 @@ func NewAuthHandler(accountService *service.AccountService, sessionService *serv
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	// parse request body
 	var req struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
-		DeviceID string `json:"device_id"` // from client device
+		Email      string `json:"email"`
+		Password   string `json:"password"`
+		DeviceID   string `json:"device_id"`   // from client device
+		DeviceName string `json:"device_name"` // optional user assigned name
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -43,17 +63,161 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// reject locked out accounts and ips before spending time on the password hash
+	ip := utils.ClientIP(r)
+	if !h.checkLockout(w, models.LockoutScopeAccount, req.Email, ip) {
+		return
+	}
+
 	// authenticate credentials
 	user, err := h.accountService.Authenticate(req.Email, req.Password)
+	if err == service.ErrInvalidCredentials {
+		h.recordFailure(w, models.LockoutScopeAccount, req.Email, ip, err)
+		return
+	} else if err == service.ErrAccountDisabled || err == service.ErrAccountPendingDeletion {
+		respondError(w, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
+		respondError(w, "authentication failed - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.loginProtectionService.RecordSuccess(models.LockoutScopeAccount, req.Email); err != nil {
+		respondError(w, "login tracking failed - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	device_id_parsed, err := gocql.ParseUUID(req.DeviceID)
+	if err != nil {
+		respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.completeLogin(w, r, user.ID, device_id_parsed, req.DeviceName)
+}
+
+// issue a two factor challenge if the account has an authenticator, otherwise the session
+func (h *AuthHandler) completeLogin(w http.ResponseWriter, r *http.Request, userID, deviceID gocql.UUID, deviceName string) {
+	// accounts with an authenticator get a challenge instead of tokens
+	twoFactor, err := h.twoFactorService.IsEnabled(userID)
+	if err != nil {
+		respondError(w, "two factor lookup failed - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if twoFactor {
+		challenge, err := h.twoFactorService.IssueChallenge(userID, deviceID, deviceName)
+		if err != nil {
+			respondError(w, "challenge generation failed - "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_in":          int(h.twoFactorService.ChallengeTTL().Seconds()),
+			"user_id":             userID.String(),
+		})
+		return
+	}
+
+	h.issueSession(w, r, userID, deviceID, deviceName)
+}
+
+// LoginTwoFactor completes a login challenge with a totp or recovery code
+func (h *AuthHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`          // current authenticator code
+		RecoveryCode   string `json:"recovery_code"` // used if no code is given
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	userID, deviceID, deviceName, err := h.twoFactorService.ValidateChallenge(req.ChallengeToken)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	// codes are short so the second step is rate limited on its own
+	ip := utils.ClientIP(r)
+	if !h.checkLockout(w, models.LockoutScopeTwoFactor, userID.String(), ip) {
+		return
+	}
+
+	if err := h.twoFactorService.Verify(userID, req.Code, req.RecoveryCode); err != nil {
+		if err == service.ErrInvalidTwoFactorCode || err == service.ErrTwoFactorNotEnrolled {
+			h.recordFailure(w, models.LockoutScopeTwoFactor, userID.String(), ip, err)
+			return
+		}
+		respondError(w, "authentication failed - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.loginProtectionService.RecordSuccess(models.LockoutScopeTwoFactor, userID.String()); err != nil {
+		respondError(w, "login tracking failed - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.issueSession(w, r, userID, deviceID, deviceName)
+}
+
+// respond with 429 if the key or ip is locked out, returns false if the request was rejected
+func (h *AuthHandler) checkLockout(w http.ResponseWriter, scope, key, ip string) bool {
+	retryAfter, err := h.loginProtectionService.Check(scope, key, ip)
+	if err != nil {
+		respondError(w, "login tracking failed - "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if retryAfter > 0 {
+		respondTooManyAttempts(w, retryAfter)
+		return false
+	}
+	return true
+}
+
+// count a failed attempt and respond with 401, or 429 if the attempt triggered a lockout
+func (h *AuthHandler) recordFailure(w http.ResponseWriter, scope, key, ip string, authErr error) {
+	retryAfter, err := h.loginProtectionService.RecordFailure(scope, key, ip)
 	if err != nil {
-		respondError(w, "authentication failed - "+err.Error(), http.StatusUnauthorized)
+		respondError(w, "login tracking failed - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		respondTooManyAttempts(w, retryAfter)
+		return
+	}
+	respondError(w, "authentication failed - "+authErr.Error(), http.StatusUnauthorized)
+}
+
+// respond with 403 if an admin disabled the account, returns false if the request was rejected
+func (h *AuthHandler) checkNotDisabled(w http.ResponseWriter, userID gocql.UUID) bool {
+	disabled, err := h.accountService.IsDisabled(userID)
+	if err != nil {
+		respondError(w, "account check failed - "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if disabled {
+		respondError(w, service.ErrAccountDisabled.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// generate a token pair, persist the device session and write the login response
+func (h *AuthHandler) issueSession(w http.ResponseWriter, r *http.Request, userID, deviceID gocql.UUID, deviceName string) {
+	// the account may have been disabled while a two factor challenge was pending
+	if !h.checkNotDisabled(w, userID) {
 		return
 	}
 
 	// generate token pair
 	accessToken, refreshToken, err := utils.GenerateTokenPair(
-		user.ID.String(),
-		[]byte(h.cfg.JWTSecret),
+		userID.String(),
+		h.keys,
 		time.Duration(h.cfg.AccessTokenTTL)*time.Minute,
 		time.Duration(h.cfg.RefreshTokenTTL)*24*time.Hour,
 	)
@@ -62,17 +226,12 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	device_id_parsed, err := gocql.ParseUUID(req.DeviceID)
-	if err != nil {
-		respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
-		return
-	}
-
 	// create persistent session
 	if err := h.sessionService.CreatePermanentSession(
-		user.ID,
-		device_id_parsed,
+		userID,
+		deviceID,
 		refreshToken,
+		sessionMetadata(r, deviceName),
 	); err != nil {
 		respondError(w, "session creation failed - "+err.Error(), http.StatusInternalServerError)
 		return
@@ -83,7 +242,7 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		"access_token":  accessToken,
 		"refresh_token": refreshToken,
 		"expires_in":    h.cfg.AccessTokenTTL * 60,
-		"user_id":       user.ID.String(),
+		"user_id":       userID.String(),
 	})
 }
 
@@ -104,13 +263,14 @@ func (h *AuthHandler) SilentLogin(w http.ResponseWriter, r *http.Request) {
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 
-	// extract user ID from token
-	claims, err := utils.ParseToken(token, []byte(h.cfg.JWTSecret))
+	// extract user ID from token (only refresh tokens are accepted)
+	claims, err := utils.ValidateToken(token, "refresh", h.keys)
 	if err != nil {
 		respondError(w, "Invalid token", http.StatusForbidden)
 		return
 	}
-	userID, err := gocql.ParseUUID(claims["sub"].(string))
+	subject, _ := claims["sub"].(string)
+	userID, err := gocql.ParseUUID(subject)
 	if err != nil {
 		respondError(w, "invalid user claim", http.StatusForbidden)
 		return
@@ -118,15 +278,33 @@ func (h *AuthHandler) SilentLogin(w http.ResponseWriter, r *http.Request) {
 
 	// validate session and device binding
 	valid, err := h.sessionService.ValidateSession(userID, deviceID, token)
-	if err != nil || !valid {
+	if err != nil {
+		respondError(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+	if !valid {
+		// a signed refresh token that no longer matches may be a replay of a rotated token
+		reused, err := h.sessionService.DetectTokenReuse(userID, deviceID, token, utils.ClientIP(r))
+		if err != nil {
+			respondError(w, "session check failed - "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if reused {
+			respondError(w, "refresh token reuse detected - session revoked", http.StatusUnauthorized)
+			return
+		}
 		respondError(w, "Invalid session", http.StatusUnauthorized)
 		return
 	}
 
+	if !h.checkNotDisabled(w, userID) {
+		return
+	}
+
 	// generate new token pair
 	newAccessToken, newRefreshToken, err := utils.GenerateTokenPair(
 		userID.String(),
-		[]byte(h.cfg.JWTSecret),
+		h.keys,
 		time.Duration(h.cfg.AccessTokenTTL)*time.Minute,
 		time.Duration(h.cfg.RefreshTokenTTL)*24*time.Hour,
 	)
@@ -141,8 +319,13 @@ func (h *AuthHandler) SilentLogin(w http.ResponseWriter, r *http.Request) {
 		deviceID,
 		token,
 		newRefreshToken,
+		sessionMetadata(r, ""),
 	); err != nil {
-		respondError(w, "session update failed - "+err.Error(), http.StatusInternalServerError)
+		status := http.StatusInternalServerError
+		if err == service.ErrInvalidSession {
+			status = http.StatusUnauthorized // rotated by a concurrent request
+		}
+		respondError(w, "session update failed - "+err.Error(), status)
 		return
 	}
 
@@ -175,9 +358,68 @@ func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// the access token would stay valid for its remaining ttl otherwise
+	tokenID, _ := r.Context().Value("tokenID").(string)
+	tokenExpiry, _ := r.Context().Value("tokenExpiry").(time.Time)
+	if err := h.revocationService.Revoke(tokenID, userID, tokenExpiry); err != nil {
+		respondError(w, "logout failed - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	respondJSON(w, http.StatusOK, map[string]string{"status": "logged out"})
 }
 
+// collect client information stored with a device session
+func sessionMetadata(r *http.Request, deviceName string) models.SessionMetadata {
+	return models.SessionMetadata{
+		DeviceName: truncate(deviceName, maxDeviceNameLength),
+		IP:         utils.ClientIP(r),
+		UserAgent:  truncate(r.UserAgent(), maxUserAgentLength),
+	}
+}
+
+// cut a string to a maximum byte length without splitting utf-8 characters
+func truncate(value string, maxLength int) string {
+	if len(value) <= maxLength {
+		return value
+	}
+	return strings.ToValidUTF8(value[:maxLength], "")
+}
+
+// limits for client supplied session metadata
+const (
+	maxDeviceNameLength = 64
+	maxUserAgentLength  = 256
+)
+
+// helper to send lockout responses with a retry-after header
+func respondTooManyAttempts(w http.ResponseWriter, retryAfter time.Duration) {
+	respondRateLimited(w, "too many failed attempts, try again later", retryAfter)
+}
+
+// helper to send 429 responses with a retry-after header (whole seconds, rounded up)
+func respondRateLimited(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondJSON(w, http.StatusTooManyRequests, map[string]interface{}{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// helper to send password policy violations, returns false if err is not a policy error
+func respondPasswordPolicyError(w http.ResponseWriter, err error) bool {
+	policyErr, ok := err.(*utils.PasswordPolicyError)
+	if !ok {
+		return false
+	}
+	respondJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":      policyErr.Error(),
+		"violations": policyErr.Violations,
+	})
+	return true
+}
+
 // helper to send json responses
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"backend/internal/models"
+	"backend/internal/service"
+
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gocql/gocql"
+)
+
+// DataExportHandler lets users download all personal data stored about them
+type DataExportHandler struct {
+	exportService *service.DataExportService
+}
+
+// NewDataExportHandler creates a new data export handler
+func NewDataExportHandler(exportService *service.DataExportService) *DataExportHandler {
+	return &DataExportHandler{
+		exportService: exportService,
+	}
+}
+
+// StartExport requests an export. small exports are returned as file right away,
+// otherwise the response is 202 with the export id to poll
+func (h *DataExportHandler) StartExport(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	req := struct {
+		Format string `json:"format"` // json (default) or zip
+	}{Format: models.ExportFormatJSON}
+
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respondError(w, "invalid request format", http.StatusBadRequest)
+			return
+		}
+	}
+
+	export, err := h.exportService.StartExport(userID, req.Format)
+	if err != nil {
+		respondError(w, "could not export data - "+err.Error(), dataExportErrorStatus(err))
+		return
+	}
+
+	if export.Status != models.ExportReady {
+		respondJSON(w, http.StatusAccepted, dataExportResponse(export))
+		return
+	}
+	h.sendExport(w, userID, export.ID)
+}
+
+// GetExports returns the state of one export (?id=) or all exports of the user
+func (h *DataExportHandler) GetExports(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		exportID, err := gocql.ParseUUID(id)
+		if err != nil {
+			respondError(w, "invalid export id - "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		export, err := h.exportService.GetExport(userID, exportID)
+		if err != nil {
+			respondError(w, err.Error(), dataExportErrorStatus(err))
+			return
+		}
+		respondJSON(w, http.StatusOK, dataExportResponse(export))
+		return
+	}
+
+	exports, err := h.exportService.ListExports(userID)
+	if err != nil {
+		respondError(w, "could not get exports - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(exports))
+	for _, export := range exports {
+		result = append(result, dataExportResponse(&export))
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"exports": result,
+	})
+}
+
+// Download returns the file of a ready export (?id=)
+func (h *DataExportHandler) Download(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	exportID, err := gocql.ParseUUID(r.URL.Query().Get("id"))
+	if err != nil {
+		respondError(w, "invalid export id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.sendExport(w, userID, exportID)
+}
+
+// write the export file as attachment
+func (h *DataExportHandler) sendExport(w http.ResponseWriter, userID, exportID gocql.UUID) {
+	export, data, err := h.exportService.GetExportData(userID, exportID)
+	if err != nil {
+		respondError(w, err.Error(), dataExportErrorStatus(err))
+		return
+	}
+
+	contentType := "application/json"
+	if export.Format == models.ExportFormatZIP {
+		contentType = "application/zip"
+	}
+
+	filename := "data-export-" + export.CreatedAt.Format("2006-01-02") + "." + export.Format
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("Cache-Control", "no-store") // personal data must not end up in shared caches
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// export state without internal fields
+func dataExportResponse(export *models.DataExport) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":         export.ID.String(),
+		"format":     export.Format,
+		"status":     export.Status,
+		"created_at": export.CreatedAt,
+		"expires_at": export.ExpiresAt,
+	}
+	if export.Status == models.ExportReady {
+		response["size"] = export.Size
+		response["download"] = "/auth/export/download?id=" + export.ID.String()
+	}
+	if !export.CompletedAt.IsZero() {
+		response["completed_at"] = export.CompletedAt
+	}
+	if export.Error != "" {
+		response["error"] = export.Error
+	}
+	return response
+}
+
+// map data export errors to http status codes
+func dataExportErrorStatus(err error) int {
+	switch err {
+	case service.ErrExportFormat:
+		return http.StatusBadRequest
+	case service.ErrExportNotFound:
+		return http.StatusNotFound
+	case service.ErrExportNotReady:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
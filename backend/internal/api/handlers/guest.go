@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/internal/service"
+	"backend/pkg/utils"
+
+	"encoding/json"
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// GuestLogin creates an anonymous account bound to the device and signs it in
+func (h *AuthHandler) GuestLogin(w http.ResponseWriter, r *http.Request) {
+	if !h.cfg.GuestAccountsEnabled {
+		respondError(w, service.ErrGuestAccountsDisabled.Error(), http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		DeviceID   string `json:"device_id"`
+		DeviceName string `json:"device_name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := gocql.ParseUUID(req.DeviceID)
+	if err != nil {
+		respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	account, retryAfter, err := h.guestService.CreateGuest(deviceID, utils.ClientIP(r))
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		respondRateLimited(w, "too many guest accounts created, try again later", retryAfter)
+		return
+	}
+
+	h.issueSession(w, r, account.ID, deviceID, req.DeviceName)
+}
+
+// ClaimGuest attaches an email and password to the signed in guest account, it keeps its scans and sessions
+func (h *AuthHandler) ClaimGuest(w http.ResponseWriter, r *http.Request) {
+	// get user ID from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	account, err := h.guestService.ClaimAccount(userID, req.Email, req.Password)
+	if respondPasswordPolicyError(w, err) {
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == service.ErrNotGuestAccount {
+			status = http.StatusConflict
+		} else if err == repository.ErrEmailExists || err.Error() == "invalid email format" {
+			status = http.StatusBadRequest // existing accounts are joined with /auth/guest/merge
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	// the account is claimed either way, the user can request a new link
+	mailErr := h.accountService.SendVerificationEmail(account)
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":            "account claimed",
+		"id":                account.ID.String(),
+		"email":             account.Email,
+		"verified":          account.Verified,
+		"verification_sent": mailErr == nil,
+	})
+}
+
+// MergeGuest moves the progress of the signed in guest into an existing account and signs the
+// device in to that account. the account is authenticated like a login (two factor code included)
+func (h *AuthHandler) MergeGuest(w http.ResponseWriter, r *http.Request) {
+	// get user ID from context
+	guestID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		Code         string `json:"code"`          // required if the account has an authenticator
+		RecoveryCode string `json:"recovery_code"` // used if no code is given
+		DeviceName   string `json:"device_name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, _ := gocql.ParseUUID(r.Header.Get("X-Device-ID")) // already validated by the auth middleware
+
+	// check before counting login attempts for nothing
+	guest, err := h.accountService.IsGuest(guestID)
+	if err != nil {
+		respondError(w, "account check failed - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !guest {
+		respondError(w, service.ErrNotGuestAccount.Error(), http.StatusConflict)
+		return
+	}
+
+	target, ok := h.authenticateMergeTarget(w, r, req.Email, req.Password, req.Code, req.RecoveryCode)
+	if !ok {
+		return
+	}
+
+	if _, err := h.guestService.MergeInto(guestID, target, utils.ClientIP(r)); err != nil {
+		status := http.StatusInternalServerError
+		if err == service.ErrNotGuestAccount {
+			status = http.StatusConflict // merged or claimed by a concurrent request
+		}
+		respondError(w, "merge failed - "+err.Error(), status)
+		return
+	}
+
+	// the guest session was deleted with the guest, the device continues with the account
+	h.issueSession(w, r, target, deviceID, req.DeviceName)
+}
+
+// check password and second factor of the account a guest is merged into, with the login lockouts.
+// returns false if the request was rejected
+func (h *AuthHandler) authenticateMergeTarget(w http.ResponseWriter, r *http.Request, email, password, code, recoveryCode string) (gocql.UUID, bool) {
+	ip := utils.ClientIP(r)
+	if !h.checkLockout(w, models.LockoutScopeAccount, email, ip) {
+		return gocql.UUID{}, false
+	}
+
+	account, err := h.accountService.Authenticate(email, password)
+	if err == service.ErrInvalidCredentials {
+		h.recordFailure(w, models.LockoutScopeAccount, email, ip, err)
+		return gocql.UUID{}, false
+	} else if err == service.ErrAccountDisabled || err == service.ErrAccountPendingDeletion {
+		respondError(w, err.Error(), http.StatusForbidden)
+		return gocql.UUID{}, false
+	} else if err != nil {
+		respondError(w, "authentication failed - "+err.Error(), http.StatusInternalServerError)
+		return gocql.UUID{}, false
+	}
+
+	if err := h.loginProtectionService.RecordSuccess(models.LockoutScopeAccount, email); err != nil {
+		respondError(w, "login tracking failed - "+err.Error(), http.StatusInternalServerError)
+		return gocql.UUID{}, false
+	}
+
+	twoFactor, err := h.twoFactorService.IsEnabled(account.ID)
+	if err != nil {
+		respondError(w, "two factor lookup failed - "+err.Error(), http.StatusInternalServerError)
+		return gocql.UUID{}, false
+	}
+	if !twoFactor {
+		return account.ID, true
+	}
+
+	// the merge is a single request, the code is sent along instead of a challenge round trip
+	if code == "" && recoveryCode == "" {
+		respondJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"error":               "two factor code required",
+			"two_factor_required": true,
+		})
+		return gocql.UUID{}, false
+	}
+
+	if !h.checkLockout(w, models.LockoutScopeTwoFactor, account.ID.String(), ip) {
+		return gocql.UUID{}, false
+	}
+	if err := h.twoFactorService.Verify(account.ID, code, recoveryCode); err != nil {
+		if err == service.ErrInvalidTwoFactorCode || err == service.ErrTwoFactorNotEnrolled {
+			h.recordFailure(w, models.LockoutScopeTwoFactor, account.ID.String(), ip, err)
+			return gocql.UUID{}, false
+		}
+		respondError(w, "authentication failed - "+err.Error(), http.StatusInternalServerError)
+		return gocql.UUID{}, false
+	}
+
+	if err := h.loginProtectionService.RecordSuccess(models.LockoutScopeTwoFactor, account.ID.String()); err != nil {
+		respondError(w, "login tracking failed - "+err.Error(), http.StatusInternalServerError)
+		return gocql.UUID{}, false
+	}
+	return account.ID, true
+}
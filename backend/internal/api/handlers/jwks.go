@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"backend/pkg/utils"
+
+	"net/http"
+)
+
+// JWKSHandler publishes the public token and qr code verification keys
+type JWKSHandler struct {
+	keys   *utils.KeySet
+	qrKeys *utils.QRKeySet
+}
+
+// NewJWKSHandler creates a new jwks handler
+func NewJWKSHandler(keys *utils.KeySet, qrKeys *utils.QRKeySet) *JWKSHandler {
+	return &JWKSHandler{
+		keys:   keys,
+		qrKeys: qrKeys,
+	}
+}
+
+// JWKS returns all keys that tokens may currently be signed with (rfc 7517)
+func (h *JWKSHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	// verifiers cache the document, a rotated key has to be published before it signs tokens
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": h.keys.PublicJWKs(),
+	})
+}
+
+// QRKeys returns the keys qr payloads may be signed with, clients use them to reject forged codes offline
+func (h *JWKSHandler) QRKeys(w http.ResponseWriter, r *http.Request) {
+	// offline clients keep the document, a new key has to be published before it signs codes
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": h.qrKeys.PublicJWKs(),
+	})
+}
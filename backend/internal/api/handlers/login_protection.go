@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"backend/internal/models"
+	"backend/internal/service"
+	"backend/pkg/utils"
+
+	"encoding/json"
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// LoginProtectionHandler manages login lockouts (admin only)
+type LoginProtectionHandler struct {
+	loginProtectionService *service.LoginProtectionService
+}
+
+// NewLoginProtectionHandler creates a new login protection handler
+func NewLoginProtectionHandler(loginProtectionService *service.LoginProtectionService) *LoginProtectionHandler {
+	return &LoginProtectionHandler{
+		loginProtectionService: loginProtectionService,
+	}
+}
+
+// Unlock lifts the lockout of an account (by email) or an ip address
+func (h *LoginProtectionHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	adminID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	scope, key := models.LockoutScopeAccount, req.Email
+	if req.IP != "" {
+		scope, key = models.LockoutScopeIP, req.IP
+	}
+	if key == "" {
+		respondError(w, "email or ip required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.loginProtectionService.Unlock(scope, key, adminID, utils.ClientIP(r)); err != nil {
+		respondError(w, "could not unlock - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": scope + " unlocked"})
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"backend/internal/service"
+	"backend/pkg/utils"
+
+	"encoding/json"
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// RequestMagicLink mails a one-time sign-in link for the device, the response is the same whether the account exists or not
+func (h *AuthHandler) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email      string `json:"email"`
+		DeviceID   string `json:"device_id"`   // only this device can redeem the link
+		DeviceName string `json:"device_name"` // optional user assigned name
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := gocql.ParseUUID(req.DeviceID)
+	if err != nil {
+		respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !utils.ValidateEmail(req.Email) {
+		respondError(w, "invalid email format", http.StatusBadRequest)
+		return
+	}
+
+	retryAfter, err := h.magicLinkService.RequestLink(req.Email, deviceID, truncate(req.DeviceName, maxDeviceNameLength), utils.ClientIP(r))
+	if err != nil {
+		respondError(w, "could not send sign-in link - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if retryAfter > 0 {
+		respondRateLimited(w, "too many sign-in links requested, try again later", retryAfter)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{
+		"status": "if an account exists for this email, a sign-in link has been sent",
+	})
+}
+
+// RedeemMagicLink signs the requesting device in with a link token (token from the query or json body).
+// only POST is accepted, mail scanners that prefetch links must not use them up
+func (h *AuthHandler) RedeemMagicLink(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		DeviceID string `json:"device_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		req.Token = token
+	}
+	if req.Token == "" {
+		respondError(w, "token required", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := gocql.ParseUUID(req.DeviceID)
+	if err != nil {
+		respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	link, err := h.magicLinkService.RedeemLink(req.Token, deviceID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == service.ErrInvalidMagicLink || err == service.ErrMagicLinkDevice {
+			status = http.StatusUnauthorized
+		}
+		respondError(w, "authentication failed - "+err.Error(), status)
+		return
+	}
+
+	// the link replaces the password, an authenticator is still required
+	h.completeLogin(w, r, link.UserID, deviceID, link.DeviceName)
+}
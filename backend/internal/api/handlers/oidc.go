@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"backend/internal/service"
+
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// OIDCProviders lists the identity providers available for login
+func (h *AuthHandler) OIDCProviders(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"providers": h.oidcService.Providers(),
+	})
+}
+
+// OIDCStart redirects the user to the identity provider (?provider=&device_id=&device_name=)
+func (h *AuthHandler) OIDCStart(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	deviceID, err := gocql.ParseUUID(query.Get("device_id"))
+	if err != nil {
+		respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authURL, err := h.oidcService.StartLogin(query.Get("provider"), deviceID, truncate(query.Get("device_name"), maxDeviceNameLength))
+	if err == service.ErrUnknownOIDCProvider {
+		respondError(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		respondError(w, "could not start login - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes the provider login and responds like Login
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// the provider reports denied consent or its own failures as error parameters
+	if providerError := query.Get("error"); providerError != "" {
+		respondError(w, "identity provider error - "+providerError+" "+query.Get("error_description"), http.StatusUnauthorized)
+		return
+	}
+
+	login, err := h.oidcService.FinishLogin(query.Get("state"), query.Get("code"))
+	if err != nil {
+		respondError(w, "login failed - "+err.Error(), oidcErrorStatus(err))
+		return
+	}
+
+	h.completeLogin(w, r, login.UserID, login.DeviceID, login.DeviceName)
+}
+
+// map oidc errors to http status codes
+func oidcErrorStatus(err error) int {
+	switch err {
+	case service.ErrInvalidOIDCState, service.ErrOIDCEmailNotVerified:
+		return http.StatusUnauthorized
+	case service.ErrUnknownOIDCProvider:
+		return http.StatusNotFound
+	case service.ErrOIDCAccountUnverified:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"backend/internal/repository"
+	"backend/internal/service"
+
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// PasskeySignupStart returns registration options for a new passwordless account
+func (h *AuthHandler) PasskeySignupStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email      string `json:"email"`
+		DeviceID   string `json:"device_id"`
+		DeviceName string `json:"device_name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := gocql.ParseUUID(req.DeviceID)
+	if err != nil {
+		respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options, ceremonyID, err := h.passkeyService.BeginSignup(req.Email, deviceID, truncate(req.DeviceName, maxDeviceNameLength))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrEmailExists || err.Error() == "invalid email format" {
+			status = http.StatusBadRequest
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// PasskeySignupFinish creates the account from the authenticator response (?ceremony_id=) and signs it in
+func (h *AuthHandler) PasskeySignupFinish(w http.ResponseWriter, r *http.Request) {
+	account, login, err := h.passkeyService.FinishSignup(r.URL.Query().Get("ceremony_id"), r)
+	if err != nil {
+		status := passkeyErrorStatus(err)
+		if err == repository.ErrEmailExists {
+			status = http.StatusBadRequest // registered by someone else while the ceremony was running
+		}
+		respondError(w, "signup failed - "+err.Error(), status)
+		return
+	}
+
+	// the passkey proves possession of the device, not of the email address
+	h.accountService.SendVerificationEmail(account)
+
+	h.issueSession(w, r, login.UserID, login.DeviceID, login.DeviceName)
+}
+
+// PasskeyLoginStart returns assertion options for a usernameless login
+func (h *AuthHandler) PasskeyLoginStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		DeviceID   string `json:"device_id"`
+		DeviceName string `json:"device_name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := gocql.ParseUUID(req.DeviceID)
+	if err != nil {
+		respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	options, ceremonyID, err := h.passkeyService.BeginLogin(deviceID, truncate(req.DeviceName, maxDeviceNameLength))
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// PasskeyLoginFinish verifies the assertion (?ceremony_id=) and responds like Login
+func (h *AuthHandler) PasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	login, err := h.passkeyService.FinishLogin(r.URL.Query().Get("ceremony_id"), r)
+	if err != nil {
+		respondError(w, "login failed - "+err.Error(), passkeyErrorStatus(err))
+		return
+	}
+
+	// user verification on the authenticator is a second factor, no totp challenge
+	h.issueSession(w, r, login.UserID, login.DeviceID, login.DeviceName)
+}
+
+// PasskeyRegisterStart returns registration options for an additional passkey
+func (h *AuthHandler) PasskeyRegisterStart(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	options, ceremonyID, err := h.passkeyService.BeginRegistration(userID)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// PasskeyRegisterFinish stores the new passkey (?ceremony_id=&name=)
+func (h *AuthHandler) PasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	passkey, err := h.passkeyService.FinishRegistration(userID, query.Get("ceremony_id"), truncate(query.Get("name"), maxDeviceNameLength), r)
+	if err != nil {
+		respondError(w, "registration failed - "+err.Error(), passkeyErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":         base64.RawURLEncoding.EncodeToString(passkey.CredentialID),
+		"name":       passkey.Name,
+		"created_at": passkey.CreatedAt,
+	})
+}
+
+// ListPasskeys returns the passkeys of the user
+func (h *AuthHandler) ListPasskeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	passkeys, err := h.passkeyService.ListPasskeys(userID)
+	if err != nil {
+		respondError(w, "could not get passkeys - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		result = append(result, map[string]interface{}{
+			"id":              base64.RawURLEncoding.EncodeToString(passkey.CredentialID),
+			"name":            passkey.Name,
+			"transports":      passkey.Transports,
+			"backup_eligible": passkey.BackupEligible,
+			"backed_up":       passkey.BackupState,
+			"blocked":         passkey.CloneWarning,
+			"created_at":      passkey.CreatedAt,
+			"last_used_at":    passkey.LastUsedAt,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"passkeys": result,
+	})
+}
+
+// RenamePasskey changes the label of a passkey
+func (h *AuthHandler) RenamePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(req.ID)
+	if err != nil || strings.TrimSpace(req.Name) == "" {
+		respondError(w, "id and name are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.passkeyService.RenamePasskey(userID, credentialID, truncate(req.Name, maxDeviceNameLength)); err != nil {
+		respondError(w, err.Error(), passkeyErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "passkey renamed"})
+}
+
+// DeletePasskey removes a passkey of the user
+func (h *AuthHandler) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	credentialID, err := base64.RawURLEncoding.DecodeString(req.ID)
+	if err != nil {
+		respondError(w, "invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.passkeyService.DeletePasskey(userID, credentialID); err != nil {
+		respondError(w, err.Error(), passkeyErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "passkey deleted"})
+}
+
+// map passkey errors to http status codes
+func passkeyErrorStatus(err error) int {
+	switch err {
+	case service.ErrInvalidCeremony, service.ErrPasskeyVerification, service.ErrPasskeyBlocked:
+		return http.StatusUnauthorized
+	case repository.ErrPasskeyNotFound:
+		return http.StatusNotFound
+	case repository.ErrPasskeyExists, service.ErrLastPasskey:
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"backend/internal/service"
+
+	"encoding/json"
+	"net/http"
+)
+
+// PasswordResetHandler handles forgotten password endpoints
+type PasswordResetHandler struct {
+	resetService *service.PasswordResetService
+}
+
+// NewPasswordResetHandler creates a new password reset handler
+func NewPasswordResetHandler(resetService *service.PasswordResetService) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		resetService: resetService,
+	}
+}
+
+// ForgotPassword sends a reset link to the given email
+func (h *PasswordResetHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.resetService.RequestReset(req.Email); err != nil {
+		respondError(w, "could not request password reset - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// same response whether or not the account exists
+	respondJSON(w, http.StatusOK, map[string]string{"status": "if an account exists for this email a reset link has been sent"})
+}
+
+// ResetPassword sets a new password using a reset token (token from query or body)
+func (h *PasswordResetHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		req.Token = r.URL.Query().Get("token")
+	}
+	if req.Token == "" {
+		respondError(w, "token required", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.resetService.ResetPassword(req.Token, req.NewPassword); err != nil {
+		if respondPasswordPolicyError(w, err) {
+			return
+		}
+		status := http.StatusInternalServerError
+		if err == service.ErrInvalidResetToken {
+			status = http.StatusBadRequest
+		}
+		respondError(w, err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "password reset"})
+}
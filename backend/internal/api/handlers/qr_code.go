@@ -29,15 +29,25 @@ func (h *QRCodeHandler) GetQRAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// qr_code_id from query params
-	qr_code_id, err := gocql.ParseUUID(r.URL.Query().Get("qr_code_id"))
-	if err != nil {
-		respondError(w, "invalid qr_code_id - "+err.Error(), http.StatusBadRequest)
-		return
+	// signed payload (or the plain qr_code_id of codes printed before signing) from query params
+	var qr_code_action string
+	var err error
+	if payload := r.URL.Query().Get("payload"); payload != "" {
+		qr_code_action, err = h.qr_service.GetActionJsonFromPayload(payload, user_id)
+	} else {
+		qr_code_id, parseErr := gocql.ParseUUID(r.URL.Query().Get("qr_code_id"))
+		if parseErr != nil {
+			respondError(w, "invalid qr_code_id - "+parseErr.Error(), http.StatusBadRequest)
+			return
+		}
+		qr_code_action, err = h.qr_service.GetActionJsonFromUnsignedId(qr_code_id, user_id)
 	}
-
-	qr_code_action, err := h.qr_service.GetActionJsonFromQRCodeId(qr_code_id, user_id)
-	if err != nil {
+	if err == service.ErrQRCodeBusy {
+		// the limit was not reached, the scan only lost too often against concurrent ones
+		w.Header().Set("Retry-After", "1")
+		respondError(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
 		respondError(w, "could not get qr code action - "+err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"backend/internal/models"
+	"backend/pkg/qrcode"
+
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// limits for print jobs
+const (
+	maxPrintCodes      = 1000
+	maxPrintGrid       = 20
+	maxPrintMarginMM   = 50
+	minCutMarkMarginMM = 5
+)
+
+// codes of a print job and the options shared by sheets and archives
+type printRequest struct {
+	QrCodeIds []string `json:"qr_code_ids"` // printed in this order
+	ActionId  string   `json:"action_id"`   // all unexpired codes of the action
+	ECC       string   `json:"ecc"`         // error correction level (default M)
+	QuietZone *int     `json:"quiet_zone"`  // modules (default 4)
+	Labels    *bool    `json:"labels"`      // default true
+}
+
+// PrintSheet renders codes as print-ready pdf with a grid layout, labels and cut marks
+func (h *QRCodeManagementHandler) PrintSheet(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		printRequest
+		PageSize  string  `json:"page_size"` // a3, a4 (default), a5, letter or legal
+		Landscape bool    `json:"landscape"`
+		Columns   int     `json:"columns"`   // default 3
+		Rows      int     `json:"rows"`      // default 4
+		MarginMM  float64 `json:"margin_mm"` // default 10
+		CutMarks  *bool   `json:"cut_marks"` // default true
+	}
+	req.Columns, req.Rows, req.MarginMM, req.PageSize = 3, 4, 10, "a4"
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	codes, level, quietZone, ok := h.parsePrintRequest(w, &req.printRequest)
+	if !ok {
+		return
+	}
+
+	width, height, err := qrcode.PageSize(req.PageSize, req.Landscape)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Columns < 1 || req.Columns > maxPrintGrid || req.Rows < 1 || req.Rows > maxPrintGrid {
+		respondError(w, "columns and rows must be between 1 and "+strconv.Itoa(maxPrintGrid), http.StatusBadRequest)
+		return
+	}
+	if req.MarginMM < 0 || req.MarginMM > maxPrintMarginMM {
+		respondError(w, "margin_mm must be between 0 and "+strconv.Itoa(maxPrintMarginMM), http.StatusBadRequest)
+		return
+	}
+	cutMarks := req.CutMarks == nil || *req.CutMarks
+	if cutMarks && req.MarginMM < minCutMarkMarginMM {
+		respondError(w, "cut marks need a margin of at least "+strconv.Itoa(minCutMarkMarginMM)+" mm", http.StatusBadRequest)
+		return
+	}
+
+	layout := qrcode.SheetLayout{
+		PageWidth:  width,
+		PageHeight: height,
+		Columns:    req.Columns,
+		Rows:       req.Rows,
+		Margin:     qrcode.MM(req.MarginMM),
+		QuietZone:  quietZone,
+		CutMarks:   cutMarks,
+	}
+
+	data, err := h.qr_service.RenderPrintSheet(codes, level, layout, req.Labels == nil || *req.Labels)
+	if err == qrcode.ErrSheetTooDense {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		respondError(w, "could not render print sheet - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sendPrintFile(w, data, "application/pdf", "pdf")
+}
+
+// ImageArchive renders codes as zip of png or svg files (labels put the short code below each code)
+func (h *QRCodeManagementHandler) ImageArchive(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		printRequest
+		Format string `json:"format"` // png (default) or svg
+		Size   int    `json:"size"`   // pixels (default 512)
+		FG     string `json:"fg"`     // rrggbb or rrggbbaa (default 000000)
+		BG     string `json:"bg"`     // default ffffff
+	}
+	req.Format, req.Size, req.FG, req.BG = "png", defaultQRImageSize, "000000", "ffffff"
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	codes, level, quietZone, ok := h.parsePrintRequest(w, &req.printRequest)
+	if !ok {
+		return
+	}
+
+	if req.Format != "png" && req.Format != "svg" {
+		respondError(w, "invalid format, use png or svg", http.StatusBadRequest)
+		return
+	}
+	if req.Size < minQRImageSize || req.Size > maxQRImageSize {
+		respondError(w, "invalid size, must be between "+strconv.Itoa(minQRImageSize)+" and "+strconv.Itoa(maxQRImageSize), http.StatusBadRequest)
+		return
+	}
+
+	style := qrcode.Style{Size: req.Size, QuietZone: quietZone}
+	var err error
+	if style.Foreground, err = qrcode.ParseColor(req.FG); err != nil {
+		respondError(w, "invalid fg - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if style.Background, err = qrcode.ParseColor(req.BG); err != nil {
+		respondError(w, "invalid bg - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.qr_service.RenderImageArchive(codes, req.Format, level, style, req.Labels == nil || *req.Labels)
+	if err != nil {
+		// the options are the same for every code, a too small size fails on the first one
+		respondError(w, "could not render images - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sendPrintFile(w, data, "application/zip", "zip")
+}
+
+// resolve the codes and shared options of a print job, responds with an error if they are invalid
+func (h *QRCodeManagementHandler) parsePrintRequest(w http.ResponseWriter, req *printRequest) ([]models.QRCode, qrcode.Level, int, bool) {
+	level := qrcode.LevelM
+	if req.ECC != "" {
+		var err error
+		if level, err = qrcode.ParseLevel(req.ECC); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return nil, 0, 0, false
+		}
+	}
+
+	quietZone := 4
+	if req.QuietZone != nil {
+		if *req.QuietZone < 0 || *req.QuietZone > maxQRQuietZone {
+			respondError(w, "quiet_zone must be between 0 and "+strconv.Itoa(maxQRQuietZone), http.StatusBadRequest)
+			return nil, 0, 0, false
+		}
+		quietZone = *req.QuietZone
+	}
+
+	ids := make([]gocql.UUID, 0, len(req.QrCodeIds))
+	for _, value := range req.QrCodeIds {
+		id, err := gocql.ParseUUID(value)
+		if err != nil {
+			respondError(w, "invalid qr_code_id "+value, http.StatusBadRequest)
+			return nil, 0, 0, false
+		}
+		ids = append(ids, id)
+	}
+
+	var action_id *gocql.UUID
+	if req.ActionId != "" {
+		id, err := gocql.ParseUUID(req.ActionId)
+		if err != nil {
+			respondError(w, "invalid action_id", http.StatusBadRequest)
+			return nil, 0, 0, false
+		}
+		action_id = &id
+	}
+
+	codes, err := h.qr_service.GetQRCodesForPrint(ids, action_id, maxPrintCodes)
+	if err != nil {
+		respondError(w, "could not select qr codes - "+err.Error(), http.StatusBadRequest)
+		return nil, 0, 0, false
+	}
+	return codes, level, quietZone, true
+}
+
+// write a print job result as attachment
+func sendPrintFile(w http.ResponseWriter, data []byte, contentType, extension string) {
+	filename := "qr-codes-" + time.Now().UTC().Format("2006-01-02") + "." + extension
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"backend/pkg/qrcode"
+
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/mux"
+)
+
+// limits for rendered qr images
+const (
+	defaultQRImageSize = 512
+	minQRImageSize     = 64
+	maxQRImageSize     = 2048
+	maxQRQuietZone     = 16
+	maxQRCaptionLength = 64
+	maxQRLogoBytes     = 1 << 20
+)
+
+// GetQRCodeImage renders the signed payload of a qr code as image (/qr-mgmt/codes/{id}/image).
+// query options: format (png, svg), size (pixels), quiet_zone (modules), ecc (L, M, Q, H),
+// fg and bg (rrggbb or rrggbbaa) and caption. a POST body is drawn as centered logo (png, jpeg or gif)
+func (h *QRCodeManagementHandler) GetQRCodeImage(w http.ResponseWriter, r *http.Request) {
+	qr_code_id, err := gocql.ParseUUID(mux.Vars(r)["id"])
+	if err != nil {
+		respondError(w, "invalid qr code id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		format = "png"
+	}
+	if format != "png" && format != "svg" {
+		respondError(w, "invalid format, use png or svg", http.StatusBadRequest)
+		return
+	}
+
+	style := qrcode.Style{Size: defaultQRImageSize, QuietZone: 4, Caption: query.Get("caption")}
+	if !parseIntOption(w, query.Get("size"), "size", minQRImageSize, maxQRImageSize, &style.Size) ||
+		!parseIntOption(w, query.Get("quiet_zone"), "quiet_zone", 0, maxQRQuietZone, &style.QuietZone) {
+		return
+	}
+	if utf8.RuneCountInString(style.Caption) > maxQRCaptionLength {
+		respondError(w, "caption too long, at most "+strconv.Itoa(maxQRCaptionLength)+" characters", http.StatusBadRequest)
+		return
+	}
+
+	if style.Foreground, err = qrcode.ParseColor(stringOr(query.Get("fg"), "000000")); err != nil {
+		respondError(w, "invalid fg - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if style.Background, err = qrcode.ParseColor(stringOr(query.Get("bg"), "ffffff")); err != nil {
+		respondError(w, "invalid bg - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPost && r.ContentLength != 0 {
+		style.Logo, err = qrcode.DecodeLogo(http.MaxBytesReader(w, r.Body, maxQRLogoBytes))
+		if err != nil {
+			respondError(w, "invalid logo - "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// the logo hides modules, only the higher levels restore them reliably
+	level := qrcode.LevelM
+	if style.Logo != nil {
+		level = qrcode.LevelH
+	}
+	if query.Get("ecc") != "" {
+		if level, err = qrcode.ParseLevel(query.Get("ecc")); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if style.Logo != nil && level < qrcode.LevelQ {
+		respondError(w, "a logo needs error correction level Q or H", http.StatusBadRequest)
+		return
+	}
+
+	data, err := h.qr_service.RenderQRCodeImage(qr_code_id, format, level, style)
+	if err != nil {
+		respondError(w, "could not render qr code - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType := "image/png"
+	if format == "svg" {
+		contentType = "image/svg+xml"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `inline; filename="qr-`+qr_code_id.String()+`.`+format+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// parse an optional integer query option inside [low, high], responds with an error if it is invalid
+func parseIntOption(w http.ResponseWriter, value, name string, low, high int, target *int) bool {
+	if value == "" {
+		return true
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < low || parsed > high {
+		respondError(w, "invalid "+name+", must be between "+strconv.Itoa(low)+" and "+strconv.Itoa(high), http.StatusBadRequest)
+		return false
+	}
+	*target = parsed
+	return true
+}
+
+func stringOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
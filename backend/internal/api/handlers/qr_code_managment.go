@@ -2,9 +2,11 @@ package handlers
 
 import (
 	"backend/internal/models"
-	"backend/internal/repository"
 	"backend/internal/service"
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"strconv"
 	"time"
 
@@ -13,50 +15,21 @@ import (
 	"github.com/gocql/gocql"
 )
 
-// Debughandler manages debug endpoints
+// QRCodeManagementHandler manages qr code and action administration (permissions are checked by the router middleware)
 type QRCodeManagementHandler struct {
-	qr_service   *service.QRService
-	account_repo *repository.AccountRepository
+	qr_service *service.QRService
 }
 
 // NewAuthHandler creates a new auth handler
-func NewQRCodeManagementHandler(qr_service *service.QRService, account_repo *repository.AccountRepository) *QRCodeManagementHandler {
+func NewQRCodeManagementHandler(qr_service *service.QRService) *QRCodeManagementHandler {
 	return &QRCodeManagementHandler{
-		qr_service:   qr_service,
-		account_repo: account_repo,
+		qr_service: qr_service,
 	}
 }
 
-func (h *QRCodeManagementHandler) ValidateAdmin(w http.ResponseWriter, r *http.Request) bool {
-	// get user id from context
-	user_id, ok := r.Context().Value("userID").(gocql.UUID)
-	if !ok {
-		respondError(w, "authentication required", http.StatusUnauthorized)
-		return true
-	}
-
-	user_account, err := h.account_repo.GetAccountByID(user_id)
-	if err != nil || user_account == nil {
-		respondError(w, "could not find user account - "+err.Error(), http.StatusUnauthorized)
-		return true
-	}
-
-	if !user_account.Admin {
-		respondError(w, "admin privileges required", http.StatusForbidden)
-		return true
-	}
-
-	return false
-}
-
 // -------------------------------------- HANDLERS -----------------------------------------------
 
 func (h *QRCodeManagementHandler) AddQRCode(w http.ResponseWriter, r *http.Request) {
-	blocked := h.ValidateAdmin(w, r)
-	if blocked {
-		return
-	}
-
 	var req struct {
 		ActionID   string `json:"action_id"`
 		QrCodeType int    `json:"qr_code_type"`
@@ -87,17 +60,120 @@ func (h *QRCodeManagementHandler) AddQRCode(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	payload, err := h.qr_service.SignQRCode(qr_code)
+	if err != nil {
+		respondError(w, "could not sign qr code - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	respondJSON(w, http.StatusCreated, map[string]interface{}{
 		"qr_code_id": qr_code.ID,
+		"payload":    payload, // content to print, verified before the database is queried
 	})
 }
 
-func (h *QRCodeManagementHandler) AddQRAction(w http.ResponseWriter, r *http.Request) {
-	blocked := h.ValidateAdmin(w, r)
-	if blocked {
+// AddQRCodeBatch creates many codes for one action and returns their payloads as json or csv manifest.
+// retries with the same batch_id return the first manifest (200 instead of 201)
+func (h *QRCodeManagementHandler) AddQRCodeBatch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BatchID    string `json:"batch_id"` // client generated uuid, makes retries safe
+		ActionID   string `json:"action_id"`
+		QrCodeType int    `json:"qr_code_type"`
+		MaxUsages  int    `json:"max_usages"`  // 0 = unlimited
+		ExpireMins int    `json:"expire_mins"` // required for bulk codes
+		Count      int    `json:"count"`
+		Format     string `json:"format"` // manifest format, json (default) or csv
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	batch_id, err := gocql.ParseUUID(req.BatchID)
+	if err != nil {
+		respondError(w, "invalid batch_id", http.StatusBadRequest)
+		return
+	}
+	action_id, err := gocql.ParseUUID(req.ActionID)
+	if err != nil {
+		respondError(w, "invalid action_id", http.StatusBadRequest)
+		return
+	}
+	if req.ExpireMins < 1 {
+		respondError(w, "expire_mins must be at least 1", http.StatusBadRequest)
+		return
+	}
+	if req.Format == "" {
+		req.Format = "json"
+	}
+	if req.Format != "json" && req.Format != "csv" {
+		respondError(w, "invalid format, use json or csv", http.StatusBadRequest)
+		return
+	}
+
+	batch, created, err := h.qr_service.AddQRCodeBatch(batch_id, action_id, models.QRCodeUsageType(req.QrCodeType), req.MaxUsages, req.ExpireMins, req.Count)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrQRBatchSize) || err == service.ErrQRActionNotFound {
+			status = http.StatusBadRequest
+		} else if err == service.ErrQRBatchConflict {
+			status = http.StatusConflict
+		}
+		respondError(w, "could not add qr codes - "+err.Error(), status)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	// payloads are signed again on retries, signatures are deterministic for the same key
+	codes := batch.Codes()
+	payloads := make([]string, len(codes))
+	for i := range codes {
+		if payloads[i], err = h.qr_service.SignQRCode(&codes[i]); err != nil {
+			respondError(w, "could not sign qr code - "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.Format == "csv" {
+		var buffer bytes.Buffer
+		writer := csv.NewWriter(&buffer)
+		writer.Write([]string{"qr_code_id", "payload", "expires_at"})
+		for i, qr_code := range codes {
+			writer.Write([]string{qr_code.ID.String(), payloads[i], qr_code.ExpiresAt.UTC().Format(time.RFC3339)})
+		}
+		writer.Flush()
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="qr-batch-`+batch.ID.String()+`.csv"`)
+		w.WriteHeader(status)
+		w.Write(buffer.Bytes())
 		return
 	}
 
+	manifest := make([]map[string]interface{}, len(codes))
+	for i, qr_code := range codes {
+		manifest[i] = map[string]interface{}{
+			"qr_code_id": qr_code.ID,
+			"payload":    payloads[i],
+		}
+	}
+
+	respondJSON(w, status, map[string]interface{}{
+		"batch_id":   batch.ID,
+		"action_id":  batch.ActionId,
+		"count":      len(codes),
+		"expires_at": batch.ExpiresAt,
+		"created_at": batch.CreatedAt,
+		"codes":      manifest,
+	})
+}
+
+func (h *QRCodeManagementHandler) AddQRAction(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		ActionJson string `json:"action_json"`
 	}
@@ -119,11 +195,6 @@ func (h *QRCodeManagementHandler) AddQRAction(w http.ResponseWriter, r *http.Req
 }
 
 func (h *QRCodeManagementHandler) DeleteQRCode(w http.ResponseWriter, r *http.Request) {
-	blocked := h.ValidateAdmin(w, r)
-	if blocked {
-		return
-	}
-
 	var req struct {
 		QrCodeId string `json:"qr_code_id"`
 	}
@@ -150,11 +221,6 @@ func (h *QRCodeManagementHandler) DeleteQRCode(w http.ResponseWriter, r *http.Re
 }
 
 func (h *QRCodeManagementHandler) DeleteQRAction(w http.ResponseWriter, r *http.Request) {
-	blocked := h.ValidateAdmin(w, r)
-	if blocked {
-		return
-	}
-
 	var req struct {
 		QrActionId string `json:"qr_action_id"`
 	}
@@ -181,11 +247,6 @@ func (h *QRCodeManagementHandler) DeleteQRAction(w http.ResponseWriter, r *http.
 }
 
 func (h *QRCodeManagementHandler) GetAllQRActions(w http.ResponseWriter, r *http.Request) {
-	blocked := h.ValidateAdmin(w, r)
-	if blocked {
-		return
-	}
-
 	max_count, err := strconv.Atoi(r.URL.Query().Get("count"))
 	if err != nil {
 		respondError(w, "invalid count", http.StatusBadRequest)
@@ -215,11 +276,6 @@ func (h *QRCodeManagementHandler) GetAllQRActions(w http.ResponseWriter, r *http
 }
 
 func (h *QRCodeManagementHandler) GetAllQRCodes(w http.ResponseWriter, r *http.Request) {
-	blocked := h.ValidateAdmin(w, r)
-	if blocked {
-		return
-	}
-
 	max_count, err := strconv.Atoi(r.URL.Query().Get("count"))
 	if err != nil {
 		respondError(w, "invalid count", http.StatusBadRequest)
@@ -247,3 +303,23 @@ func (h *QRCodeManagementHandler) GetAllQRCodes(w http.ResponseWriter, r *http.R
 
 	respondJSON(w, http.StatusOK, result)
 }
+
+// GetQRCodePayload returns the signed payload of a qr code (?qr_code_id=), e.g. to reprint it after a key rotation
+func (h *QRCodeManagementHandler) GetQRCodePayload(w http.ResponseWriter, r *http.Request) {
+	qr_code_id, err := gocql.ParseUUID(r.URL.Query().Get("qr_code_id"))
+	if err != nil {
+		respondError(w, "invalid qr_code_id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payload, err := h.qr_service.GetQRCodePayload(qr_code_id)
+	if err != nil {
+		respondError(w, "could not sign qr code - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"qr_code_id": qr_code_id,
+		"payload":    payload,
+	})
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"backend/internal/models"
+	"backend/internal/service"
+
+	"encoding/json"
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// RoleHandler manages role assignments and permission lookups
+type RoleHandler struct {
+	authorizationService *service.AuthorizationService
+}
+
+// NewRoleHandler creates a new role handler
+func NewRoleHandler(authorizationService *service.AuthorizationService) *RoleHandler {
+	return &RoleHandler{
+		authorizationService: authorizationService,
+	}
+}
+
+// MyPermissions returns the roles and permissions of the authenticated user
+func (h *RoleHandler) MyPermissions(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	h.respondUserRoles(w, userID)
+}
+
+// ListRoles returns every role with the permissions it grants
+func (h *RoleHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, models.RolePermissions)
+}
+
+// UserRoles returns the roles and permissions of any user
+func (h *RoleHandler) UserRoles(w http.ResponseWriter, r *http.Request) {
+	userID, err := gocql.ParseUUID(r.URL.Query().Get("user_id"))
+	if err != nil {
+		respondError(w, "invalid user_id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.respondUserRoles(w, userID)
+}
+
+// GrantRole assigns a role to a user
+func (h *RoleHandler) GrantRole(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	adminID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	userID, role, ok := parseRoleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.authorizationService.GrantRole(userID, role, adminID); err != nil {
+		respondError(w, "could not grant role - "+err.Error(), roleErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "role granted"})
+}
+
+// RevokeRole removes a role from a user
+func (h *RoleHandler) RevokeRole(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	adminID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	userID, role, ok := parseRoleRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// prevents the last role manager from locking everyone out
+	if userID == adminID {
+		respondError(w, "cannot revoke your own roles", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authorizationService.RevokeRole(userID, role); err != nil {
+		respondError(w, "could not revoke role - "+err.Error(), roleErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "role revoked"})
+}
+
+// write the roles and resolved permissions of a user
+func (h *RoleHandler) respondUserRoles(w http.ResponseWriter, userID gocql.UUID) {
+	roles, err := h.authorizationService.GetRoles(userID)
+	if err != nil {
+		respondError(w, "could not get roles - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	permissions, err := h.authorizationService.GetPermissions(userID)
+	if err != nil {
+		respondError(w, "could not get permissions - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	roleNames := make([]models.Role, 0, len(roles))
+	for _, role := range roles {
+		roleNames = append(roleNames, role.Role)
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"user_id":     userID.String(),
+		"roles":       roleNames,
+		"permissions": permissions,
+	})
+}
+
+// parse the user and role of grant and revoke requests
+func parseRoleRequest(w http.ResponseWriter, r *http.Request) (gocql.UUID, models.Role, bool) {
+	var req struct {
+		UserID string `json:"user_id"`
+		Role   string `json:"role"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return gocql.UUID{}, "", false
+	}
+
+	userID, err := gocql.ParseUUID(req.UserID)
+	if err != nil {
+		respondError(w, "invalid user_id - "+err.Error(), http.StatusBadRequest)
+		return gocql.UUID{}, "", false
+	}
+
+	return userID, models.Role(req.Role), true
+}
+
+// map role errors to http status codes
+func roleErrorStatus(err error) int {
+	switch err {
+	case service.ErrUnknownRole:
+		return http.StatusBadRequest
+	case service.ErrAccountNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
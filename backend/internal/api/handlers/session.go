@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"backend/internal/repository"
+	"backend/internal/service"
+
+	"encoding/json"
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// SessionHandler manages the device sessions of the authenticated user
+type SessionHandler struct {
+	sessionService *service.SessionService
+}
+
+// NewSessionHandler creates a new session handler
+func NewSessionHandler(sessionService *service.SessionService) *SessionHandler {
+	return &SessionHandler{
+		sessionService: sessionService,
+	}
+}
+
+// ListSessions returns every device session of the user
+func (h *SessionHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	currentDeviceID, _ := gocql.ParseUUID(r.Header.Get("X-Device-ID")) // already validated by the auth middleware
+
+	sessions, err := h.sessionService.ListSessions(userID)
+	if err != nil {
+		respondError(w, "could not get sessions - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, map[string]interface{}{
+			"device_id":   session.DeviceID.String(),
+			"device_name": session.DeviceName,
+			"created_at":  session.CreatedAt,
+			"last_used":   session.LastUsed,
+			"expires_at":  session.ExpiresAt,
+			"ip":          session.IP,
+			"user_agent":  session.UserAgent,
+			"current":     session.DeviceID == currentDeviceID,
+		})
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": result,
+	})
+}
+
+// RevokeSession logs out a specific device or every device except the current one
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		DeviceID  string `json:"device_id"`  // device to revoke
+		AllOthers bool   `json:"all_others"` // revoke every device except the current one
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if req.AllOthers {
+		currentDeviceID, err := gocql.ParseUUID(r.Header.Get("X-Device-ID"))
+		if err != nil {
+			respondError(w, "invalid device ID - "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		revoked, err := h.sessionService.RevokeOtherSessions(userID, currentDeviceID)
+		if err != nil {
+			respondError(w, "could not revoke sessions - "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		respondJSON(w, http.StatusOK, map[string]interface{}{
+			"status":  "sessions revoked",
+			"revoked": revoked,
+		})
+		return
+	}
+
+	deviceID, err := gocql.ParseUUID(req.DeviceID)
+	if err != nil {
+		respondError(w, "invalid device_id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := h.sessionService.CheckSession(userID, deviceID)
+	if err != nil {
+		respondError(w, "could not get session - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		respondError(w, repository.ErrSessionNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := h.sessionService.DeleteSession(userID, deviceID); err != nil {
+		respondError(w, "could not revoke session - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "session revoked",
+		"revoked": 1,
+	})
+}
+
+// RenameSession sets the name of one of the user's devices
+func (h *SessionHandler) RenameSession(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		DeviceID   string `json:"device_id"`
+		DeviceName string `json:"device_name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	deviceID, err := gocql.ParseUUID(req.DeviceID)
+	if err != nil {
+		respondError(w, "invalid device_id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.sessionService.RenameSession(userID, deviceID, truncate(req.DeviceName, maxDeviceNameLength)); err != nil {
+		status := http.StatusInternalServerError
+		if err == repository.ErrSessionNotFound {
+			status = http.StatusNotFound
+		}
+		respondError(w, "could not rename session - "+err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "session renamed"})
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"backend/internal/service"
+
+	"encoding/json"
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// TwoFactorHandler manages totp enrollment endpoints
+type TwoFactorHandler struct {
+	twoFactorService *service.TwoFactorService
+}
+
+// NewTwoFactorHandler creates a new two factor handler
+func NewTwoFactorHandler(twoFactorService *service.TwoFactorService) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorService: twoFactorService,
+	}
+}
+
+// Enroll starts totp setup and returns the secret and otpauth uri
+func (h *TwoFactorHandler) Enroll(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	secret, uri, err := h.twoFactorService.Enroll(userID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == service.ErrTwoFactorAlreadyEnabled {
+			status = http.StatusConflict
+		}
+		respondError(w, "could not enroll two factor - "+err.Error(), status)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": uri,
+	})
+}
+
+// Confirm activates totp with a first code and returns the recovery codes
+func (h *TwoFactorHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	recoveryCodes, err := h.twoFactorService.Confirm(userID, req.Code)
+	if err != nil {
+		respondError(w, "could not confirm two factor - "+err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "two factor enabled",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after verifying a totp code
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code string `json:"code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.twoFactorService.Verify(userID, req.Code, ""); err != nil {
+		respondError(w, "could not verify code - "+err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+
+	recoveryCodes, err := h.twoFactorService.RegenerateRecoveryCodes(userID)
+	if err != nil {
+		respondError(w, "could not regenerate recovery codes - "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// Disable removes totp from the account after verifying a code
+func (h *TwoFactorHandler) Disable(w http.ResponseWriter, r *http.Request) {
+	// get user id from context
+	userID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.twoFactorService.Disable(userID, req.Code, req.RecoveryCode); err != nil {
+		respondError(w, "could not disable two factor - "+err.Error(), twoFactorErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "two factor disabled"})
+}
+
+// map two factor errors to http status codes
+func twoFactorErrorStatus(err error) int {
+	switch err {
+	case service.ErrInvalidTwoFactorCode:
+		return http.StatusUnauthorized
+	case service.ErrTwoFactorNotEnrolled, service.ErrTwoFactorAlreadyEnabled:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
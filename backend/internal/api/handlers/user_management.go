@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"backend/internal/service"
+	"backend/pkg/utils"
+
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gocql/gocql"
+)
+
+// UserManagementHandler exposes the admin user management endpoints
+type UserManagementHandler struct {
+	userManagementService *service.UserManagementService
+}
+
+// NewUserManagementHandler creates a new user management handler
+func NewUserManagementHandler(userManagementService *service.UserManagementService) *UserManagementHandler {
+	return &UserManagementHandler{
+		userManagementService: userManagementService,
+	}
+}
+
+// ListUsers returns a page of accounts (optionally filtered by email with ?q=)
+func (h *UserManagementHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	count := 50
+	if value := query.Get("count"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 500 {
+			respondError(w, "count must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		count = parsed
+	}
+
+	// the paging state is passed to clients as an opaque url safe string
+	var pageState []byte
+	if value := query.Get("page"); value != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			respondError(w, "invalid page", http.StatusBadRequest)
+			return
+		}
+		pageState = decoded
+	}
+
+	accounts, nextPage, truncated, err := h.userManagementService.ListAccounts(query.Get("q"), count, pageState)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"users":     accounts,
+		"truncated": truncated, // the search gave up early, request next_page to continue it
+	}
+	if nextPage != nil {
+		response["next_page"] = base64.RawURLEncoding.EncodeToString(nextPage)
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// GetUser returns an account with its roles, sessions, scan history and recent security events
+func (h *UserManagementHandler) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := gocql.ParseUUID(r.URL.Query().Get("user_id"))
+	if err != nil {
+		respondError(w, "invalid user_id - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	details, err := h.userManagementService.GetUserDetails(userID)
+	if err != nil {
+		respondError(w, err.Error(), userManagementErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, details)
+}
+
+// DisableUser blocks an account and logs it out everywhere
+func (h *UserManagementHandler) DisableUser(w http.ResponseWriter, r *http.Request) {
+	adminID, userID, ok := parseUserManagementRequest(w, r, nil)
+	if !ok {
+		return
+	}
+
+	if userID == adminID {
+		respondError(w, "cannot disable your own account", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.userManagementService.DisableAccount(userID, adminID, utils.ClientIP(r)); err != nil {
+		respondError(w, "could not disable account - "+err.Error(), userManagementErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "account disabled"})
+}
+
+// EnableUser re-enables a disabled account
+func (h *UserManagementHandler) EnableUser(w http.ResponseWriter, r *http.Request) {
+	adminID, userID, ok := parseUserManagementRequest(w, r, nil)
+	if !ok {
+		return
+	}
+
+	if err := h.userManagementService.EnableAccount(userID, adminID, utils.ClientIP(r)); err != nil {
+		respondError(w, "could not enable account - "+err.Error(), userManagementErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "account enabled"})
+}
+
+// ForceLogout ends all sessions of a user
+func (h *UserManagementHandler) ForceLogout(w http.ResponseWriter, r *http.Request) {
+	adminID, userID, ok := parseUserManagementRequest(w, r, nil)
+	if !ok {
+		return
+	}
+
+	if err := h.userManagementService.ForceLogout(userID, adminID, utils.ClientIP(r)); err != nil {
+		respondError(w, "could not log out user - "+err.Error(), userManagementErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"status": "user logged out"})
+}
+
+// SetAdmin grants or revokes the admin role of a user
+func (h *UserManagementHandler) SetAdmin(w http.ResponseWriter, r *http.Request) {
+	var admin bool
+	adminID, userID, ok := parseUserManagementRequest(w, r, &admin)
+	if !ok {
+		return
+	}
+
+	if err := h.userManagementService.SetAdmin(userID, admin, adminID); err != nil {
+		respondError(w, "could not update admin role - "+err.Error(), userManagementErrorStatus(err))
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"user_id": userID.String(), "admin": admin})
+}
+
+// parse the acting admin from the context and the target user (and the optional admin flag) from the body
+func parseUserManagementRequest(w http.ResponseWriter, r *http.Request, admin *bool) (gocql.UUID, gocql.UUID, bool) {
+	// get user id from context
+	adminID, ok := r.Context().Value("userID").(gocql.UUID)
+	if !ok {
+		respondError(w, "authentication required", http.StatusUnauthorized)
+		return gocql.UUID{}, gocql.UUID{}, false
+	}
+
+	var req struct {
+		UserID string `json:"user_id"`
+		Admin  bool   `json:"admin"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request format", http.StatusBadRequest)
+		return gocql.UUID{}, gocql.UUID{}, false
+	}
+
+	userID, err := gocql.ParseUUID(req.UserID)
+	if err != nil {
+		respondError(w, "invalid user_id - "+err.Error(), http.StatusBadRequest)
+		return gocql.UUID{}, gocql.UUID{}, false
+	}
+
+	if admin != nil {
+		*admin = req.Admin
+	}
+	return adminID, userID, true
+}
+
+// map user management errors to http status codes
+func userManagementErrorStatus(err error) int {
+	switch err {
+	case service.ErrAccountNotFound:
+		return http.StatusNotFound
+	case service.ErrUnknownRole, service.ErrOwnAccount:
+		return http.StatusBadRequest
+	case service.ErrHigherRole:
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
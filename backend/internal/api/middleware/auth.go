@@ -2,7 +2,6 @@ package middleware
 
 import (
 	"backend/internal/service"
-	"backend/pkg/config"
 	"backend/pkg/utils"
 
 	"context"
@@ -12,8 +11,8 @@ import (
 	"github.com/gocql/gocql"
 )
 
-// AuthMiddleware validates jwts and injects user context
-func AuthMiddleware(cfg *config.Config, sessionService *service.SessionService) func(http.Handler) http.Handler {
+// AuthMiddleware validates jwts or api keys and injects user context
+func AuthMiddleware(keys *utils.KeySet, sessionService *service.SessionService, accountService *service.AccountService, revocationService *service.TokenRevocationService, apiKeyService *service.APIKeyService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(
 			func(w http.ResponseWriter, r *http.Request) {
@@ -38,13 +37,58 @@ func AuthMiddleware(cfg *config.Config, sessionService *service.SessionService)
 				}
 				token := tokenParts[1]
 
-				// parse and validate token
-				claims, err := utils.ParseToken(token, []byte(cfg.JWTSecret))
+				// service accounts authenticate with api keys instead of a jwt and device session
+				if service.IsAPIKey(token) {
+					if !acceptsAPIKey(r.URL.Path) {
+						http.Error(w, "api keys are not accepted for this endpoint", http.StatusForbidden)
+						return
+					}
+
+					apiKey, err := apiKeyService.Authenticate(token)
+					if err == service.ErrInvalidAPIKey {
+						http.Error(w, err.Error(), http.StatusUnauthorized)
+						return
+					} else if err != nil {
+						http.Error(w, "api key check failed - "+err.Error(), http.StatusInternalServerError)
+						return
+					}
+
+					// the service account is the acting principal, permissions come from the key scopes
+					ctx := context.WithValue(r.Context(), "userID", apiKey.ServiceAccountID)
+					ctx = context.WithValue(ctx, "apiKey", apiKey)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+
+				// parse and validate token (only access tokens are accepted)
+				claims, err := utils.ValidateToken(token, "access", keys)
 				if err != nil {
 					http.Error(w, "invalid token - "+err.Error(), http.StatusForbidden)
 					return
 				}
 
+				// reject revoked tokens (tokens without jti cannot be revoked and are not accepted)
+				tokenID, _ := claims["jti"].(string)
+				if tokenID == "" {
+					http.Error(w, "invalid token - missing jti", http.StatusUnauthorized)
+					return
+				}
+				expiresAt, err := claims.GetExpirationTime()
+				if err != nil || expiresAt == nil {
+					http.Error(w, "invalid token - missing exp", http.StatusUnauthorized)
+					return
+				}
+
+				revoked, err := revocationService.IsRevoked(tokenID)
+				if err != nil {
+					http.Error(w, "token check failed - "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if revoked {
+					http.Error(w, "token revoked", http.StatusUnauthorized)
+					return
+				}
+
 				// extract user ID from claims
 				userID, err := gocql.ParseUUID(claims["sub"].(string))
 				if err != nil {
@@ -68,13 +112,36 @@ func AuthMiddleware(cfg *config.Config, sessionService *service.SessionService)
 					return
 				}
 
-				// inject user ID into request context
+				// disabled accounts lose access immediately, even with a valid access token
+				disabled, err := accountService.IsDisabled(userID)
+				if err != nil {
+					http.Error(w, "account check failed - "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if disabled {
+					http.Error(w, "account disabled", http.StatusForbidden)
+					return
+				}
+
+				// inject user ID and token details (needed to revoke it on logout) into request context
 				ctx := context.WithValue(r.Context(), "userID", userID)
+				ctx = context.WithValue(ctx, "tokenID", tokenID)
+				ctx = context.WithValue(ctx, "tokenExpiry", expiresAt.Time)
 				next.ServeHTTP(w, r.WithContext(ctx))
 			})
 	}
 }
 
+// check if api keys may call the route (account endpoints under /auth/ are for humans only)
+func acceptsAPIKey(path string) bool {
+	for _, prefix := range []string{"/qr/", "/qr-mgmt/", "/admin/users", "/admin/unlock_login"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // check if route doesnt require authentication
 func isPublicRoute(path string) bool {
 	publicRoutes := map[string]bool{
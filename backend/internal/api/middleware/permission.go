@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"backend/internal/models"
+	"backend/internal/service"
+
+	"context"
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// RequirePermission blocks users whose roles (or api keys whose scopes) do not grant the permission (must run after AuthMiddleware)
+func RequirePermission(authorizationService *service.AuthorizationService, permission models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				userID, ok := r.Context().Value("userID").(gocql.UUID)
+				if !ok {
+					http.Error(w, "authentication required", http.StatusUnauthorized)
+					return
+				}
+
+				allowed, err := hasPermission(r, authorizationService, userID, permission)
+				if err != nil {
+					http.Error(w, "could not check permissions - "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if !allowed {
+					http.Error(w, "missing permission "+string(permission), http.StatusForbidden)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+			})
+	}
+}
+
+// ActOnBehalf lets callers with the permission act as the user named in the X-On-Behalf-Of header.
+// api keys always have to name a user, requests without the header continue as the caller (must run after AuthMiddleware)
+func ActOnBehalf(authorizationService *service.AuthorizationService, accountService *service.AccountService, permission models.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				actorID, ok := r.Context().Value("userID").(gocql.UUID)
+				if !ok {
+					http.Error(w, "authentication required", http.StatusUnauthorized)
+					return
+				}
+
+				target := r.Header.Get("X-On-Behalf-Of")
+				if target == "" {
+					if _, isAPIKey := r.Context().Value("apiKey").(*models.APIKey); isAPIKey {
+						http.Error(w, "X-On-Behalf-Of header required for api keys", http.StatusBadRequest)
+						return
+					}
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				allowed, err := hasPermission(r, authorizationService, actorID, permission)
+				if err != nil {
+					http.Error(w, "could not check permissions - "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if !allowed {
+					http.Error(w, "missing permission "+string(permission), http.StatusForbidden)
+					return
+				}
+
+				userID, err := gocql.ParseUUID(target)
+				if err != nil {
+					http.Error(w, "invalid X-On-Behalf-Of - "+err.Error(), http.StatusBadRequest)
+					return
+				}
+
+				// reports unknown accounts as disabled
+				disabled, err := accountService.IsDisabled(userID)
+				if err != nil {
+					http.Error(w, "account check failed - "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if disabled {
+					http.Error(w, "account not found or disabled", http.StatusForbidden)
+					return
+				}
+
+				// handlers see the represented user, the caller stays available for auditing
+				ctx := context.WithValue(r.Context(), "userID", userID)
+				ctx = context.WithValue(ctx, "actorID", actorID)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+	}
+}
+
+// api keys are limited to their scopes, users to the permissions of their roles
+func hasPermission(r *http.Request, authorizationService *service.AuthorizationService, userID gocql.UUID, permission models.Permission) (bool, error) {
+	if apiKey, ok := r.Context().Value("apiKey").(*models.APIKey); ok {
+		return apiKey.HasScope(permission), nil
+	}
+	return authorizationService.HasPermission(userID, permission)
+}
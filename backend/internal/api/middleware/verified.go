@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"backend/internal/service"
+
+	"net/http"
+
+	"github.com/gocql/gocql"
+)
+
+// RequireVerified blocks users that have not confirmed their email address (must run after AuthMiddleware).
+// with allowGuests guest accounts pass as well, they have no email until they are claimed
+func RequireVerified(accountService *service.AccountService, allowGuests bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				userID, ok := r.Context().Value("userID").(gocql.UUID)
+				if !ok {
+					http.Error(w, "authentication required", http.StatusUnauthorized)
+					return
+				}
+
+				verified, err := accountService.IsVerified(userID)
+				if err != nil {
+					http.Error(w, "could not check account - "+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				if !verified && allowGuests {
+					verified, err = accountService.IsGuest(userID)
+					if err != nil {
+						http.Error(w, "could not check account - "+err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
+				if !verified {
+					http.Error(w, service.ErrEmailNotVerified.Error(), http.StatusForbidden)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+			})
+	}
+}
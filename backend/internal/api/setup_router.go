@@ -3,65 +3,207 @@ package api
 import (
 	"backend/internal/api/handlers"
 	"backend/internal/api/middleware"
+	"backend/internal/models"
 	"backend/internal/repository"
 	"backend/internal/service"
 	"backend/pkg/config"
+	"backend/pkg/mail"
+	"backend/pkg/utils"
 
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gocql/gocql"
 	"github.com/gorilla/mux"
 )
 
 // SetupRouter configures all application routes
-func SetupRouter(session *gocql.Session, cfg *config.Config, logger *log.Logger) *mux.Router {
+func SetupRouter(session *gocql.Session, cfg *config.Config, keys *utils.KeySet, qrKeys *utils.QRKeySet, relyingParty *webauthn.WebAuthn, passwordParams utils.Argon2Params, passwordPolicy *utils.PasswordPolicy, mailer mail.Mailer, logger *log.Logger) *mux.Router {
 	router := mux.NewRouter()
 
 	// initialize repositories (database access)
 	accountRepo := repository.NewAccountRepo(session)
 	sessionRepo := repository.NewSessionRepo(session)
+	passwordResetRepo := repository.NewPasswordResetRepo(session)
+	twoFactorRepo := repository.NewTwoFactorRepo(session)
+	securityEventRepo := repository.NewSecurityEventRepo(session)
+	loginAttemptRepo := repository.NewLoginAttemptRepo(session)
+	roleRepo := repository.NewRoleRepo(session)
+	revokedTokenRepo := repository.NewRevokedTokenRepo(session)
+	oidcRepo := repository.NewOIDCRepo(session)
+	passkeyRepo := repository.NewPasskeyRepo(session)
+	apiKeyRepo := repository.NewAPIKeyRepo(session)
+	dataExportRepo := repository.NewDataExportRepo(session)
+	magicLinkRepo := repository.NewMagicLinkRepo(session)
 
 	qrActionRepo := repository.NewQRActionRepo(session)
 	qrCodeRepo := repository.NewQRCodeRepo(session)
 	userQrScanRepo := repository.NewUserQRScanRepo(session)
 
 	// initialize services (logic)
-	accountService := service.NewAccountService(accountRepo, sessionRepo, cfg.PepperSecret)
-	sessionService := service.NewSessionService(sessionRepo, cfg.PepperSecret, time.Hour*24*time.Duration(cfg.RefreshTokenTTL))
-	qrService := service.NewQRService(qrActionRepo, qrCodeRepo, userQrScanRepo)
+	securityEventService := service.NewSecurityEventService(securityEventRepo)
+	authorizationService := service.NewAuthorizationService(roleRepo, accountRepo)
+	accountService := service.NewAccountService(accountRepo, sessionRepo, passkeyRepo, oidcRepo, mailer, cfg.PepperSecret, passwordParams, passwordPolicy, keys, cfg.PublicBaseURL, time.Duration(cfg.EmailVerificationTTL)*time.Hour, time.Duration(cfg.EmailChangeCancelTTL)*24*time.Hour, logger)
+	passwordResetService := service.NewPasswordResetService(accountService, accountRepo, sessionRepo, passwordResetRepo, mailer, cfg.PepperSecret, passwordParams, passwordPolicy, cfg.AppBaseURL, time.Duration(cfg.PasswordResetTTL)*time.Minute, logger)
+	magicLinkService := service.NewMagicLinkService(accountService, magicLinkRepo, accountRepo, mailer, cfg.PepperSecret, cfg.AppBaseURL,
+		time.Duration(cfg.MagicLinkTTL)*time.Minute,
+		time.Duration(cfg.MagicLinkWindow)*time.Minute,
+		cfg.MagicLinkMaxPerEmail, cfg.MagicLinkMaxPerIP,
+		logger,
+	)
+	sessionService := service.NewSessionService(sessionRepo, securityEventService, cfg.PepperSecret, time.Hour*24*time.Duration(cfg.RefreshTokenTTL), cfg.RevokeAllOnTokenReuse)
+	revocationService := service.NewTokenRevocationService(revokedTokenRepo, time.Duration(cfg.RevocationCacheTTL)*time.Second)
+	loginProtectionService := service.NewLoginProtectionService(loginAttemptRepo, accountRepo, securityEventService,
+		cfg.LoginMaxAccountFailures, cfg.LoginMaxIPFailures,
+		time.Duration(cfg.LoginFailureWindow)*time.Minute,
+		time.Duration(cfg.LoginLockoutBase)*time.Minute,
+		time.Duration(cfg.LoginLockoutMax)*time.Minute,
+	)
+	oidcService := service.NewOIDCService(accountRepo, oidcRepo, securityEventService, cfg.OIDCProviders, cfg.PublicBaseURL+"/auth/oidc/callback", time.Duration(cfg.OIDCStateTTL)*time.Minute)
+	passkeyService := service.NewPasskeyService(accountService, accountRepo, passkeyRepo, relyingParty, time.Duration(cfg.WebAuthnCeremonyTTL)*time.Minute)
+	twoFactorService := service.NewTwoFactorService(accountRepo, twoFactorRepo, cfg.PepperSecret, keys, cfg.TOTPIssuer, time.Duration(cfg.TwoFactorChallengeTTL)*time.Minute)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, authorizationService, securityEventService, cfg.PepperSecret, time.Duration(cfg.APIKeyRotationGrace)*time.Minute)
+	userManagementService := service.NewUserManagementService(accountRepo, sessionRepo, userQrScanRepo, authorizationService, securityEventService)
+	qrService := service.NewQRService(qrActionRepo, qrCodeRepo, userQrScanRepo, qrKeys, cfg.QRAcceptUnsigned, cfg.QRBulkMaxCodes)
+	dataExportService := service.NewDataExportService(dataExportRepo, accountRepo, sessionRepo, roleRepo, twoFactorRepo, passkeyRepo, oidcRepo,
+		securityEventService, userQrScanRepo, qrCodeRepo, qrActionRepo,
+		cfg.DataExportWorkers,
+		time.Duration(cfg.DataExportTTL)*time.Hour,
+		time.Duration(cfg.DataExportSyncWait)*time.Second,
+		time.Duration(cfg.DataExportTimeout)*time.Minute,
+	)
+	accountDeletionService := service.NewAccountDeletionService(accountRepo, sessionRepo, securityEventService, securityEventRepo, userQrScanRepo,
+		roleRepo, twoFactorRepo, passkeyRepo, oidcRepo, dataExportRepo, mailer, keys, cfg.PublicBaseURL,
+		time.Duration(cfg.AccountDeletionGrace)*24*time.Hour,
+	)
+
+	guestService := service.NewGuestService(accountRepo, userQrScanRepo, qrCodeRepo, magicLinkRepo, accountService, accountDeletionService, securityEventService, cfg.PepperSecret, passwordParams, passwordPolicy,
+		time.Duration(cfg.GuestWindow)*time.Minute, cfg.GuestMaxPerIP,
+	)
+
+	// remove deleted accounts after their grace period (runs on every instance, purges are idempotent)
+	go accountDeletionService.RunPurger(time.Duration(cfg.AccountPurgeInterval)*time.Minute, logger)
 
 	// initialize handlers (http parsing)
-	authHandler := handlers.NewAuthHandler(accountService, sessionService, cfg)
-	accountHandler := handlers.NewAccountHandler(accountService)
+	authHandler := handlers.NewAuthHandler(accountService, sessionService, twoFactorService, loginProtectionService, revocationService, oidcService, passkeyService, guestService, magicLinkService, keys, cfg)
+	accountHandler := handlers.NewAccountHandler(accountService, accountDeletionService)
+	passwordResetHandler := handlers.NewPasswordResetHandler(passwordResetService)
+	twoFactorHandler := handlers.NewTwoFactorHandler(twoFactorService)
+	sessionHandler := handlers.NewSessionHandler(sessionService)
+	loginProtectionHandler := handlers.NewLoginProtectionHandler(loginProtectionService)
+	roleHandler := handlers.NewRoleHandler(authorizationService)
+	userManagementHandler := handlers.NewUserManagementHandler(userManagementService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	dataExportHandler := handlers.NewDataExportHandler(dataExportService)
 
 	qrCodeHandler := handlers.NewQRCodeHandler(qrService)
-	qrCodeManagementHandler := handlers.NewQRCodeManagementHandler(qrService, accountRepo)
+	qrCodeManagementHandler := handlers.NewQRCodeManagementHandler(qrService)
 
+	jwksHandler := handlers.NewJWKSHandler(keys, qrKeys)
 	debugHandler := handlers.NewDebugHandler(cfg)
 
 	// public routes
 	router.HandleFunc("/auth/register", accountHandler.Register).Methods("POST")
 	router.HandleFunc("/auth/login", authHandler.Login).Methods("POST")
+	router.HandleFunc("/auth/login/2fa", authHandler.LoginTwoFactor).Methods("POST")
 	router.HandleFunc("/auth/refresh", authHandler.SilentLogin).Methods("POST")
+	router.HandleFunc("/auth/verify_email", accountHandler.VerifyEmail).Methods("GET", "POST")
+	router.HandleFunc("/auth/confirm_email_change", accountHandler.ConfirmEmailChange).Methods("GET", "POST")
+	router.HandleFunc("/auth/cancel_email_change", accountHandler.CancelEmailChange).Methods("GET", "POST")
+	router.HandleFunc("/auth/restore_account", accountHandler.RestoreAccount).Methods("GET", "POST")
+	router.HandleFunc("/auth/forgot_password", passwordResetHandler.ForgotPassword).Methods("POST")
+	router.HandleFunc("/auth/reset_password", passwordResetHandler.ResetPassword).Methods("POST")
+	router.HandleFunc("/auth/oidc/providers", authHandler.OIDCProviders).Methods("GET")
+	router.HandleFunc("/auth/oidc/start", authHandler.OIDCStart).Methods("GET")
+	router.HandleFunc("/auth/oidc/callback", authHandler.OIDCCallback).Methods("GET")
+	router.HandleFunc("/auth/passkeys/signup/start", authHandler.PasskeySignupStart).Methods("POST")
+	router.HandleFunc("/auth/passkeys/signup/finish", authHandler.PasskeySignupFinish).Methods("POST")
+	router.HandleFunc("/auth/passkeys/login/start", authHandler.PasskeyLoginStart).Methods("POST")
+	router.HandleFunc("/auth/passkeys/login/finish", authHandler.PasskeyLoginFinish).Methods("POST")
+	router.HandleFunc("/auth/guest", authHandler.GuestLogin).Methods("POST")
+	router.HandleFunc("/auth/magic_link", authHandler.RequestMagicLink).Methods("POST")
+	router.HandleFunc("/auth/magic_link/redeem", authHandler.RedeemMagicLink).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", jwksHandler.JWKS).Methods("GET")
+	router.HandleFunc("/qr/keys", jwksHandler.QRKeys).Methods("GET")
 
 	// authenticated routes (use auth middleware)
 	authRouter := router.PathPrefix("/").Subrouter()
-	authRouter.Use(middleware.AuthMiddleware(cfg, sessionService))
+	authRouter.Use(middleware.AuthMiddleware(keys, sessionService, accountService, revocationService, apiKeyService))
 
 	authRouter.HandleFunc("/auth/change_password", accountHandler.ChangePassword).Methods("POST")
+	authRouter.HandleFunc("/auth/change_email", accountHandler.ChangeEmail).Methods("POST")
 	authRouter.HandleFunc("/auth/logout", authHandler.Logout).Methods("POST")
 	authRouter.HandleFunc("/auth/delete_account", accountHandler.Delete).Methods("POST")
-
-	authRouter.HandleFunc("/qr/scan", qrCodeHandler.GetQRAction).Methods("GET")
-
-	authRouter.HandleFunc("/qr-mgmt/add_action", qrCodeManagementHandler.AddQRAction).Methods("POST")
-	authRouter.HandleFunc("/qr-mgmt/add_code", qrCodeManagementHandler.AddQRCode).Methods("POST")
-	authRouter.HandleFunc("/qr-mgmt/delete_code", qrCodeManagementHandler.DeleteQRCode).Methods("POST")
-	authRouter.HandleFunc("/qr-mgmt/delete_action", qrCodeManagementHandler.DeleteQRAction).Methods("POST")
-	authRouter.HandleFunc("/qr-mgmt/list_codes", qrCodeManagementHandler.GetAllQRCodes).Methods("GET")
-	authRouter.HandleFunc("/qr-mgmt/list_actions", qrCodeManagementHandler.GetAllQRActions).Methods("GET")
+	authRouter.HandleFunc("/auth/resend_verification", accountHandler.ResendVerification).Methods("POST")
+	authRouter.HandleFunc("/auth/permissions", roleHandler.MyPermissions).Methods("GET")
+
+	authRouter.HandleFunc("/auth/export", dataExportHandler.StartExport).Methods("POST")
+	authRouter.HandleFunc("/auth/export", dataExportHandler.GetExports).Methods("GET")
+	authRouter.HandleFunc("/auth/export/download", dataExportHandler.Download).Methods("GET")
+
+	authRouter.HandleFunc("/auth/sessions", sessionHandler.ListSessions).Methods("GET")
+	authRouter.HandleFunc("/auth/sessions/revoke", sessionHandler.RevokeSession).Methods("POST")
+	authRouter.HandleFunc("/auth/sessions/rename", sessionHandler.RenameSession).Methods("POST")
+
+	authRouter.HandleFunc("/auth/2fa/enroll", twoFactorHandler.Enroll).Methods("POST")
+	authRouter.HandleFunc("/auth/2fa/confirm", twoFactorHandler.Confirm).Methods("POST")
+	authRouter.HandleFunc("/auth/2fa/recovery_codes", twoFactorHandler.RegenerateRecoveryCodes).Methods("POST")
+	authRouter.HandleFunc("/auth/2fa/disable", twoFactorHandler.Disable).Methods("POST")
+
+	authRouter.HandleFunc("/auth/passkeys", authHandler.ListPasskeys).Methods("GET")
+	authRouter.HandleFunc("/auth/passkeys/register/start", authHandler.PasskeyRegisterStart).Methods("POST")
+	authRouter.HandleFunc("/auth/passkeys/register/finish", authHandler.PasskeyRegisterFinish).Methods("POST")
+	authRouter.HandleFunc("/auth/passkeys/rename", authHandler.RenamePasskey).Methods("POST")
+	authRouter.HandleFunc("/auth/passkeys/delete", authHandler.DeletePasskey).Methods("POST")
+
+	authRouter.HandleFunc("/auth/guest/claim", authHandler.ClaimGuest).Methods("POST")
+	authRouter.HandleFunc("/auth/guest/merge", authHandler.MergeGuest).Methods("POST")
+
+	// routes that require a verified email address (guests may scan, their progress moves along when they upgrade)
+	requireVerified := middleware.RequireVerified(accountService, true)
+
+	// api keys (and users with the permission) scan for the user in X-On-Behalf-Of
+	onBehalf := middleware.ActOnBehalf(authorizationService, accountService, models.PermQRScan)
+
+	authRouter.Handle("/qr/scan", onBehalf(requireVerified(http.HandlerFunc(qrCodeHandler.GetQRAction)))).Methods("GET")
+
+	// routes that require a permission granted by one of the user's roles
+	withPermission := func(permission models.Permission, handler http.HandlerFunc) http.Handler {
+		return middleware.RequirePermission(authorizationService, permission)(handler)
+	}
+
+	authRouter.Handle("/qr-mgmt/add_action", withPermission(models.PermQRActionCreate, qrCodeManagementHandler.AddQRAction)).Methods("POST")
+	authRouter.Handle("/qr-mgmt/add_code", withPermission(models.PermQRCodeCreate, qrCodeManagementHandler.AddQRCode)).Methods("POST")
+	authRouter.Handle("/qr-mgmt/add_codes", withPermission(models.PermQRCodeCreate, qrCodeManagementHandler.AddQRCodeBatch)).Methods("POST")
+	authRouter.Handle("/qr-mgmt/delete_code", withPermission(models.PermQRCodeDelete, qrCodeManagementHandler.DeleteQRCode)).Methods("POST")
+	authRouter.Handle("/qr-mgmt/delete_action", withPermission(models.PermQRActionDelete, qrCodeManagementHandler.DeleteQRAction)).Methods("POST")
+	authRouter.Handle("/qr-mgmt/list_codes", withPermission(models.PermQRCodeRead, qrCodeManagementHandler.GetAllQRCodes)).Methods("GET")
+	authRouter.Handle("/qr-mgmt/list_actions", withPermission(models.PermQRActionRead, qrCodeManagementHandler.GetAllQRActions)).Methods("GET")
+	authRouter.Handle("/qr-mgmt/get_payload", withPermission(models.PermQRCodeRead, qrCodeManagementHandler.GetQRCodePayload)).Methods("GET")
+	authRouter.Handle("/qr-mgmt/codes/{id}/image", withPermission(models.PermQRCodeRead, qrCodeManagementHandler.GetQRCodeImage)).Methods("GET", "POST")
+	authRouter.Handle("/qr-mgmt/print/pdf", withPermission(models.PermQRCodeRead, qrCodeManagementHandler.PrintSheet)).Methods("POST")
+	authRouter.Handle("/qr-mgmt/print/zip", withPermission(models.PermQRCodeRead, qrCodeManagementHandler.ImageArchive)).Methods("POST")
+
+	authRouter.Handle("/admin/unlock_login", withPermission(models.PermAccountManage, loginProtectionHandler.Unlock)).Methods("POST")
+	authRouter.Handle("/admin/users", withPermission(models.PermAccountManage, userManagementHandler.ListUsers)).Methods("GET")
+	authRouter.Handle("/admin/users/get", withPermission(models.PermAccountManage, userManagementHandler.GetUser)).Methods("GET")
+	authRouter.Handle("/admin/users/disable", withPermission(models.PermAccountManage, userManagementHandler.DisableUser)).Methods("POST")
+	authRouter.Handle("/admin/users/enable", withPermission(models.PermAccountManage, userManagementHandler.EnableUser)).Methods("POST")
+	authRouter.Handle("/admin/users/logout", withPermission(models.PermAccountManage, userManagementHandler.ForceLogout)).Methods("POST")
+	authRouter.Handle("/admin/users/set_admin", withPermission(models.PermRoleManage, userManagementHandler.SetAdmin)).Methods("POST")
+	authRouter.Handle("/admin/service_accounts", withPermission(models.PermServiceAccountManage, apiKeyHandler.ListServiceAccounts)).Methods("GET")
+	authRouter.Handle("/admin/service_accounts/create", withPermission(models.PermServiceAccountManage, apiKeyHandler.CreateServiceAccount)).Methods("POST")
+	authRouter.Handle("/admin/service_accounts/delete", withPermission(models.PermServiceAccountManage, apiKeyHandler.DeleteServiceAccount)).Methods("POST")
+	authRouter.Handle("/admin/api_keys", withPermission(models.PermServiceAccountManage, apiKeyHandler.ListKeys)).Methods("GET")
+	authRouter.Handle("/admin/api_keys/create", withPermission(models.PermServiceAccountManage, apiKeyHandler.CreateKey)).Methods("POST")
+	authRouter.Handle("/admin/api_keys/rotate", withPermission(models.PermServiceAccountManage, apiKeyHandler.RotateKey)).Methods("POST")
+	authRouter.Handle("/admin/api_keys/revoke", withPermission(models.PermServiceAccountManage, apiKeyHandler.RevokeKey)).Methods("POST")
+	authRouter.Handle("/admin/roles", withPermission(models.PermRoleManage, roleHandler.ListRoles)).Methods("GET")
+	authRouter.Handle("/admin/roles/user", withPermission(models.PermRoleManage, roleHandler.UserRoles)).Methods("GET")
+	authRouter.Handle("/admin/roles/grant", withPermission(models.PermRoleManage, roleHandler.GrantRole)).Methods("POST")
+	authRouter.Handle("/admin/roles/revoke", withPermission(models.PermRoleManage, roleHandler.RevokeRole)).Methods("POST")
 
 	authRouter.HandleFunc("/debug", debugHandler.AuthDebug).Methods("GET")
 
@@ -12,7 +12,23 @@ type Account struct {
 	Email        string     `json:"email"`      // email address
 	PasswordHash string     `json:"-"`          // hashed password (never exposed)
 	CreatedAt    time.Time  `json:"created_at"` // account creation timestamp
-	Admin        bool       `json:"admin"`      // admin privileges
+	Verified     bool       `json:"verified"`   // email address confirmed
+	Disabled     bool       `json:"disabled"`   // blocked by an admin
+	PendingEmail string     `json:"-"`          // requested new address until it is confirmed
+	Guest        bool       `json:"guest"`      // anonymous device account without email until it is claimed
+
+	DeletionRequestedAt time.Time `json:"deletion_requested_at"` // zero unless the account waits for the purge
+}
+
+// PendingDeletion reports whether the owner deleted the account and the grace period is running
+func (a *Account) PendingDeletion() bool {
+	return !a.DeletionRequestedAt.IsZero()
+}
+
+// PendingDeletion is an account waiting for the purge
+type PendingDeletion struct {
+	UserID      gocql.UUID `json:"user_id"`
+	RequestedAt time.Time  `json:"requested_at"`
 }
 
 // NewAccount creates a new account instance with initialized fields
@@ -23,6 +39,6 @@ func NewAccount(email, password string) *Account {
 		ID:        randomUUID,       // generate unique ID
 		Email:     email,            // set user email
 		CreatedAt: time.Now().UTC(), // set creation time
-		Admin:     false,            // default to non-admin
+		Verified:  false,            // email has to be confirmed first
 	}
 }
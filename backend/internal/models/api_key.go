@@ -0,0 +1,45 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// ServiceAccount is a non human principal (game server, kiosk script) that authenticates with api keys
+type ServiceAccount struct {
+	ID          gocql.UUID `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	CreatedBy   gocql.UUID `json:"created_by"` // admin that created the service account
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// APIKey is a long lived credential of a service account, limited to its scopes
+type APIKey struct {
+	ID               string       `json:"id"` // public part of the key, used for lookup
+	ServiceAccountID gocql.UUID   `json:"service_account_id"`
+	Name             string       `json:"name"`
+	SecretHash       string       `json:"-"` // hmac of the secret part (never exposed)
+	Scopes           []Permission `json:"scopes"`
+	CreatedBy        gocql.UUID   `json:"created_by"`
+	CreatedAt        time.Time    `json:"created_at"`
+	ExpiresAt        time.Time    `json:"expires_at"` // zero = never
+	LastUsedAt       time.Time    `json:"last_used_at"`
+	RevokedAt        time.Time    `json:"revoked_at"` // zero = active
+}
+
+// HasScope reports whether the key grants the permission
+func (k *APIKey) HasScope(permission Permission) bool {
+	for _, scope := range k.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether the key may be used at the given time
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// data export formats
+const (
+	ExportFormatJSON = "json" // single json document
+	ExportFormatZIP  = "zip"  // one json file per section
+)
+
+// data export states
+const (
+	ExportPending = "pending" // waiting for a free worker
+	ExportRunning = "running"
+	ExportReady   = "ready"
+	ExportFailed  = "failed"
+)
+
+// DataExport represents a personal data export requested by a user
+type DataExport struct {
+	UserID      gocql.UUID `json:"user_id"`         // account the data belongs to
+	ID          gocql.UUID `json:"id"`              // time based export identifier
+	Format      string     `json:"format"`          // one of the ExportFormat* constants
+	Status      string     `json:"status"`          // one of the Export* states
+	Error       string     `json:"error,omitempty"` // reason of a failed export
+	Size        int64      `json:"size"`            // bytes of the generated file
+	CreatedAt   time.Time  `json:"created_at"`      // request timestamp
+	CompletedAt time.Time  `json:"completed_at"`    // set once ready or failed
+	ExpiresAt   time.Time  `json:"expires_at"`      // the export and its file are removed afterwards
+}
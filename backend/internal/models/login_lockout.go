@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// lockout scopes
+const (
+	LockoutScopeAccount   = "account"    // keyed by normalized email
+	LockoutScopeIP        = "ip"         // keyed by client ip
+	LockoutScopeTwoFactor = "two_factor" // keyed by user ID (second login step)
+)
+
+// LoginLockout represents a temporary login block for an account or ip
+type LoginLockout struct {
+	Scope       string    `json:"scope"`        // one of the LockoutScope* constants
+	Key         string    `json:"key"`          // email or ip address
+	LockedUntil time.Time `json:"locked_until"` // logins are rejected until this time
+	Level       int       `json:"level"`        // number of consecutive lockouts (grows the duration)
+}
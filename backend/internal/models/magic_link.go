@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// MagicLinkToken represents a pending passwordless sign-in link
+type MagicLinkToken struct {
+	TokenHash  string     `json:"-"`           // hashed link token (never exposed)
+	UserID     gocql.UUID `json:"user_id"`     // account the link signs in to
+	Email      string     `json:"email"`       // address the link was sent to (invalid once the account email changes)
+	DeviceID   gocql.UUID `json:"device_id"`   // device that requested the link, only it can redeem it
+	DeviceName string     `json:"device_name"` // name for the session created on redemption
+	CreatedAt  time.Time  `json:"created_at"`  // request timestamp
+}
+
+// rate limit scopes for sign-in link requests (guest creation shares the request log)
+const (
+	MagicLinkLimitEmail = "email"    // keyed by normalized email
+	MagicLinkLimitIP    = "ip"       // keyed by client ip
+	GuestLimitIP        = "guest_ip" // guest account creations keyed by client ip
+)
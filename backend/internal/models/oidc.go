@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// OIDCState is a started provider login waiting for the callback
+type OIDCState struct {
+	State        string     `json:"-"` // random value echoed by the provider
+	Provider     string     `json:"provider"`
+	CodeVerifier string     `json:"-"` // pkce verifier, the provider only sees its hash
+	Nonce        string     `json:"-"` // bound into the id token
+	DeviceID     gocql.UUID `json:"device_id"`
+	DeviceName   string     `json:"device_name"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// OIDCIdentity links an account at an identity provider to a local account
+type OIDCIdentity struct {
+	Provider string     `json:"provider"`
+	Subject  string     `json:"subject"` // sub claim, stable per provider
+	UserID   gocql.UUID `json:"user_id"`
+	Email    string     `json:"email"` // email at link time
+	LinkedAt time.Time  `json:"linked_at"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// PasskeyCredential represents a registered webauthn credential
+type PasskeyCredential struct {
+	UserID          gocql.UUID `json:"user_id"`         // associated user ID
+	CredentialID    []byte     `json:"credential_id"`   // raw credential id (base64 in json)
+	PublicKey       []byte     `json:"-"`               // cose encoded public key
+	AttestationType string     `json:"-"`               // attestation format used at registration
+	Transports      []string   `json:"transports"`      // hints how the client can reach the authenticator
+	AAGUID          []byte     `json:"-"`               // authenticator model identifier
+	SignCount       int64      `json:"-"`               // last seen signature counter (clone detection)
+	BackupEligible  bool       `json:"backup_eligible"` // synced passkey (may exist on several devices)
+	BackupState     bool       `json:"backup_state"`
+	CloneWarning    bool       `json:"clone_warning"` // counter went backwards, the credential is blocked
+	Name            string     `json:"name"`          // user assigned label
+	CreatedAt       time.Time  `json:"created_at"`
+	LastUsedAt      time.Time  `json:"last_used_at"`
+}
+
+// webauthn ceremony kinds
+const (
+	CeremonySignup   = "signup"   // passwordless account creation
+	CeremonyRegister = "register" // additional passkey for a signed in user
+	CeremonyLogin    = "login"
+)
+
+// WebAuthnCeremony is a started registration or login waiting for the authenticator response
+type WebAuthnCeremony struct {
+	ID          string     `json:"-"`
+	Kind        string     `json:"kind"`
+	UserID      gocql.UUID `json:"user_id"` // empty for logins (discoverable credentials)
+	Email       string     `json:"email"`   // signup only
+	DeviceID    gocql.UUID `json:"device_id"`
+	DeviceName  string     `json:"device_name"`
+	SessionData string     `json:"-"` // json encoded library session (challenge, allowed credentials)
+	CreatedAt   time.Time  `json:"created_at"`
+}
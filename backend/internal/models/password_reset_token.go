@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// PasswordResetToken represents a pending password reset request
+type PasswordResetToken struct {
+	TokenHash string     `json:"-"`          // hashed reset token (never exposed)
+	UserID    gocql.UUID `json:"user_id"`    // account the reset belongs to
+	CreatedAt time.Time  `json:"created_at"` // request timestamp
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// QRCodeBatch is a bulk creation of qr codes for one action, the client supplied id makes retries idempotent
+type QRCodeBatch struct {
+	ID         gocql.UUID      `json:"batch_id"`
+	ActionId   gocql.UUID      `json:"action_id"`
+	QrCodeType QRCodeUsageType `json:"qr_type"`
+	MaxUsages  int             `json:"max_uses"`
+	ExpireMins int             `json:"expire_mins"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+	CodeIds    []gocql.UUID    `json:"qr_code_ids"` // generated before the first insert, retries create the same codes
+	Complete   bool            `json:"complete"`    // all codes were stored
+	CreatedAt  time.Time       `json:"created_at"`
+}
+
+// Codes returns the qr codes of the batch
+func (b *QRCodeBatch) Codes() []QRCode {
+	codes := make([]QRCode, len(b.CodeIds))
+	for i, id := range b.CodeIds {
+		codes[i] = QRCode{
+			ID:         id,
+			ActionId:   b.ActionId,
+			QrCodeType: b.QrCodeType,
+			MaxUsages:  b.MaxUsages,
+			ExpiresAt:  b.ExpiresAt,
+		}
+	}
+	return codes
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// RevokedToken is an access token that must be rejected before it expires
+type RevokedToken struct {
+	TokenID   string     `json:"token_id"` // jti claim
+	UserID    gocql.UUID `json:"user_id"`
+	RevokedAt time.Time  `json:"revoked_at"`
+	ExpiresAt time.Time  `json:"expires_at"` // exp claim, the row is deleted afterwards
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Role is a named set of permissions
+type Role string
+
+// available roles, each one includes the permissions of the previous one
+const (
+	RoleViewer     Role = "viewer"
+	RoleQREditor   Role = "qr-editor"
+	RoleAdmin      Role = "admin"
+	RoleSuperAdmin Role = "superadmin"
+)
+
+// Permission names a single authorized operation
+type Permission string
+
+const (
+	PermQRCodeRead     Permission = "qr.code.read"
+	PermQRCodeCreate   Permission = "qr.code.create"
+	PermQRCodeDelete   Permission = "qr.code.delete"
+	PermQRActionRead   Permission = "qr.action.read"
+	PermQRActionCreate Permission = "qr.action.create"
+	PermQRActionDelete Permission = "qr.action.delete"
+	PermAccountManage  Permission = "account.manage"
+	PermRoleManage     Permission = "role.manage"
+	PermQRScan         Permission = "qr.scan" // scan qr codes on behalf of other users (X-On-Behalf-Of)
+
+	PermServiceAccountManage Permission = "service_account.manage"
+)
+
+// RolePermissions maps every role to the permissions it grants
+var RolePermissions = map[Role][]Permission{
+	RoleViewer: {
+		PermQRCodeRead, PermQRActionRead,
+	},
+	RoleQREditor: {
+		PermQRCodeRead, PermQRActionRead,
+		PermQRCodeCreate, PermQRCodeDelete, PermQRActionCreate, PermQRActionDelete,
+	},
+	RoleAdmin: {
+		PermQRCodeRead, PermQRActionRead,
+		PermQRCodeCreate, PermQRCodeDelete, PermQRActionCreate, PermQRActionDelete,
+		PermAccountManage, PermQRScan,
+	},
+	RoleSuperAdmin: {
+		PermQRCodeRead, PermQRActionRead,
+		PermQRCodeCreate, PermQRCodeDelete, PermQRActionCreate, PermQRActionDelete,
+		PermAccountManage, PermQRScan, PermRoleManage, PermServiceAccountManage,
+	},
+}
+
+// RoleRanks orders the roles, accounts cannot act on accounts holding a higher role than their own
+var RoleRanks = map[Role]int{
+	RoleViewer:     1,
+	RoleQREditor:   2,
+	RoleAdmin:      3,
+	RoleSuperAdmin: 4,
+}
+
+// RoleAssignment represents a role granted to an account
+type RoleAssignment struct {
+	UserID    gocql.UUID `json:"user_id"`    // account the role belongs to
+	Role      Role       `json:"role"`       // granted role
+	GrantedAt time.Time  `json:"granted_at"` // grant timestamp
+	GrantedBy gocql.UUID `json:"granted_by"` // account that granted the role (zero for migrations)
+}
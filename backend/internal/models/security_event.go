@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// security event types
+const (
+	EventRefreshTokenReuse = "refresh_token_reuse"
+	EventLoginLockout      = "login_lockout"
+	EventLoginUnlock       = "login_unlock"
+	EventAccountDisabled   = "account_disabled"
+	EventAccountEnabled    = "account_enabled"
+	EventForcedLogout      = "forced_logout"
+	EventOIDCLinked        = "oidc_linked"
+	EventAPIKeyCreated     = "api_key_created"
+	EventAPIKeyRevoked     = "api_key_revoked"
+
+	EventAccountDeletionRequested = "account_deletion_requested"
+	EventAccountRestored          = "account_restored"
+	EventAccountPurged            = "account_purged" // recorded under SystemUserID, the account events are purged too
+
+	EventGuestCreated = "guest_created"
+	EventGuestClaimed = "guest_claimed"
+	EventGuestMerged  = "guest_merged" // recorded on the account the guest was merged into
+)
+
+// SystemUserID is used for events that cannot be attributed to an account (e.g. ip lockouts)
+var SystemUserID = gocql.UUID{}
+
+// SecurityEvent represents a security relevant occurrence on an account
+type SecurityEvent struct {
+	UserID    gocql.UUID `json:"user_id"`    // affected user ID
+	EventID   gocql.UUID `json:"event_id"`   // time based event identifier
+	EventType string     `json:"event_type"` // one of the Event* constants
+	DeviceID  gocql.UUID `json:"device_id"`  // device involved (zero if unknown)
+	IP        string     `json:"ip"`         // client ip address
+	Details   string     `json:"details"`    // free form description
+	CreatedAt time.Time  `json:"created_at"` // derived from the event ID
+}
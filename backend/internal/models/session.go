@@ -8,10 +8,30 @@ import (
 
 // PermanentSession represents a long lived authentication session
 type PermanentSession struct {
+	UserID     gocql.UUID `json:"user_id"`     // associated user ID
+	DeviceID   gocql.UUID `json:"device_id"`   // unique device identifier
+	TokenHash  string     `json:"-"`           // hashed refresh token (never exposed)
+	CreatedAt  time.Time  `json:"created_at"`  // creation timestamp
+	LastUsed   time.Time  `json:"last_used"`   // last access timestamp
+	ExpiresAt  time.Time  `json:"expires_at"`  // expiration timestamp
+	FamilyID   gocql.UUID `json:"-"`           // refresh token family, changes on every login
+	DeviceName string     `json:"device_name"` // user assigned device name
+	IP         string     `json:"ip"`          // client ip at login or last refresh
+	UserAgent  string     `json:"user_agent"`  // client user agent at login or last refresh
+}
+
+// SessionMetadata holds client information captured at login and refresh
+type SessionMetadata struct {
+	DeviceName string
+	IP         string
+	UserAgent  string
+}
+
+// RotatedRefreshToken records a refresh token that was replaced during rotation
+type RotatedRefreshToken struct {
+	TokenID   string     `json:"token_id"`   // lookup hash of the rotated token
 	UserID    gocql.UUID `json:"user_id"`    // associated user ID
-	DeviceID  gocql.UUID `json:"device_id"`  // unique device identifier
-	TokenHash string     `json:"token_hash"` // hashed refresh token
-	CreatedAt time.Time  `json:"created_at"` // creation timestamp
-	LastUsed  time.Time  `json:"last_used"`  // last access timestamp
-	ExpiresAt time.Time  `json:"expires_at"` // expiration timestamp
+	DeviceID  gocql.UUID `json:"device_id"`  // device the token was issued to
+	FamilyID  gocql.UUID `json:"family_id"`  // token family at rotation time
+	RotatedAt time.Time  `json:"rotated_at"` // rotation timestamp
 }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// TOTPSecret represents a totp authenticator enrollment
+type TOTPSecret struct {
+	UserID       gocql.UUID `json:"user_id"`    // associated user ID
+	Secret       string     `json:"-"`          // encrypted base32 secret (never exposed)
+	Confirmed    bool       `json:"confirmed"`  // true once the user proved the authenticator works
+	LastUsedStep int64      `json:"-"`          // last accepted time step (replay protection)
+	CreatedAt    time.Time  `json:"created_at"` // enrollment timestamp
+}
+
+// RecoveryCode represents a single use two factor backup code
+type RecoveryCode struct {
+	UserID   gocql.UUID `json:"user_id"` // associated user ID
+	CodeID   gocql.UUID `json:"code_id"` // unique code identifier
+	CodeHash string     `json:"-"`       // hashed code (never exposed)
+}
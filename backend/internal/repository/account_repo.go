@@ -4,6 +4,7 @@ import (
 	"backend/internal/models"
 
 	"errors"
+	"time"
 
 	"github.com/gocql/gocql"
 )
@@ -18,8 +19,13 @@ func NewAccountRepo(session *gocql.Session) *AccountRepository {
 }
 
 func (r *AccountRepository) CreateAccount(account *models.Account) error {
-	// use lightweight transaction to ensure email uniqueness
-	query := `INSERT INTO auth.accounts (id, email, password_hash, created_at, admin) VALUES (?, ?, ?, ?, ?) IF NOT EXISTS`
+	// the email is claimed first, the claim is what keeps emails unique
+	if err := r.claimEmail(account.Email, account.ID); err != nil {
+		return err
+	}
+
+	// use lightweight transaction to never overwrite an existing account
+	query := `INSERT INTO auth.accounts (id, email, password_hash, created_at, verified) VALUES (?, ?, ?, ?, ?) IF NOT EXISTS`
 
 	m := make(map[string]interface{})
 	applied, err := r.session.Query(query,
@@ -27,7 +33,7 @@ func (r *AccountRepository) CreateAccount(account *models.Account) error {
 		account.Email,
 		account.PasswordHash,
 		account.CreatedAt,
-		account.Admin,
+		account.Verified,
 	).MapScanCAS(m) // check if applied
 
 	if err != nil {
@@ -35,24 +41,60 @@ func (r *AccountRepository) CreateAccount(account *models.Account) error {
 	}
 
 	if !applied {
+		r.releaseEmail(account.Email, account.ID)
 		return ErrEmailExists // custom error for duplicate email
 	}
 	return nil
 }
 
+// CreateGuestAccount inserts an account without email (guests claim no email until they are upgraded)
+func (r *AccountRepository) CreateGuestAccount(account *models.Account) error {
+	// the email column stays null, empty values would end up in the email index
+	query := `INSERT INTO auth.accounts (id, created_at, verified, guest) VALUES (?, ?, ?, true) IF NOT EXISTS`
+
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(query,
+		account.ID,
+		account.CreatedAt,
+		account.Verified,
+	).MapScanCAS(m)
+
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return errors.New("account id already in use")
+	}
+	return nil
+}
+
+// ClaimGuestAccount attaches an email and password to a guest account, returns false if it is no longer a guest
+func (r *AccountRepository) ClaimGuestAccount(userID gocql.UUID, email, passwordHash string) (bool, error) {
+	if err := r.claimEmail(email, userID); err != nil {
+		return false, err
+	}
+
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(`UPDATE auth.accounts SET email = ?, password_hash = ?, guest = false WHERE id = ? IF guest = true`,
+		email, passwordHash, userID,
+	).MapScanCAS(m)
+	if err != nil || !applied {
+		r.releaseEmail(email, userID)
+		return false, err
+	}
+	return true, nil
+}
+
+// columns read into models.Account by scanAccount
+const accountColumns = `id, email, password_hash, created_at, verified, disabled, pending_email, deletion_requested_at, guest`
+
 func (r *AccountRepository) GetAccountByEmail(email string) (*models.Account, error) {
 	var account models.Account
 
 	// consistancy level LocalQuorum for stronger consistency (doesnt matter on a single node)
-	query := r.session.Query(`SELECT id, email, password_hash, created_at, admin FROM auth.accounts WHERE email = ? LIMIT 1`, email).Consistency(gocql.LocalQuorum)
+	query := r.session.Query(`SELECT `+accountColumns+` FROM auth.accounts WHERE email = ? LIMIT 1`, email).Consistency(gocql.LocalQuorum)
 
-	err := query.Scan(
-		&account.ID,
-		&account.Email,
-		&account.PasswordHash,
-		&account.CreatedAt,
-		&account.Admin,
-	)
+	err := query.Scan(scanAccount(&account)...)
 
 	if err == gocql.ErrNotFound {
 		return nil, nil // account not found
@@ -67,15 +109,9 @@ func (r *AccountRepository) GetAccountByID(id gocql.UUID) (*models.Account, erro
 	var account models.Account
 
 	// ref. GetAccountByEmail
-	query := r.session.Query(`SELECT id, email, password_hash, created_at, admin FROM auth.accounts WHERE id = ? LIMIT 1`, id).Consistency(gocql.LocalQuorum)
+	query := r.session.Query(`SELECT `+accountColumns+` FROM auth.accounts WHERE id = ? LIMIT 1`, id).Consistency(gocql.LocalQuorum)
 
-	err := query.Scan(
-		&account.ID,
-		&account.Email,
-		&account.PasswordHash,
-		&account.CreatedAt,
-		&account.Admin,
-	)
+	err := query.Scan(scanAccount(&account)...)
 
 	if err == gocql.ErrNotFound {
 		return nil, nil
@@ -83,15 +119,198 @@ func (r *AccountRepository) GetAccountByID(id gocql.UUID) (*models.Account, erro
 	return &account, err
 }
 
+// ListAccounts returns one page of accounts in token order and the paging state for the next page (nil if done)
+func (r *AccountRepository) ListAccounts(pageSize int, pageState []byte) ([]models.Account, []byte, error) {
+	iter := r.session.Query(`SELECT ` + accountColumns + ` FROM auth.accounts`).PageSize(pageSize).PageState(pageState).Iter()
+
+	var accounts []models.Account
+	var account models.Account
+
+	// only consume the rows of the current page
+	for remaining := iter.NumRows(); remaining > 0 && iter.Scan(scanAccount(&account)...); remaining-- {
+		accounts = append(accounts, account)
+	}
+
+	nextPage := iter.PageState()
+	if err := iter.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(nextPage) == 0 {
+		nextPage = nil
+	}
+	return accounts, nextPage, nil
+}
+
+func (r *AccountRepository) SetDisabled(userID gocql.UUID, disabled bool) error {
+	return r.session.Query(`UPDATE auth.accounts SET disabled = ? WHERE id = ?`,
+		disabled, userID,
+	).Exec()
+}
+
+// scan destinations matching accountColumns
+func scanAccount(account *models.Account) []interface{} {
+	return []interface{}{
+		&account.ID,
+		&account.Email,
+		&account.PasswordHash,
+		&account.CreatedAt,
+		&account.Verified,
+		&account.Disabled,
+		&account.PendingEmail,
+		&account.DeletionRequestedAt,
+		&account.Guest,
+	}
+}
+
 func (r *AccountRepository) UpdatePassword(userID gocql.UUID, newHash string) error {
 	return r.session.Query(`UPDATE auth.accounts SET password_hash = ? WHERE id = ?`,
 		newHash, userID,
 	).Exec()
 }
 
-func (r *AccountRepository) DeleteAccount(userID gocql.UUID) error {
+// ReplacePasswordHash swaps the hash only if it was not changed in the meantime (used for parameter upgrades)
+func (r *AccountRepository) ReplacePasswordHash(userID gocql.UUID, oldHash, newHash string) (bool, error) {
+	m := make(map[string]interface{})
+	return r.session.Query(`UPDATE auth.accounts SET password_hash = ? WHERE id = ? IF password_hash = ?`,
+		newHash, userID, oldHash,
+	).MapScanCAS(m)
+}
+
+// MarkVerified sets the verified flag, returns false if the account was already verified
+func (r *AccountRepository) MarkVerified(userID gocql.UUID) (bool, error) {
+	m := make(map[string]interface{})
+	return r.session.Query(`UPDATE auth.accounts SET verified = true WHERE id = ? IF verified = false`,
+		userID,
+	).MapScanCAS(m)
+}
+
+// SetPendingEmail stores the requested new address until it is confirmed (replaces an earlier request)
+func (r *AccountRepository) SetPendingEmail(userID gocql.UUID, email string) error {
+	return r.session.Query(`UPDATE auth.accounts SET pending_email = ? WHERE id = ?`,
+		email, userID,
+	).Exec()
+}
+
+// ClearPendingEmail drops a pending address, returns false if it is no longer the pending one
+func (r *AccountRepository) ClearPendingEmail(userID gocql.UUID, email string) (bool, error) {
+	m := make(map[string]interface{})
+	return r.session.Query(`UPDATE auth.accounts SET pending_email = null WHERE id = ? IF pending_email = ?`,
+		userID, email,
+	).MapScanCAS(m)
+}
+
+// ChangeEmail moves an account from one address to another and marks it verified. the new address
+// is claimed before the account is updated and the old claim is released afterwards, so the address
+// can never belong to two accounts. with requirePending it only applies while the new address is the
+// pending one, which makes confirmations single use. returns false if the account changed in between
+func (r *AccountRepository) ChangeEmail(userID gocql.UUID, from, to string, requirePending bool) (bool, error) {
+	if err := r.claimEmail(to, userID); err != nil {
+		return false, err
+	}
+
+	query := `UPDATE auth.accounts SET email = ?, pending_email = null, verified = true WHERE id = ? IF email = ?`
+	args := []interface{}{to, userID, from}
+	if requirePending {
+		query += ` AND pending_email = ?`
+		args = append(args, to)
+	}
+
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(query, args...).MapScanCAS(m)
+	if err != nil || !applied {
+		r.releaseEmail(to, userID)
+		return false, err
+	}
+
+	r.releaseEmail(from, userID)
+	return true, nil
+}
+
+// MarkForDeletion flags the account and queues it for the purger
+func (r *AccountRepository) MarkForDeletion(userID gocql.UUID, requestedAt time.Time) error {
+	if err := r.session.Query(`UPDATE auth.accounts SET deletion_requested_at = ? WHERE id = ?`, requestedAt, userID).Exec(); err != nil {
+		return err
+	}
+
+	return r.session.Query(`INSERT INTO auth.pending_deletions (user_id, requested_at) VALUES (?, ?)`, userID, requestedAt).Exec()
+}
+
+// ClearDeletion restores an account flagged at requestedAt, returns false if it is not (or no longer) flagged
+func (r *AccountRepository) ClearDeletion(userID gocql.UUID, requestedAt time.Time) (bool, error) {
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(`UPDATE auth.accounts SET deletion_requested_at = null WHERE id = ? IF deletion_requested_at = ?`,
+		userID, requestedAt,
+	).MapScanCAS(m)
+	if err != nil || !applied {
+		return false, err
+	}
+
+	return true, r.session.Query(`DELETE FROM auth.pending_deletions WHERE user_id = ?`, userID).Exec()
+}
+
+// GetPendingDeletions returns all accounts waiting for the purge
+func (r *AccountRepository) GetPendingDeletions() ([]models.PendingDeletion, error) {
+	iter := r.session.Query(`SELECT user_id, requested_at FROM auth.pending_deletions`).Iter()
+
+	var deletions []models.PendingDeletion
+	var deletion models.PendingDeletion
+
+	for iter.Scan(&deletion.UserID, &deletion.RequestedAt) {
+		deletions = append(deletions, deletion)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return deletions, nil
+}
+
+// RemovePendingDeletion dequeues a purged account, returns false if another purger was faster
+func (r *AccountRepository) RemovePendingDeletion(userID gocql.UUID) (bool, error) {
+	m := make(map[string]interface{})
+	return r.session.Query(`DELETE FROM auth.pending_deletions WHERE user_id = ? IF EXISTS`, userID).MapScanCAS(m)
+}
+
+// DeleteAccount removes the account and releases its email
+func (r *AccountRepository) DeleteAccount(userID gocql.UUID, email string) error {
 	query := `DELETE FROM auth.accounts WHERE id = ?`
-	return r.session.Query(query, userID).Exec()
+	if err := r.session.Query(query, userID).Exec(); err != nil {
+		return err
+	}
+
+	// guest accounts hold no email
+	if email == "" {
+		return nil
+	}
+	return r.releaseEmail(email, userID)
+}
+
+// claim an email for an account, returns ErrEmailExists if another account holds it
+func (r *AccountRepository) claimEmail(email string, userID gocql.UUID) error {
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(`INSERT INTO auth.account_emails (email, user_id) VALUES (?, ?) IF NOT EXISTS`,
+		email, userID,
+	).MapScanCAS(m)
+
+	if err != nil {
+		return err
+	}
+
+	// a claim left behind by an interrupted change of the same account can be reused
+	if owner, _ := m["user_id"].(gocql.UUID); !applied && owner != userID {
+		return ErrEmailExists
+	}
+	return nil
+}
+
+// release a claim, only if it still belongs to the account
+func (r *AccountRepository) releaseEmail(email string, userID gocql.UUID) error {
+	m := make(map[string]interface{})
+	_, err := r.session.Query(`DELETE FROM auth.account_emails WHERE email = ? IF user_id = ?`,
+		email, userID,
+	).MapScanCAS(m)
+	return err
 }
 
 // custom error for duplicate email
@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// APIKeyRepository handles database operations for service accounts and their api keys
+type APIKeyRepository struct {
+	session *gocql.Session
+}
+
+func NewAPIKeyRepo(session *gocql.Session) *APIKeyRepository {
+	return &APIKeyRepository{session: session}
+}
+
+func (r *APIKeyRepository) CreateServiceAccount(account *models.ServiceAccount) error {
+	query := `INSERT INTO auth.service_accounts (id, name, description, created_by, created_at) VALUES (?, ?, ?, ?, ?)`
+
+	return r.session.Query(query,
+		account.ID,
+		account.Name,
+		account.Description,
+		account.CreatedBy,
+		account.CreatedAt,
+	).Exec()
+}
+
+func (r *APIKeyRepository) GetServiceAccount(id gocql.UUID) (*models.ServiceAccount, error) {
+	account := &models.ServiceAccount{ID: id}
+
+	err := r.session.Query(`SELECT name, description, created_by, created_at FROM auth.service_accounts WHERE id = ?`, id).
+		Scan(&account.Name, &account.Description, &account.CreatedBy, &account.CreatedAt)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// GetServiceAccounts returns all service accounts (there are only a few, no paging)
+func (r *APIKeyRepository) GetServiceAccounts() ([]models.ServiceAccount, error) {
+	iter := r.session.Query(`SELECT id, name, description, created_by, created_at FROM auth.service_accounts`).Iter()
+
+	var accounts []models.ServiceAccount
+	var account models.ServiceAccount
+
+	for iter.Scan(&account.ID, &account.Name, &account.Description, &account.CreatedBy, &account.CreatedAt) {
+		accounts = append(accounts, account)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func (r *APIKeyRepository) DeleteServiceAccount(id gocql.UUID) error {
+	return r.session.Query(`DELETE FROM auth.service_accounts WHERE id = ?`, id).Exec()
+}
+
+// columns read into models.APIKey by scanAPIKey
+const apiKeyColumns = `id, service_account_id, name, secret_hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+func (r *APIKeyRepository) CreateKey(key *models.APIKey) error {
+	query := `INSERT INTO auth.api_keys (` + apiKeyColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS`
+
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(query,
+		key.ID,
+		key.ServiceAccountID,
+		key.Name,
+		key.SecretHash,
+		scopes,
+		key.CreatedBy,
+		key.CreatedAt,
+		key.ExpiresAt, // zero times are stored as empty values
+		key.LastUsedAt,
+		key.RevokedAt,
+	).MapScanCAS(m)
+
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrAPIKeyExists
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) GetKey(id string) (*models.APIKey, error) {
+	var key models.APIKey
+	var scopes []string
+
+	err := r.session.Query(`SELECT `+apiKeyColumns+` FROM auth.api_keys WHERE id = ?`, id).
+		Scan(scanAPIKey(&key, &scopes)...)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	key.Scopes = toPermissions(scopes)
+	return &key, nil
+}
+
+func (r *APIKeyRepository) GetKeysForServiceAccount(serviceAccountID gocql.UUID) ([]models.APIKey, error) {
+	iter := r.session.Query(`SELECT `+apiKeyColumns+` FROM auth.api_keys WHERE service_account_id = ?`, serviceAccountID).Iter()
+
+	var keys []models.APIKey
+	var key models.APIKey
+	var scopes []string
+
+	for iter.Scan(scanAPIKey(&key, &scopes)...) {
+		key.Scopes = toPermissions(scopes)
+		keys = append(keys, key)
+		key, scopes = models.APIKey{}, nil
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SetExpiry shortens the lifetime of a key (used for the grace period after a rotation)
+func (r *APIKeyRepository) SetExpiry(id string, expiresAt time.Time) error {
+	return r.session.Query(`UPDATE auth.api_keys SET expires_at = ? WHERE id = ?`, expiresAt, id).Exec()
+}
+
+func (r *APIKeyRepository) RevokeKey(id string, revokedAt time.Time) error {
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(`UPDATE auth.api_keys SET revoked_at = ? WHERE id = ? IF EXISTS`, revokedAt, id).MapScanCAS(m)
+
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) UpdateLastUsed(id string, usedAt time.Time) error {
+	return r.session.Query(`UPDATE auth.api_keys SET last_used_at = ? WHERE id = ?`, usedAt, id).Exec()
+}
+
+func (r *APIKeyRepository) DeleteKey(id string) error {
+	return r.session.Query(`DELETE FROM auth.api_keys WHERE id = ?`, id).Exec()
+}
+
+// scan destinations matching apiKeyColumns (scopes are converted by the caller)
+func scanAPIKey(key *models.APIKey, scopes *[]string) []interface{} {
+	return []interface{}{
+		&key.ID,
+		&key.ServiceAccountID,
+		&key.Name,
+		&key.SecretHash,
+		scopes,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	}
+}
+
+func toPermissions(scopes []string) []models.Permission {
+	permissions := make([]models.Permission, 0, len(scopes))
+	for _, scope := range scopes {
+		permissions = append(permissions, models.Permission(scope))
+	}
+	return permissions
+}
+
+// custom errors for api keys
+var (
+	ErrAPIKeyExists   = errors.New("api key id already exists")
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
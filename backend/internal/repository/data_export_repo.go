@@ -0,0 +1,158 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// exportChunkSize keeps single blob writes well below the scylla mutation size limit
+const exportChunkSize = 1 << 20
+
+// DataExportRepository handles database operations for personal data exports
+type DataExportRepository struct {
+	session *gocql.Session
+}
+
+func NewDataExportRepo(session *gocql.Session) *DataExportRepository {
+	return &DataExportRepository{session: session}
+}
+
+// columns read into models.DataExport by scanDataExport
+const dataExportColumns = `user_id, id, format, status, error, size, created_at, completed_at, expires_at`
+
+// CreateExport stores a new export that is removed by scylla at its expiry
+func (r *DataExportRepository) CreateExport(export *models.DataExport) error {
+	query := `INSERT INTO auth.data_exports (` + dataExportColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+
+	return r.session.Query(query,
+		export.UserID,
+		export.ID,
+		export.Format,
+		export.Status,
+		export.Error,
+		export.Size,
+		export.CreatedAt,
+		export.CompletedAt,
+		export.ExpiresAt,
+		ttlUntil(export.ExpiresAt),
+	).Exec()
+}
+
+// UpdateStatus stores the state of an export (updated cells need the remaining ttl as well)
+func (r *DataExportRepository) UpdateStatus(export *models.DataExport) error {
+	return r.session.Query(`UPDATE auth.data_exports USING TTL ? SET status = ?, error = ?, size = ?, completed_at = ? WHERE user_id = ? AND id = ?`,
+		ttlUntil(export.ExpiresAt),
+		export.Status,
+		export.Error,
+		export.Size,
+		export.CompletedAt,
+		export.UserID,
+		export.ID,
+	).Exec()
+}
+
+func (r *DataExportRepository) GetExport(userID, id gocql.UUID) (*models.DataExport, error) {
+	var export models.DataExport
+
+	err := r.session.Query(`SELECT `+dataExportColumns+` FROM auth.data_exports WHERE user_id = ? AND id = ?`, userID, id).
+		Consistency(gocql.LocalQuorum).
+		Scan(scanDataExport(&export)...)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// GetExportsForUser returns the exports of a user, newest first
+func (r *DataExportRepository) GetExportsForUser(userID gocql.UUID) ([]models.DataExport, error) {
+	iter := r.session.Query(`SELECT `+dataExportColumns+` FROM auth.data_exports WHERE user_id = ?`, userID).Iter()
+
+	var exports []models.DataExport
+	var export models.DataExport
+
+	for iter.Scan(scanDataExport(&export)...) {
+		exports = append(exports, export)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return exports, nil
+}
+
+// SaveData stores the generated file in chunks with the ttl of its export
+func (r *DataExportRepository) SaveData(export *models.DataExport, data []byte) error {
+	for chunk := 0; chunk*exportChunkSize < len(data); chunk++ {
+		end := min((chunk+1)*exportChunkSize, len(data))
+
+		err := r.session.Query(`INSERT INTO auth.data_export_chunks (user_id, export_id, chunk, data) VALUES (?, ?, ?, ?) USING TTL ?`,
+			export.UserID,
+			export.ID,
+			chunk,
+			data[chunk*exportChunkSize:end],
+			ttlUntil(export.ExpiresAt),
+		).Exec()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetData reassembles the generated file of an export
+func (r *DataExportRepository) GetData(userID, exportID gocql.UUID) ([]byte, error) {
+	iter := r.session.Query(`SELECT data FROM auth.data_export_chunks WHERE user_id = ? AND export_id = ?`, userID, exportID).
+		PageSize(4). // only a few chunks in memory at once besides the result
+		Iter()
+
+	var data, chunk []byte
+	for iter.Scan(&chunk) {
+		data = append(data, chunk...)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DeleteExportsForUser removes all exports of a user and their files
+func (r *DataExportRepository) DeleteExportsForUser(userID gocql.UUID) error {
+	exports, err := r.GetExportsForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		if err := r.session.Query(`DELETE FROM auth.data_export_chunks WHERE user_id = ? AND export_id = ?`, userID, export.ID).Exec(); err != nil {
+			return err
+		}
+	}
+	return r.session.Query(`DELETE FROM auth.data_exports WHERE user_id = ?`, userID).Exec()
+}
+
+// scan destinations matching dataExportColumns
+func scanDataExport(export *models.DataExport) []interface{} {
+	return []interface{}{
+		&export.UserID,
+		&export.ID,
+		&export.Format,
+		&export.Status,
+		&export.Error,
+		&export.Size,
+		&export.CreatedAt,
+		&export.CompletedAt,
+		&export.ExpiresAt,
+	}
+}
+
+// remaining lifetime in whole seconds for USING TTL (at least one second, 0 would mean forever)
+func ttlUntil(expiresAt time.Time) int {
+	return max(int(time.Until(expiresAt).Seconds()), 1)
+}
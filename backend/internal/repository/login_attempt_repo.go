@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// LoginAttemptRepository handles database operations for failed logins and lockouts
+type LoginAttemptRepository struct {
+	session *gocql.Session
+}
+
+func NewLoginAttemptRepo(session *gocql.Session) *LoginAttemptRepository {
+	return &LoginAttemptRepository{session: session}
+}
+
+// RecordFailure stores a failed attempt that is counted until the ttl expires
+func (r *LoginAttemptRepository) RecordFailure(scope, key string, window time.Duration) error {
+	return r.session.Query(`INSERT INTO auth.login_failures (scope, key, attempt_id) VALUES (?, ?, ?) USING TTL ?`,
+		scope, key, gocql.TimeUUID(), int(window.Seconds()),
+	).Exec()
+}
+
+// CountFailures returns the amount of failed attempts inside the current window
+func (r *LoginAttemptRepository) CountFailures(scope, key string) (int, error) {
+	var count int
+	err := r.session.Query(`SELECT COUNT(*) FROM auth.login_failures WHERE scope = ? AND key = ?`, scope, key).Scan(&count)
+	return count, err
+}
+
+func (r *LoginAttemptRepository) ClearFailures(scope, key string) error {
+	return r.session.Query(`DELETE FROM auth.login_failures WHERE scope = ? AND key = ?`, scope, key).Exec()
+}
+
+func (r *LoginAttemptRepository) GetLockout(scope, key string) (*models.LoginLockout, error) {
+	lockout := &models.LoginLockout{Scope: scope, Key: key}
+
+	err := r.session.Query(`SELECT locked_until, level FROM auth.login_lockouts WHERE scope = ? AND key = ?`, scope, key).
+		Scan(&lockout.LockedUntil, &lockout.Level)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return lockout, nil
+}
+
+// SaveLockout stores a lockout, the row is kept for ttl so the level survives the lock itself
+func (r *LoginAttemptRepository) SaveLockout(lockout *models.LoginLockout, ttl time.Duration) error {
+	return r.session.Query(`INSERT INTO auth.login_lockouts (scope, key, locked_until, level) VALUES (?, ?, ?, ?) USING TTL ?`,
+		lockout.Scope, lockout.Key, lockout.LockedUntil, lockout.Level, int(ttl.Seconds()),
+	).Exec()
+}
+
+func (r *LoginAttemptRepository) DeleteLockout(scope, key string) error {
+	return r.session.Query(`DELETE FROM auth.login_lockouts WHERE scope = ? AND key = ?`, scope, key).Exec()
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// MagicLinkRepository handles database operations for sign-in link tokens and their rate limits
+type MagicLinkRepository struct {
+	session *gocql.Session
+}
+
+func NewMagicLinkRepo(session *gocql.Session) *MagicLinkRepository {
+	return &MagicLinkRepository{session: session}
+}
+
+// CreateToken stores a link token that is removed by scylla after the ttl
+func (r *MagicLinkRepository) CreateToken(token *models.MagicLinkToken, ttl time.Duration) error {
+	query := `INSERT INTO auth.magic_link_tokens (token_hash, user_id, email, device_id, device_name, created_at) VALUES (?, ?, ?, ?, ?, ?) USING TTL ?`
+
+	return r.session.Query(query,
+		token.TokenHash,
+		token.UserID,
+		token.Email,
+		token.DeviceID,
+		token.DeviceName,
+		token.CreatedAt,
+		int(ttl.Seconds()),
+	).Exec()
+}
+
+// GetToken returns a link token without redeeming it, returns nil if the token does not exist
+func (r *MagicLinkRepository) GetToken(tokenHash string) (*models.MagicLinkToken, error) {
+	token := &models.MagicLinkToken{TokenHash: tokenHash}
+
+	err := r.session.Query(`SELECT user_id, email, device_id, device_name, created_at FROM auth.magic_link_tokens WHERE token_hash = ?`, tokenHash).
+		Consistency(gocql.LocalQuorum).
+		Scan(&token.UserID, &token.Email, &token.DeviceID, &token.DeviceName, &token.CreatedAt)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ConsumeToken deletes a link token, returns false if it was already used
+func (r *MagicLinkRepository) ConsumeToken(tokenHash string) (bool, error) {
+	// lightweight transaction so only one concurrent request can redeem the token
+	m := make(map[string]interface{})
+	return r.session.Query(`DELETE FROM auth.magic_link_tokens WHERE token_hash = ? IF EXISTS`, tokenHash).MapScanCAS(m)
+}
+
+// RecordRequest stores a link request that is counted until the ttl expires
+func (r *MagicLinkRepository) RecordRequest(scope, key string, window time.Duration) error {
+	return r.session.Query(`INSERT INTO auth.magic_link_requests (scope, key, request_id) VALUES (?, ?, ?) USING TTL ?`,
+		scope, key, gocql.TimeUUID(), int(window.Seconds()),
+	).Exec()
+}
+
+// GetRequests returns the ids of the requests inside the current window, oldest first
+func (r *MagicLinkRepository) GetRequests(scope, key string) ([]gocql.UUID, error) {
+	iter := r.session.Query(`SELECT request_id FROM auth.magic_link_requests WHERE scope = ? AND key = ?`, scope, key).Iter()
+
+	var requests []gocql.UUID
+	var requestID gocql.UUID
+
+	for iter.Scan(&requestID) {
+		requests = append(requests, requestID)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// OIDCRepository handles database operations for openid connect logins
+type OIDCRepository struct {
+	session *gocql.Session
+}
+
+func NewOIDCRepo(session *gocql.Session) *OIDCRepository {
+	return &OIDCRepository{session: session}
+}
+
+// CreateState stores a started login that is removed by scylla after the ttl
+func (r *OIDCRepository) CreateState(state *models.OIDCState, ttl time.Duration) error {
+	query := `INSERT INTO auth.oidc_states (state, provider, code_verifier, nonce, device_id, device_name, created_at) VALUES (?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+
+	return r.session.Query(query,
+		state.State,
+		state.Provider,
+		state.CodeVerifier,
+		state.Nonce,
+		state.DeviceID,
+		state.DeviceName,
+		state.CreatedAt,
+		int(ttl.Seconds()),
+	).Exec()
+}
+
+// ConsumeState deletes a started login and returns it, returns nil if it does not exist or was already used
+func (r *OIDCRepository) ConsumeState(stateValue string) (*models.OIDCState, error) {
+	state := &models.OIDCState{State: stateValue}
+
+	err := r.session.Query(`SELECT provider, code_verifier, nonce, device_id, device_name, created_at FROM auth.oidc_states WHERE state = ?`, stateValue).
+		Consistency(gocql.LocalQuorum).
+		Scan(&state.Provider, &state.CodeVerifier, &state.Nonce, &state.DeviceID, &state.DeviceName, &state.CreatedAt)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// lightweight transaction so a callback cannot be replayed
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(`DELETE FROM auth.oidc_states WHERE state = ? IF EXISTS`, stateValue).MapScanCAS(m)
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		return nil, nil
+	}
+
+	return state, nil
+}
+
+func (r *OIDCRepository) GetIdentity(provider, subject string) (*models.OIDCIdentity, error) {
+	identity := &models.OIDCIdentity{Provider: provider, Subject: subject}
+
+	err := r.session.Query(`SELECT user_id, email, linked_at FROM auth.oidc_identities WHERE provider = ? AND subject = ?`, provider, subject).
+		Consistency(gocql.LocalQuorum).
+		Scan(&identity.UserID, &identity.Email, &identity.LinkedAt)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// GetIdentitiesForUser returns all provider accounts linked to a local account
+func (r *OIDCRepository) GetIdentitiesForUser(userID gocql.UUID) ([]models.OIDCIdentity, error) {
+	iter := r.session.Query(`SELECT provider, subject, email, linked_at FROM auth.oidc_identities WHERE user_id = ?`, userID).Iter()
+
+	var identities []models.OIDCIdentity
+	identity := models.OIDCIdentity{UserID: userID}
+
+	for iter.Scan(&identity.Provider, &identity.Subject, &identity.Email, &identity.LinkedAt) {
+		identities = append(identities, identity)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// LinkIdentity stores the link, returns false if the provider account is already linked
+func (r *OIDCRepository) LinkIdentity(identity *models.OIDCIdentity) (bool, error) {
+	m := make(map[string]interface{})
+	return r.session.Query(`INSERT INTO auth.oidc_identities (provider, subject, user_id, email, linked_at) VALUES (?, ?, ?, ?, ?) IF NOT EXISTS`,
+		identity.Provider,
+		identity.Subject,
+		identity.UserID,
+		identity.Email,
+		identity.LinkedAt,
+	).MapScanCAS(m)
+}
+
+func (r *OIDCRepository) DeleteIdentity(provider, subject string) error {
+	return r.session.Query(`DELETE FROM auth.oidc_identities WHERE provider = ? AND subject = ?`, provider, subject).Exec()
+}
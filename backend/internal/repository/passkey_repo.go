@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"errors"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// PasskeyRepository handles database operations for webauthn credentials and ceremonies
+type PasskeyRepository struct {
+	session *gocql.Session
+}
+
+func NewPasskeyRepo(session *gocql.Session) *PasskeyRepository {
+	return &PasskeyRepository{session: session}
+}
+
+// columns read into models.PasskeyCredential by scanPasskey
+const passkeyColumns = `user_id, credential_id, public_key, attestation_type, transports, aaguid, sign_count, backup_eligible, backup_state, clone_warning, name, created_at, last_used_at`
+
+func (r *PasskeyRepository) CreateCredential(credential *models.PasskeyCredential) error {
+	query := `INSERT INTO auth.passkey_credentials (` + passkeyColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS`
+
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(query,
+		credential.UserID,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.AttestationType,
+		credential.Transports,
+		credential.AAGUID,
+		credential.SignCount,
+		credential.BackupEligible,
+		credential.BackupState,
+		credential.CloneWarning,
+		credential.Name,
+		credential.CreatedAt,
+		credential.LastUsedAt,
+	).MapScanCAS(m)
+
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrPasskeyExists
+	}
+	return nil
+}
+
+func (r *PasskeyRepository) GetCredentialsForUser(userID gocql.UUID) ([]models.PasskeyCredential, error) {
+	iter := r.session.Query(`SELECT `+passkeyColumns+` FROM auth.passkey_credentials WHERE user_id = ?`, userID).Iter()
+
+	var credentials []models.PasskeyCredential
+	var credential models.PasskeyCredential
+
+	for iter.Scan(scanPasskey(&credential)...) {
+		credentials = append(credentials, credential)
+		credential = models.PasskeyCredential{} // slices are reused by scan otherwise
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// UpdateUsage stores the new counter, returns false if another login changed the counter in the meantime
+func (r *PasskeyRepository) UpdateUsage(userID gocql.UUID, credentialID []byte, oldSignCount, newSignCount int64, backupState bool, usedAt time.Time) (bool, error) {
+	m := make(map[string]interface{})
+	return r.session.Query(`UPDATE auth.passkey_credentials SET sign_count = ?, backup_state = ?, last_used_at = ? WHERE user_id = ? AND credential_id = ? IF sign_count = ?`,
+		newSignCount, backupState, usedAt, userID, credentialID, oldSignCount,
+	).MapScanCAS(m)
+}
+
+// FlagClone blocks a credential whose signature counter went backwards
+func (r *PasskeyRepository) FlagClone(userID gocql.UUID, credentialID []byte) error {
+	return r.session.Query(`UPDATE auth.passkey_credentials SET clone_warning = true WHERE user_id = ? AND credential_id = ?`,
+		userID, credentialID,
+	).Exec()
+}
+
+func (r *PasskeyRepository) RenameCredential(userID gocql.UUID, credentialID []byte, name string) error {
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(`UPDATE auth.passkey_credentials SET name = ? WHERE user_id = ? AND credential_id = ? IF EXISTS`,
+		name, userID, credentialID,
+	).MapScanCAS(m)
+
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+func (r *PasskeyRepository) DeleteCredential(userID gocql.UUID, credentialID []byte) error {
+	return r.session.Query(`DELETE FROM auth.passkey_credentials WHERE user_id = ? AND credential_id = ?`, userID, credentialID).Exec()
+}
+
+func (r *PasskeyRepository) DeleteCredentialsForUser(userID gocql.UUID) error {
+	return r.session.Query(`DELETE FROM auth.passkey_credentials WHERE user_id = ?`, userID).Exec()
+}
+
+// CreateCeremony stores a started ceremony that is removed by scylla after the ttl
+func (r *PasskeyRepository) CreateCeremony(ceremony *models.WebAuthnCeremony, ttl time.Duration) error {
+	query := `INSERT INTO auth.webauthn_ceremonies (id, kind, user_id, email, device_id, device_name, session_data, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+
+	return r.session.Query(query,
+		ceremony.ID,
+		ceremony.Kind,
+		ceremony.UserID,
+		ceremony.Email,
+		ceremony.DeviceID,
+		ceremony.DeviceName,
+		ceremony.SessionData,
+		ceremony.CreatedAt,
+		int(ttl.Seconds()),
+	).Exec()
+}
+
+// ConsumeCeremony deletes a ceremony and returns it, returns nil if it does not exist or was already used
+func (r *PasskeyRepository) ConsumeCeremony(id string) (*models.WebAuthnCeremony, error) {
+	ceremony := &models.WebAuthnCeremony{ID: id}
+
+	err := r.session.Query(`SELECT kind, user_id, email, device_id, device_name, session_data, created_at FROM auth.webauthn_ceremonies WHERE id = ?`, id).
+		Consistency(gocql.LocalQuorum).
+		Scan(&ceremony.Kind, &ceremony.UserID, &ceremony.Email, &ceremony.DeviceID, &ceremony.DeviceName, &ceremony.SessionData, &ceremony.CreatedAt)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// lightweight transaction so a challenge can only be answered once
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(`DELETE FROM auth.webauthn_ceremonies WHERE id = ? IF EXISTS`, id).MapScanCAS(m)
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		return nil, nil
+	}
+
+	return ceremony, nil
+}
+
+// scan destinations matching passkeyColumns
+func scanPasskey(credential *models.PasskeyCredential) []interface{} {
+	return []interface{}{
+		&credential.UserID,
+		&credential.CredentialID,
+		&credential.PublicKey,
+		&credential.AttestationType,
+		&credential.Transports,
+		&credential.AAGUID,
+		&credential.SignCount,
+		&credential.BackupEligible,
+		&credential.BackupState,
+		&credential.CloneWarning,
+		&credential.Name,
+		&credential.CreatedAt,
+		&credential.LastUsedAt,
+	}
+}
+
+// custom errors for passkeys
+var (
+	ErrPasskeyExists   = errors.New("passkey already registered")
+	ErrPasskeyNotFound = errors.New("passkey not found")
+)
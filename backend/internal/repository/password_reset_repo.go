@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// PasswordResetRepository handles database operations for password reset tokens
+type PasswordResetRepository struct {
+	session *gocql.Session
+}
+
+func NewPasswordResetRepo(session *gocql.Session) *PasswordResetRepository {
+	return &PasswordResetRepository{session: session}
+}
+
+// CreateToken stores a reset token that is removed by scylla after the ttl
+func (r *PasswordResetRepository) CreateToken(token *models.PasswordResetToken, ttl time.Duration) error {
+	query := `INSERT INTO auth.password_reset_tokens (token_hash, user_id, created_at) VALUES (?, ?, ?) USING TTL ?`
+
+	return r.session.Query(query,
+		token.TokenHash,
+		token.UserID,
+		token.CreatedAt,
+		int(ttl.Seconds()),
+	).Exec()
+}
+
+// GetToken returns a reset token without redeeming it, returns nil if the token does not exist
+func (r *PasswordResetRepository) GetToken(tokenHash string) (*models.PasswordResetToken, error) {
+	token := &models.PasswordResetToken{TokenHash: tokenHash}
+
+	err := r.session.Query(`SELECT user_id, created_at FROM auth.password_reset_tokens WHERE token_hash = ?`, tokenHash).
+		Consistency(gocql.LocalQuorum).
+		Scan(&token.UserID, &token.CreatedAt)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ConsumeToken deletes a reset token and returns it, returns nil if the token does not exist or was already used
+func (r *PasswordResetRepository) ConsumeToken(tokenHash string) (*models.PasswordResetToken, error) {
+	token, err := r.GetToken(tokenHash)
+	if err != nil || token == nil {
+		return nil, err
+	}
+
+	// lightweight transaction so only one concurrent request can redeem the token
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(`DELETE FROM auth.password_reset_tokens WHERE token_hash = ? IF EXISTS`, tokenHash).MapScanCAS(m)
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		return nil, nil
+	}
+
+	return token, nil
+}
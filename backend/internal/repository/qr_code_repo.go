@@ -78,3 +78,82 @@ func (r *QRCodeRepository) GetAllQRCodes(max_count int) ([]models.QRCode, error)
 
 	return entries, nil
 }
+
+// GetQRCodesByActionID returns up to max_count codes that run the given action
+func (r *QRCodeRepository) GetQRCodesByActionID(action_id gocql.UUID, max_count int) ([]models.QRCode, error) {
+	iter := r.session.Query("SELECT id, action_id, qr_code_type, max_usages, expires_at FROM qr.qr_codes WHERE action_id = ? LIMIT ?", action_id, max_count).Iter()
+
+	var entries []models.QRCode
+	var code models.QRCode
+
+	for iter.Scan(&code.ID, &code.ActionId, &code.QrCodeType, &code.MaxUsages, &code.ExpiresAt) {
+		entries = append(entries, code)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// CreateQRCodes stores many codes with logged batches, codes are plain upserts so a retry after a failure is safe
+func (r *QRCodeRepository) CreateQRCodes(codes []models.QRCode) error {
+	const batchSize = 100 // stays far below the batch size limit
+
+	for start := 0; start < len(codes); start += batchSize {
+		batch := r.session.NewBatch(gocql.LoggedBatch)
+		for _, qr_code := range codes[start:min(start+batchSize, len(codes))] {
+			batch.Query(`INSERT INTO qr.qr_codes (id, action_id, qr_code_type, max_usages, expires_at) VALUES (?, ?, ?, ?, ?)`,
+				qr_code.ID,
+				qr_code.ActionId,
+				qr_code.QrCodeType,
+				qr_code.MaxUsages,
+				qr_code.ExpiresAt,
+			)
+		}
+		if err := r.session.ExecuteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimQRCodeBatch stores a new batch, returns false if a batch with the id already exists
+func (r *QRCodeRepository) ClaimQRCodeBatch(batch *models.QRCodeBatch) (bool, error) {
+	query := `INSERT INTO qr.qr_code_batches (id, action_id, qr_code_type, max_usages, expire_mins, expires_at, code_ids, complete, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, false, ?) IF NOT EXISTS`
+
+	// lightweight transaction so concurrent retries cannot both create codes
+	m := make(map[string]interface{})
+	return r.session.Query(query,
+		batch.ID,
+		batch.ActionId,
+		batch.QrCodeType,
+		batch.MaxUsages,
+		batch.ExpireMins,
+		batch.ExpiresAt,
+		batch.CodeIds,
+		batch.CreatedAt,
+	).MapScanCAS(m)
+}
+
+// GetQRCodeBatch returns a batch, returns nil if it does not exist
+func (r *QRCodeRepository) GetQRCodeBatch(id gocql.UUID) (*models.QRCodeBatch, error) {
+	batch := &models.QRCodeBatch{ID: id}
+
+	err := r.session.Query(`SELECT action_id, qr_code_type, max_usages, expire_mins, expires_at, code_ids, complete, created_at FROM qr.qr_code_batches WHERE id = ?`, id).
+		Consistency(gocql.LocalQuorum).
+		Scan(&batch.ActionId, &batch.QrCodeType, &batch.MaxUsages, &batch.ExpireMins, &batch.ExpiresAt, &batch.CodeIds, &batch.Complete, &batch.CreatedAt)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// CompleteQRCodeBatch marks all codes of a batch as stored
+func (r *QRCodeRepository) CompleteQRCodeBatch(id gocql.UUID) error {
+	return r.session.Query(`UPDATE qr.qr_code_batches SET complete = true WHERE id = ?`, id).Exec()
+}
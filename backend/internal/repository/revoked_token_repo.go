@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// RevokedTokenRepository handles database operations for the access token denylist
+type RevokedTokenRepository struct {
+	session *gocql.Session
+}
+
+func NewRevokedTokenRepo(session *gocql.Session) *RevokedTokenRepository {
+	return &RevokedTokenRepository{session: session}
+}
+
+// RevokeToken stores the jti until the token would have expired anyway
+func (r *RevokedTokenRepository) RevokeToken(token *models.RevokedToken) error {
+	ttl := int(time.Until(token.ExpiresAt).Seconds()) + 1 // round up so the row never expires before the token
+
+	return r.session.Query(`INSERT INTO auth.revoked_tokens (token_id, user_id, revoked_at) VALUES (?, ?, ?) USING TTL ?`,
+		token.TokenID, token.UserID, token.RevokedAt, ttl,
+	).Exec()
+}
+
+func (r *RevokedTokenRepository) IsRevoked(tokenID string) (bool, error) {
+	var userID gocql.UUID
+
+	err := r.session.Query(`SELECT user_id FROM auth.revoked_tokens WHERE token_id = ?`, tokenID).Consistency(gocql.LocalQuorum).Scan(&userID)
+	if err == gocql.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"github.com/gocql/gocql"
+)
+
+// RoleRepository handles database operations for role assignments
+type RoleRepository struct {
+	session *gocql.Session
+}
+
+func NewRoleRepo(session *gocql.Session) *RoleRepository {
+	return &RoleRepository{session: session}
+}
+
+func (r *RoleRepository) GrantRole(assignment *models.RoleAssignment) error {
+	query := `INSERT INTO auth.account_roles (user_id, role, granted_at, granted_by) VALUES (?, ?, ?, ?)`
+
+	return r.session.Query(query,
+		assignment.UserID,
+		string(assignment.Role),
+		assignment.GrantedAt,
+		assignment.GrantedBy,
+	).Exec()
+}
+
+func (r *RoleRepository) RevokeRole(userID gocql.UUID, role models.Role) error {
+	return r.session.Query(`DELETE FROM auth.account_roles WHERE user_id = ? AND role = ?`, userID, string(role)).Exec()
+}
+
+func (r *RoleRepository) GetRolesForUser(userID gocql.UUID) ([]models.RoleAssignment, error) {
+	iter := r.session.Query(`SELECT role, granted_at, granted_by FROM auth.account_roles WHERE user_id = ?`, userID).Iter()
+
+	var assignments []models.RoleAssignment
+	var role string
+	assignment := models.RoleAssignment{UserID: userID}
+
+	for iter.Scan(&role, &assignment.GrantedAt, &assignment.GrantedBy) {
+		assignment.Role = models.Role(role)
+		assignments = append(assignments, assignment)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return assignments, nil
+}
+
+func (r *RoleRepository) DeleteRolesForUser(userID gocql.UUID) error {
+	return r.session.Query(`DELETE FROM auth.account_roles WHERE user_id = ?`, userID).Exec()
+}
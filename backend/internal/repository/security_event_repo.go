@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"github.com/gocql/gocql"
+)
+
+// SecurityEventRepository handles database operations for security events
+type SecurityEventRepository struct {
+	session *gocql.Session
+}
+
+func NewSecurityEventRepo(session *gocql.Session) *SecurityEventRepository {
+	return &SecurityEventRepository{session: session}
+}
+
+func (r *SecurityEventRepository) CreateEvent(event *models.SecurityEvent) error {
+	query := `INSERT INTO auth.security_events (user_id, event_id, event_type, device_id, ip, details) VALUES (?, ?, ?, ?, ?, ?)`
+
+	return r.session.Query(query,
+		event.UserID,
+		event.EventID,
+		event.EventType,
+		event.DeviceID,
+		event.IP,
+		event.Details,
+	).Exec()
+}
+
+// GetEventsForUser returns the newest events of a user
+func (r *SecurityEventRepository) GetEventsForUser(userID gocql.UUID, maxCount int) ([]models.SecurityEvent, error) {
+	iter := r.session.Query(`SELECT event_id, event_type, device_id, ip, details FROM auth.security_events WHERE user_id = ? LIMIT ?`, userID, maxCount).Iter()
+
+	var events []models.SecurityEvent
+	event := models.SecurityEvent{UserID: userID}
+
+	for iter.Scan(&event.EventID, &event.EventType, &event.DeviceID, &event.IP, &event.Details) {
+		event.CreatedAt = event.EventID.Time()
+		events = append(events, event)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *SecurityEventRepository) DeleteEventsForUser(userID gocql.UUID) error {
+	return r.session.Query(`DELETE FROM auth.security_events WHERE user_id = ?`, userID).Exec()
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"backend/internal/models"
 
+	"errors"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -20,8 +21,8 @@ func NewSessionRepo(session *gocql.Session) *SessionRepository {
 func (r *SessionRepository) CreateSession(session *models.PermanentSession) error {
 	// use parameterized query to prevent sql injection
 	query := `INSERT INTO auth.permanent_sessions 
-		(user_id, device_id, token_hash, created_at, last_used, expires_at) 
-		VALUES (?, ?, ?, ?, ?, ?)`
+		(user_id, device_id, token_hash, created_at, last_used, expires_at, family_id, device_name, ip, user_agent) 
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	return r.session.Query(query,
 		session.UserID,
@@ -30,46 +31,148 @@ func (r *SessionRepository) CreateSession(session *models.PermanentSession) erro
 		session.CreatedAt,
 		session.LastUsed,
 		session.ExpiresAt,
+		session.FamilyID,
+		session.DeviceName,
+		session.IP,
+		session.UserAgent,
 	).Exec()
 }
 
-func (r *SessionRepository) GetSession(userID, deviceID gocql.UUID) (*models.PermanentSession, error) {
-	session := &models.PermanentSession{
-		UserID:   userID,
-		DeviceID: deviceID,
-	}
+// columns read into models.PermanentSession by scanSession
+const sessionColumns = `user_id, device_id, token_hash, created_at, last_used, expires_at, family_id, device_name, ip, user_agent`
 
-	query := `SELECT token_hash, expires_at 
+func (r *SessionRepository) GetSession(userID, deviceID gocql.UUID) (*models.PermanentSession, error) {
+	query := `SELECT ` + sessionColumns + ` 
 		FROM auth.permanent_sessions 
 		WHERE user_id = ? AND device_id = ? 
 		LIMIT 1`
 
-	err := r.session.Query(query, userID, deviceID).Scan(
-		&session.TokenHash,
-		&session.ExpiresAt,
-	)
+	var session models.PermanentSession
+	err := r.session.Query(query, userID, deviceID).Scan(scanSession(&session)...)
 
 	if err == gocql.ErrNotFound {
 		return nil, nil
 	}
 
-	return session, err
+	return &session, err
 }
 
-func (r *SessionRepository) RotateSessionToken(userID, deviceID gocql.UUID, newTokenHash string) error {
+// GetSessionsForUser returns every device session of a user
+func (r *SessionRepository) GetSessionsForUser(userID gocql.UUID) ([]models.PermanentSession, error) {
+	query := `SELECT ` + sessionColumns + ` 
+		FROM auth.permanent_sessions 
+		WHERE user_id = ?`
+
+	iter := r.session.Query(query, userID).Iter()
+
+	var sessions []models.PermanentSession
+	var session models.PermanentSession
+
+	for iter.Scan(scanSession(&session)...) {
+		sessions = append(sessions, session)
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// UpdateDeviceName sets the user assigned name of a device session
+func (r *SessionRepository) UpdateDeviceName(userID, deviceID gocql.UUID, deviceName string) error {
+	query := `UPDATE auth.permanent_sessions SET 
+		device_name = ? 
+		WHERE user_id = ? AND device_id = ? 
+		IF EXISTS`
+
+	m := make(map[string]interface{})
+	applied, err := r.session.Query(query, deviceName, userID, deviceID).MapScanCAS(m)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// scan destinations matching sessionColumns
+func scanSession(session *models.PermanentSession) []interface{} {
+	return []interface{}{
+		&session.UserID,
+		&session.DeviceID,
+		&session.TokenHash,
+		&session.CreatedAt,
+		&session.LastUsed,
+		&session.ExpiresAt,
+		&session.FamilyID,
+		&session.DeviceName,
+		&session.IP,
+		&session.UserAgent,
+	}
+}
+
+// RotateSessionToken replaces the token hash only if it still matches the old one,
+// returns false if another request rotated the session first
+func (r *SessionRepository) RotateSessionToken(userID, deviceID gocql.UUID, oldTokenHash, newTokenHash, ip, userAgent string) (bool, error) {
 	query := `UPDATE auth.permanent_sessions SET 
 		token_hash = ?,
-		last_used = ? 
-		WHERE user_id = ? AND device_id = ?`
+		last_used = ?,
+		ip = ?,
+		user_agent = ? 
+		WHERE user_id = ? AND device_id = ?
+		IF token_hash = ?`
 
+	m := make(map[string]interface{})
 	return r.session.Query(query,
 		newTokenHash,
 		time.Now().UTC(),
+		ip,
+		userAgent,
 		userID,
 		deviceID,
+		oldTokenHash,
+	).MapScanCAS(m)
+}
+
+// RecordRotatedToken remembers a replaced refresh token until it would have expired anyway
+func (r *SessionRepository) RecordRotatedToken(token *models.RotatedRefreshToken, ttl time.Duration) error {
+	query := `INSERT INTO auth.rotated_refresh_tokens 
+		(token_id, user_id, device_id, family_id, rotated_at) 
+		VALUES (?, ?, ?, ?, ?) USING TTL ?`
+
+	return r.session.Query(query,
+		token.TokenID,
+		token.UserID,
+		token.DeviceID,
+		token.FamilyID,
+		token.RotatedAt,
+		int(ttl.Seconds()),
 	).Exec()
 }
 
+func (r *SessionRepository) GetRotatedToken(tokenID string) (*models.RotatedRefreshToken, error) {
+	token := &models.RotatedRefreshToken{TokenID: tokenID}
+
+	query := `SELECT user_id, device_id, family_id, rotated_at 
+		FROM auth.rotated_refresh_tokens 
+		WHERE token_id = ?`
+
+	err := r.session.Query(query, tokenID).Scan(
+		&token.UserID,
+		&token.DeviceID,
+		&token.FamilyID,
+		&token.RotatedAt,
+	)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	}
+
+	return token, err
+}
+
 func (r *SessionRepository) DeleteSession(userID, deviceID gocql.UUID) error {
 	query := `DELETE FROM auth.permanent_sessions 
 		WHERE user_id = ? AND device_id = ?`
@@ -81,3 +184,6 @@ func (r *SessionRepository) DeleteAllSessionsForUser(userID gocql.UUID) error {
 		WHERE user_id = ?`
 	return r.session.Query(query, userID).Exec()
 }
+
+// custom error for unknown device sessions
+var ErrSessionNotFound = errors.New("session not found")
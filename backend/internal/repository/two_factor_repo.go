@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"backend/internal/models"
+
+	"github.com/gocql/gocql"
+)
+
+// TwoFactorRepository handles database operations for totp secrets and recovery codes
+type TwoFactorRepository struct {
+	session *gocql.Session
+}
+
+func NewTwoFactorRepo(session *gocql.Session) *TwoFactorRepository {
+	return &TwoFactorRepository{session: session}
+}
+
+// SaveSecret creates or replaces the totp enrollment of a user
+func (r *TwoFactorRepository) SaveSecret(secret *models.TOTPSecret) error {
+	query := `INSERT INTO auth.totp_secrets (user_id, secret, confirmed, last_used_step, created_at) VALUES (?, ?, ?, ?, ?)`
+
+	return r.session.Query(query,
+		secret.UserID,
+		secret.Secret,
+		secret.Confirmed,
+		secret.LastUsedStep,
+		secret.CreatedAt,
+	).Exec()
+}
+
+func (r *TwoFactorRepository) GetSecret(userID gocql.UUID) (*models.TOTPSecret, error) {
+	secret := &models.TOTPSecret{UserID: userID}
+
+	query := r.session.Query(`SELECT secret, confirmed, last_used_step, created_at FROM auth.totp_secrets WHERE user_id = ?`, userID).Consistency(gocql.LocalQuorum)
+
+	err := query.Scan(
+		&secret.Secret,
+		&secret.Confirmed,
+		&secret.LastUsedStep,
+		&secret.CreatedAt,
+	)
+
+	if err == gocql.ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+func (r *TwoFactorRepository) ConfirmSecret(userID gocql.UUID, step int64) error {
+	return r.session.Query(`UPDATE auth.totp_secrets SET confirmed = true, last_used_step = ? WHERE user_id = ?`,
+		step, userID,
+	).Exec()
+}
+
+// UseStep records an accepted time step, returns false if the same or a later step was already used
+func (r *TwoFactorRepository) UseStep(userID gocql.UUID, step int64) (bool, error) {
+	m := make(map[string]interface{})
+	return r.session.Query(`UPDATE auth.totp_secrets SET last_used_step = ? WHERE user_id = ? IF last_used_step < ?`,
+		step, userID, step,
+	).MapScanCAS(m)
+}
+
+func (r *TwoFactorRepository) DeleteSecret(userID gocql.UUID) error {
+	return r.session.Query(`DELETE FROM auth.totp_secrets WHERE user_id = ?`, userID).Exec()
+}
+
+// ReplaceRecoveryCodes removes all existing recovery codes of a user and stores the new ones
+func (r *TwoFactorRepository) ReplaceRecoveryCodes(userID gocql.UUID, codes []models.RecoveryCode) error {
+	batch := r.session.NewBatch(gocql.LoggedBatch)
+	batch.Query(`DELETE FROM auth.recovery_codes WHERE user_id = ?`, userID)
+	for _, code := range codes {
+		batch.Query(`INSERT INTO auth.recovery_codes (user_id, code_id, code_hash) VALUES (?, ?, ?)`,
+			code.UserID, code.CodeID, code.CodeHash,
+		)
+	}
+	return r.session.ExecuteBatch(batch)
+}
+
+func (r *TwoFactorRepository) GetRecoveryCodes(userID gocql.UUID) ([]models.RecoveryCode, error) {
+	iter := r.session.Query(`SELECT code_id, code_hash FROM auth.recovery_codes WHERE user_id = ?`, userID).Iter()
+
+	var codes []models.RecoveryCode
+	var codeID gocql.UUID
+	var codeHash string
+
+	for iter.Scan(&codeID, &codeHash) {
+		codes = append(codes, models.RecoveryCode{UserID: userID, CodeID: codeID, CodeHash: codeHash})
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// ConsumeRecoveryCode deletes a recovery code, returns false if it was already used
+func (r *TwoFactorRepository) ConsumeRecoveryCode(userID, codeID gocql.UUID) (bool, error) {
+	m := make(map[string]interface{})
+	return r.session.Query(`DELETE FROM auth.recovery_codes WHERE user_id = ? AND code_id = ? IF EXISTS`,
+		userID, codeID,
+	).MapScanCAS(m)
+}
+
+func (r *TwoFactorRepository) DeleteRecoveryCodes(userID gocql.UUID) error {
+	return r.session.Query(`DELETE FROM auth.recovery_codes WHERE user_id = ?`, userID).Exec()
+}
@@ -2,11 +2,17 @@ package repository
 
 import (
 	"backend/internal/models"
+	"errors"
 	"fmt"
+	"math/rand"
+	"time"
 
 	"github.com/gocql/gocql"
 )
 
+// a claim that lost against concurrent scans is retried with the winning count this often
+const maxUsageClaimAttempts = 64
+
 type UserQRScanRepository struct {
 	session *gocql.Session
 }
@@ -49,6 +55,24 @@ func (r *UserQRScanRepository) GetUserQrScanByID(user_id, qr_code_id gocql.UUID)
 	return &userQRScan, nil
 }
 
+func (r *UserQRScanRepository) GetUserQRScansByUserId(user_id gocql.UUID) ([]models.UserQRScan, error) {
+	iter := r.session.Query(`SELECT qr_code_id, count FROM qr.user_qr_scans WHERE user_id = ?`, user_id).Iter()
+
+	var entries []models.UserQRScan
+	var qr_code_id gocql.UUID
+	var count int
+
+	for iter.Scan(&qr_code_id, &count) {
+		entries = append(entries, models.UserQRScan{UserId: user_id, QrCodeId: qr_code_id, Count: count})
+	}
+
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
 func (r *UserQRScanRepository) GetGlobalUsageCountByQRCodeId(qr_code_id gocql.UUID) (int, error) {
 	var count int
 	var totalCount int = 0
@@ -66,12 +90,146 @@ func (r *UserQRScanRepository) GetGlobalUsageCountByQRCodeId(qr_code_id gocql.UU
 	return totalCount, nil
 }
 
+// MergeGuestScans adds the scans of a guest to the count of a user, capped at maxCount (0 = no cap).
+// the guest id is stored on the row in the same compare-and-set write, so merging a guest again is a no-op
+func (r *UserQRScanRepository) MergeGuestScans(userId, qrCodeId, guestId gocql.UUID, count, maxCount int) error {
+	for attempt := 0; attempt < maxUsageClaimAttempts; attempt++ {
+		var current int
+		var merged []gocql.UUID
+		err := r.session.Query(`SELECT count, merged_guests FROM qr.user_qr_scans WHERE user_id = ? AND qr_code_id = ?`, userId, qrCodeId).
+			Consistency(gocql.LocalQuorum).Scan(&current, &merged)
+
+		if err == gocql.ErrNotFound {
+			if maxCount > 0 {
+				count = min(count, maxCount)
+			}
+			m := make(map[string]interface{})
+			applied, err := r.session.Query(`INSERT INTO qr.user_qr_scans (user_id, qr_code_id, count, merged_guests) VALUES (?, ?, ?, ?) IF NOT EXISTS`,
+				userId, qrCodeId, count, []gocql.UUID{guestId}).MapScanCAS(m)
+			if err != nil || applied {
+				return err
+			}
+			continue // created concurrently, add to the new row
+		} else if err != nil {
+			return err
+		}
+
+		for _, id := range merged {
+			if id == guestId {
+				return nil
+			}
+		}
+
+		newCount := current + count
+		if maxCount > 0 {
+			newCount = max(current, min(newCount, maxCount))
+		}
+
+		m := make(map[string]interface{})
+		applied, err := r.session.Query(`UPDATE qr.user_qr_scans SET count = ?, merged_guests = merged_guests + ? WHERE user_id = ? AND qr_code_id = ? IF count = ?`,
+			newCount, []gocql.UUID{guestId}, userId, qrCodeId, current).MapScanCAS(m)
+		if err != nil || applied {
+			return err
+		}
+	}
+	return ErrUsageClaimContention
+}
+
+// ClaimGlobalUsage reserves one use of a global qr code, returns false once maxUsages uses are claimed.
+// the usage row is only written with compare-and-set, so concurrent claims never exceed the limit
+func (r *UserQRScanRepository) ClaimGlobalUsage(qrCodeId gocql.UUID, maxUsages int) (bool, error) {
+	var used int
+	err := r.session.Query(`SELECT used FROM qr.qr_code_usages WHERE qr_code_id = ?`, qrCodeId).Consistency(gocql.LocalQuorum).Scan(&used)
+	if err == gocql.ErrNotFound {
+		// codes scanned before claims were tracked start with the uses already counted per user
+		if used, err = r.GetGlobalUsageCountByQRCodeId(qrCodeId); err != nil {
+			return false, err
+		}
+		if used >= maxUsages {
+			return false, nil
+		}
+
+		m := make(map[string]interface{})
+		applied, err := r.session.Query(`INSERT INTO qr.qr_code_usages (qr_code_id, used) VALUES (?, ?) IF NOT EXISTS`, qrCodeId, used+1).MapScanCAS(m)
+		if err != nil {
+			return false, err
+		}
+		if applied {
+			return true, nil
+		}
+		used, _ = m["used"].(int)
+	} else if err != nil {
+		return false, err
+	}
+
+	for attempt := 0; attempt < maxUsageClaimAttempts; attempt++ {
+		if used >= maxUsages {
+			return false, nil
+		}
+
+		m := make(map[string]interface{})
+		applied, err := r.session.Query(`UPDATE qr.qr_code_usages SET used = ? WHERE qr_code_id = ? IF used = ?`, used+1, qrCodeId, used).MapScanCAS(m)
+		if err != nil {
+			return false, err
+		}
+		if applied {
+			return true, nil
+		}
+
+		// the failed condition returns the current count, back off a little so the losers do not collide again
+		used, _ = m["used"].(int)
+		time.Sleep(time.Duration(rand.Intn(min(5*(attempt+1), 50))) * time.Millisecond)
+	}
+	return false, ErrUsageClaimContention
+}
+
+// ReleaseGlobalUsage gives back a claimed use of a global qr code (the scan failed after the claim)
+func (r *UserQRScanRepository) ReleaseGlobalUsage(qrCodeId gocql.UUID) error {
+	var used int
+	err := r.session.Query(`SELECT used FROM qr.qr_code_usages WHERE qr_code_id = ?`, qrCodeId).Consistency(gocql.LocalQuorum).Scan(&used)
+	if err == gocql.ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < maxUsageClaimAttempts; attempt++ {
+		if used <= 0 {
+			return nil
+		}
+
+		m := make(map[string]interface{})
+		applied, err := r.session.Query(`UPDATE qr.qr_code_usages SET used = ? WHERE qr_code_id = ? IF used = ?`, used-1, qrCodeId, used).MapScanCAS(m)
+		if err != nil {
+			return err
+		}
+		if applied {
+			return nil
+		}
+
+		used, _ = m["used"].(int)
+		time.Sleep(time.Duration(rand.Intn(min(5*(attempt+1), 50))) * time.Millisecond)
+	}
+	return ErrUsageClaimContention
+}
+
+// DeleteGlobalUsage removes the claimed uses of a qr code
+func (r *UserQRScanRepository) DeleteGlobalUsage(qrCodeId gocql.UUID) error {
+	return r.session.Query(`DELETE FROM qr.qr_code_usages WHERE qr_code_id = ?`, qrCodeId).Exec()
+}
+
 func (r *UserQRScanRepository) UpdateCount(userId, qrCodeId gocql.UUID, newCount int) error {
 	return r.session.Query(`UPDATE qr.user_qr_scans SET count = ? WHERE user_id = ? AND qr_code_id = ?`,
 		newCount, userId, qrCodeId,
 	).Exec()
 }
 
+// DeleteUserQRScan removes the scan count of one user for one qr code
+func (r *UserQRScanRepository) DeleteUserQRScan(userId, qrCodeId gocql.UUID) error {
+	query := `DELETE FROM qr.user_qr_scans WHERE user_id = ? AND qr_code_id = ?`
+	return r.session.Query(query, userId, qrCodeId).Exec()
+}
+
 func (r *UserQRScanRepository) DeleteUserQRCodeScansByUserId(userId gocql.UUID) error {
 	query := `DELETE FROM qr.user_qr_scans WHERE user_id = ?`
 	return r.session.Query(query, userId).Exec()
@@ -99,3 +257,6 @@ func (r *UserQRScanRepository) DeleteUserQRCodeScansByQRCodeId(qrCodeId gocql.UU
 
 	return nil
 }
+
+// custom errors for qr scans
+var ErrUsageClaimContention = errors.New("too many concurrent scans of this qr code, try again")
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/pkg/mail"
+	"backend/pkg/utils"
+
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const accountRestoreTokenType = "account_restore"
+
+// AccountCleanup removes one kind of per user data when an account is purged (must be idempotent)
+type AccountCleanup func(userID gocql.UUID) error
+
+// cleanup registered under a name for error messages
+type namedCleanup struct {
+	name    string
+	cleanup AccountCleanup
+}
+
+// AccountDeletionService handles self service account deletion. a deleted account is only flagged,
+// the owner can restore it through a mailed link until the grace period ends and the purger removes
+// the account with all its data. features storing new per user data add a cleanup with AddCleanup
+type AccountDeletionService struct {
+	account_repo *repository.AccountRepository
+	session_repo *repository.SessionRepository
+	events       *SecurityEventService
+	cleanups     []namedCleanup
+	mailer       mail.Mailer
+	jwtKeys      *utils.KeySet
+	baseURL      string        // public url used in email links
+	grace        time.Duration // time until a deleted account is purged
+}
+
+// NewAccountDeletionService creates a new account deletion service with the cleanups of the existing tables
+func NewAccountDeletionService(account_repo *repository.AccountRepository, session_repo *repository.SessionRepository, events *SecurityEventService,
+	event_repo *repository.SecurityEventRepository, scan_repo *repository.UserQRScanRepository, role_repo *repository.RoleRepository,
+	two_factor_repo *repository.TwoFactorRepository, passkey_repo *repository.PasskeyRepository, oidc_repo *repository.OIDCRepository,
+	export_repo *repository.DataExportRepository, mailer mail.Mailer, jwtKeys *utils.KeySet, baseURL string, grace time.Duration) *AccountDeletionService {
+
+	s := &AccountDeletionService{
+		account_repo: account_repo,
+		session_repo: session_repo,
+		events:       events,
+		mailer:       mailer,
+		jwtKeys:      jwtKeys,
+		baseURL:      baseURL,
+		grace:        grace,
+	}
+
+	s.AddCleanup("sessions", session_repo.DeleteAllSessionsForUser)
+	s.AddCleanup("qr scans", scan_repo.DeleteUserQRCodeScansByUserId)
+	s.AddCleanup("roles", role_repo.DeleteRolesForUser)
+	s.AddCleanup("two factor secret", two_factor_repo.DeleteSecret)
+	s.AddCleanup("recovery codes", two_factor_repo.DeleteRecoveryCodes)
+	s.AddCleanup("passkeys", passkey_repo.DeleteCredentialsForUser)
+	s.AddCleanup("linked identities", func(userID gocql.UUID) error {
+		identities, err := oidc_repo.GetIdentitiesForUser(userID)
+		if err != nil {
+			return err
+		}
+		for _, identity := range identities {
+			if err := oidc_repo.DeleteIdentity(identity.Provider, identity.Subject); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	s.AddCleanup("data exports", export_repo.DeleteExportsForUser)
+	s.AddCleanup("security events", event_repo.DeleteEventsForUser)
+
+	return s
+}
+
+// AddCleanup registers data to remove on purge, cleanups run in registration order before the account row is deleted
+func (s *AccountDeletionService) AddCleanup(name string, cleanup AccountCleanup) {
+	s.cleanups = append(s.cleanups, namedCleanup{name: name, cleanup: cleanup})
+}
+
+// RequestDeletion flags the account, signs out every device and mails a restore link.
+// reports whether the mail was sent (the deletion is scheduled either way)
+func (s *AccountDeletionService) RequestDeletion(userID gocql.UUID, ip string) (bool, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return false, err
+	}
+	if account == nil {
+		return false, ErrAccountNotFound
+	}
+
+	// scylla stores milliseconds, the restore token has to match the stored value
+	requestedAt := time.Now().UTC().Truncate(time.Millisecond)
+	if err := s.account_repo.MarkForDeletion(userID, requestedAt); err != nil {
+		return false, errors.New("failed to schedule deletion - " + err.Error())
+	}
+
+	if err := s.session_repo.DeleteAllSessionsForUser(userID); err != nil {
+		return false, errors.New("failed to delete associated sessions - " + err.Error())
+	}
+
+	purgeAt := requestedAt.Add(s.grace)
+	if err := s.events.Record(userID, models.EventAccountDeletionRequested, gocql.UUID{}, ip, "purge at "+purgeAt.Format(time.RFC3339)); err != nil {
+		return false, err
+	}
+
+	// guest accounts have no email to send the link to
+	if account.Guest {
+		return false, nil
+	}
+	return s.sendRestoreEmail(account, requestedAt) == nil, nil
+}
+
+// DeleteImmediately removes an account with all its data without grace period (e.g. a guest merged into another account)
+func (s *AccountDeletionService) DeleteImmediately(account *models.Account) error {
+	return s.deleteAccount(account.ID, account)
+}
+
+// RestoreAccount redeems a restore token and lifts the pending deletion
+func (s *AccountDeletionService) RestoreAccount(token string) (*models.Account, error) {
+	claims, err := utils.ValidateToken(token, accountRestoreTokenType, s.jwtKeys)
+	if err != nil {
+		return nil, ErrInvalidRestoreToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	requestedAtMillis, _ := claims["requested_at"].(float64)
+
+	userID, err := gocql.ParseUUID(subject)
+	if err != nil {
+		return nil, ErrInvalidRestoreToken
+	}
+
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the token belongs to this deletion request (a later deletion needs its own link)
+	requestedAt := time.UnixMilli(int64(requestedAtMillis)).UTC()
+	if account == nil || !account.DeletionRequestedAt.Equal(requestedAt) {
+		return nil, ErrInvalidRestoreToken
+	}
+
+	// the purger may already be running once the grace period is over
+	if time.Since(requestedAt) >= s.grace {
+		return nil, ErrInvalidRestoreToken
+	}
+
+	restored, err := s.account_repo.ClearDeletion(userID, account.DeletionRequestedAt)
+	if err != nil {
+		return nil, err
+	}
+	if !restored {
+		return nil, ErrInvalidRestoreToken
+	}
+
+	if err := s.events.Record(userID, models.EventAccountRestored, gocql.UUID{}, "", ""); err != nil {
+		return nil, err
+	}
+
+	account.DeletionRequestedAt = time.Time{}
+	return account, nil
+}
+
+// PurgeDue removes every account whose grace period ended, returns the number of purged accounts
+func (s *AccountDeletionService) PurgeDue(now time.Time) (int, error) {
+	deletions, err := s.account_repo.GetPendingDeletions()
+	if err != nil {
+		return 0, err
+	}
+
+	// one failing account must not hold back the others, the last error is returned
+	purged := 0
+	var purgeErr error
+	for _, deletion := range deletions {
+		if now.Sub(deletion.RequestedAt) < s.grace {
+			continue
+		}
+
+		done, err := s.purge(deletion)
+		if err != nil {
+			purgeErr = errors.New("failed to purge account " + deletion.UserID.String() + " - " + err.Error())
+			continue
+		}
+		if done {
+			purged++
+		}
+	}
+	return purged, purgeErr
+}
+
+// RunPurger purges due accounts every interval until the process exits
+func (s *AccountDeletionService) RunPurger(interval time.Duration, logger *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		purged, err := s.PurgeDue(time.Now())
+		if err != nil {
+			logger.Printf("account purge failed: %v", err)
+		}
+		if purged > 0 {
+			logger.Printf("purged %d deleted accounts", purged)
+		}
+	}
+}
+
+// remove all data of one account. every step is idempotent, a failed purge is retried on the next
+// run and concurrent purgers on other instances only record the purge once
+func (s *AccountDeletionService) purge(deletion models.PendingDeletion) (bool, error) {
+	account, err := s.account_repo.GetAccountByID(deletion.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	// restored in the meantime, only the queue entry is left over
+	if account != nil && !account.PendingDeletion() {
+		_, err := s.account_repo.RemovePendingDeletion(deletion.UserID)
+		return false, err
+	}
+
+	if err := s.deleteAccount(deletion.UserID, account); err != nil {
+		return false, err
+	}
+
+	dequeued, err := s.account_repo.RemovePendingDeletion(deletion.UserID)
+	if err != nil || !dequeued {
+		return false, err
+	}
+
+	// the account events were purged, the purge itself is kept without personal data
+	return true, s.events.Record(models.SystemUserID, models.EventAccountPurged, gocql.UUID{}, "",
+		"account "+deletion.UserID.String()+" deleted at "+deletion.RequestedAt.Format(time.RFC3339))
+}
+
+// run all cleanups, then remove the account row (account is nil if only the data is left over)
+func (s *AccountDeletionService) deleteAccount(userID gocql.UUID, account *models.Account) error {
+	for _, step := range s.cleanups {
+		if err := step.cleanup(userID); err != nil {
+			return errors.New("could not delete " + step.name + " - " + err.Error())
+		}
+	}
+
+	// the account row goes last, until then the account stays flagged and blocked
+	if account != nil {
+		return s.account_repo.DeleteAccount(account.ID, account.Email)
+	}
+	return nil
+}
+
+// mail the link that restores the account during the grace period
+func (s *AccountDeletionService) sendRestoreEmail(account *models.Account, requestedAt time.Time) error {
+	token, err := utils.GenerateTypedToken(
+		account.ID.String(),
+		accountRestoreTokenType,
+		map[string]interface{}{"requested_at": requestedAt.UnixMilli()},
+		s.jwtKeys,
+		s.grace,
+	)
+	if err != nil {
+		return errors.New("failed to create restore token - " + err.Error())
+	}
+
+	link := s.baseURL + "/auth/restore_account?token=" + url.QueryEscape(token)
+	body := "Hi,\n\n" +
+		"Your account was deleted and all of your data will be removed permanently in " + strconv.Itoa(int(s.grace.Hours()/24)) + " days. " +
+		"If you change your mind, open the following link before then to restore your account:\n\n" +
+		link + "\n\n" +
+		"If you did not delete your account, restore it and change your password.\n"
+
+	return s.mailer.Send(account.Email, "Your account will be deleted", body)
+}
+
+// custom errors for account deletion
+var (
+	ErrAccountPendingDeletion = errors.New("account is scheduled for deletion, use the link in the deletion email to restore it")
+	ErrInvalidRestoreToken    = errors.New("invalid or expired restore token")
+)
@@ -3,9 +3,13 @@ package service
 import (
 	"backend/internal/models"
 	"backend/internal/repository"
+	"backend/pkg/mail"
 	"backend/pkg/utils"
 
 	"errors"
+	"log"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -13,28 +17,89 @@ import (
 
 // AccountService handles business logic for user accounts
 type AccountService struct {
-	account_repo *repository.AccountRepository
-	session_repo *repository.SessionRepository
-	pepper       string
+	account_repo    *repository.AccountRepository
+	session_repo    *repository.SessionRepository
+	passkey_repo    *repository.PasskeyRepository
+	oidc_repo       *repository.OIDCRepository
+	mailer          mail.Mailer
+	pepper          string
+	passwordParams  utils.Argon2Params    // argon2 parameters for new password hashes
+	passwordPolicy  *utils.PasswordPolicy // rules new passwords must satisfy
+	jwtKeys         *utils.KeySet
+	baseURL         string        // public url used in email links
+	verificationTTL time.Duration // lifespan of email verification and email change tokens
+	cancelTTL       time.Duration // lifespan of the link that undoes an email change
+	logger          *log.Logger
 }
 
 // NewAccountService creates a new account service instance
-func NewAccountService(account_repo *repository.AccountRepository, session_repo *repository.SessionRepository, pepper string) *AccountService {
+func NewAccountService(account_repo *repository.AccountRepository, session_repo *repository.SessionRepository, passkey_repo *repository.PasskeyRepository, oidc_repo *repository.OIDCRepository, mailer mail.Mailer, pepper string, passwordParams utils.Argon2Params, passwordPolicy *utils.PasswordPolicy, jwtKeys *utils.KeySet, baseURL string, verificationTTL, cancelTTL time.Duration, logger *log.Logger) *AccountService {
 	return &AccountService{
-		account_repo: account_repo,
-		session_repo: session_repo,
-		pepper:       pepper,
+		account_repo:    account_repo,
+		session_repo:    session_repo,
+		passkey_repo:    passkey_repo,
+		oidc_repo:       oidc_repo,
+		mailer:          mailer,
+		pepper:          pepper,
+		passwordParams:  passwordParams,
+		passwordPolicy:  passwordPolicy,
+		jwtKeys:         jwtKeys,
+		baseURL:         baseURL,
+		verificationTTL: verificationTTL,
+		cancelTTL:       cancelTTL,
+		logger:          logger,
 	}
 }
 
+// RegisterPasswordlessAccount creates an account without password under a given ID (e.g. the passkey user handle)
+func (s *AccountService) RegisterPasswordlessAccount(userID gocql.UUID, email string) (*models.Account, error) {
+	if !utils.ValidateEmail(email) {
+		return nil, errors.New("invalid email format")
+	}
+
+	existing, err := s.account_repo.GetAccountByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, repository.ErrEmailExists
+	}
+
+	account := &models.Account{
+		ID:        userID,
+		Email:     email,
+		CreatedAt: time.Now().UTC(),
+		Verified:  false, // unlocked by the verification link
+	}
+
+	if err := s.account_repo.CreateAccount(account); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// HasPassword reports whether the account can sign in with a password
+func (s *AccountService) HasPassword(userID gocql.UUID) (bool, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return false, err
+	}
+	if account == nil {
+		return false, ErrAccountNotFound
+	}
+
+	return account.PasswordHash != "", nil
+}
+
 // RegisterAccount creates a new user account with proper validation
 func (s *AccountService) RegisterAccount(email, password string) (*models.Account, error) {
 	// validate input
 	if !utils.ValidateEmail(email) {
 		return nil, errors.New("invalid email format")
 	}
-	if !utils.ValidatePassword(password) {
-		return nil, errors.New("password must be at least 8 characters and contain at least one number, one uppercase letter, one lowercase letter")
+	if err := s.passwordPolicy.Validate(password, email); err != nil {
+		return nil, err
 	}
 
 	// check if account exists
@@ -47,7 +112,7 @@ func (s *AccountService) RegisterAccount(email, password string) (*models.Accoun
 	}
 
 	// hash password with server side pepper
-	hash, err := utils.HashPassword(password, s.pepper)
+	hash, err := utils.HashPassword(password, s.pepper, s.passwordParams)
 	if err != nil {
 		return nil, err
 	}
@@ -60,6 +125,7 @@ func (s *AccountService) RegisterAccount(email, password string) (*models.Accoun
 		Email:        email,
 		PasswordHash: hash,
 		CreatedAt:    time.Now().UTC(),
+		Verified:     false, // unlocked by the verification link
 	}
 
 	// persist to database
@@ -70,41 +136,244 @@ func (s *AccountService) RegisterAccount(email, password string) (*models.Accoun
 	return account, nil
 }
 
+// SendVerificationEmail mails a signed verification link to the account email
+func (s *AccountService) SendVerificationEmail(account *models.Account) error {
+	if account.Guest {
+		return ErrGuestAccount
+	}
+	if account.Verified {
+		return ErrAlreadyVerified
+	}
+
+	// token is bound to the email so it becomes useless if the address changes
+	token, err := utils.GenerateTypedToken(
+		account.ID.String(),
+		verificationTokenType,
+		map[string]interface{}{"email": account.Email},
+		s.jwtKeys,
+		s.verificationTTL,
+	)
+	if err != nil {
+		return errors.New("failed to create verification token - " + err.Error())
+	}
+
+	link := s.baseURL + "/auth/verify_email?token=" + url.QueryEscape(token)
+	body := "Welcome!\n\n" +
+		"Please confirm your email address by opening the following link:\n\n" +
+		link + "\n\n" +
+		"The link expires in " + strconv.Itoa(int(s.verificationTTL.Hours())) + " hours. If you did not create an account you can ignore this email.\n"
+
+	return s.mailer.Send(account.Email, "Confirm your email address", body)
+}
+
+// ResendVerificationEmail sends a new verification link to an unverified account
+func (s *AccountService) ResendVerificationEmail(userID gocql.UUID) error {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil || account == nil {
+		return errors.New("account not found")
+	}
+
+	return s.SendVerificationEmail(account)
+}
+
+// VerifyEmail redeems a verification token and marks the account as verified
+func (s *AccountService) VerifyEmail(token string) (*models.Account, error) {
+	claims, err := utils.ValidateToken(token, verificationTokenType, s.jwtKeys)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	email, _ := claims["email"].(string)
+
+	userID, err := gocql.ParseUUID(subject)
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.Email != email {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	// conditional update makes the token single use
+	applied, err := s.account_repo.MarkVerified(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		return nil, ErrAlreadyVerified
+	}
+
+	account.Verified = true
+	return account, nil
+}
+
+// ConfirmEmailOwnership verifies an account whose owner proved the address through a reset or sign-in link.
+// anyone could have registered the unverified account, so its passkeys, linked identities and sessions are
+// removed and the password is replaced by passwordHash (empty removes it) before the owner takes it over
+func (s *AccountService) ConfirmEmailOwnership(account *models.Account, passwordHash string) error {
+	if account.Verified {
+		return nil
+	}
+
+	// removed before the account is marked, a failed cleanup is retried with the next link
+	if err := s.account_repo.UpdatePassword(account.ID, passwordHash); err != nil {
+		return errors.New("failed to replace password - " + err.Error())
+	}
+	if err := s.passkey_repo.DeleteCredentialsForUser(account.ID); err != nil {
+		return errors.New("failed to remove passkeys - " + err.Error())
+	}
+
+	identities, err := s.oidc_repo.GetIdentitiesForUser(account.ID)
+	if err != nil {
+		return errors.New("failed to get linked identities - " + err.Error())
+	}
+	for _, identity := range identities {
+		if err := s.oidc_repo.DeleteIdentity(identity.Provider, identity.Subject); err != nil {
+			return errors.New("failed to unlink identity - " + err.Error())
+		}
+	}
+
+	if err := s.session_repo.DeleteAllSessionsForUser(account.ID); err != nil {
+		return errors.New("failed to delete associated sessions - " + err.Error())
+	}
+
+	if _, err := s.account_repo.MarkVerified(account.ID); err != nil {
+		return err
+	}
+
+	account.Verified = true
+	account.PasswordHash = passwordHash
+	return nil
+}
+
+// IsVerified reports whether the account has confirmed its email address
+func (s *AccountService) IsVerified(userID gocql.UUID) (bool, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return false, err
+	}
+	if account == nil {
+		return false, errors.New("account not found")
+	}
+
+	return account.Verified, nil
+}
+
+// IsGuest reports whether the account is an unclaimed guest account
+func (s *AccountService) IsGuest(userID gocql.UUID) (bool, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return false, err
+	}
+	if account == nil {
+		return false, errors.New("account not found")
+	}
+
+	return account.Guest, nil
+}
+
+// IsDisabled reports whether an admin blocked the account or it is scheduled for deletion
+// (missing accounts count as disabled)
+func (s *AccountService) IsDisabled(userID gocql.UUID) (bool, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return false, err
+	}
+
+	return account == nil || account.Disabled || account.PendingDeletion(), nil
+}
+
 // Authenticate verifies user credentials and returns account
 func (s *AccountService) Authenticate(email, password string) (*models.Account, error) {
 	// retrieve account
 	account, err := s.account_repo.GetAccountByEmail(email)
-	if err != nil || account == nil {
-		return nil, errors.New("invalid credentials - " + err.Error())
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	// passwordless accounts (passkey or provider login only) cannot sign in with a password
+	if account.PasswordHash == "" {
+		return nil, ErrInvalidCredentials
 	}
 
 	// verify password
 	if !utils.CheckPasswordHash(password, s.pepper, account.PasswordHash) {
-		return nil, errors.New("invalid credentials")
+		return nil, ErrInvalidCredentials
+	}
+
+	// only reveal the disabled state to someone who knows the password
+	if account.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	if account.PendingDeletion() {
+		return nil, ErrAccountPendingDeletion
+	}
+
+	// the plain password is only available here, upgrade old formats and parameters
+	if utils.PasswordNeedsRehash(account.PasswordHash, s.passwordParams) {
+		// best effort, retried on the next login
+		if err := s.rehashPassword(account, password); err != nil {
+			s.logger.Printf("failed to rehash password of account %s: %v", account.ID, err)
+		}
 	}
 
 	return account, nil
 }
 
-// ChangePassword updates a user's password
-func (s *AccountService) ChangePassword(userID gocql.UUID, oldPassword, newPassword string) error {
-	if !utils.ValidatePassword(newPassword) {
-		return errors.New("password must be at least 8 characters and contain at least one number, one uppercase letter, one lowercase letter")
+// store a hash with the current parameters unless the password was changed concurrently
+func (s *AccountService) rehashPassword(account *models.Account, password string) error {
+	newHash, err := utils.HashPassword(password, s.pepper, s.passwordParams)
+	if err != nil {
+		return err
+	}
+
+	replaced, err := s.account_repo.ReplacePasswordHash(account.ID, account.PasswordHash, newHash)
+	if err != nil {
+		return err
+	}
+	if replaced {
+		account.PasswordHash = newHash
 	}
+	return nil
+}
 
+// ChangePassword updates a user's password
+func (s *AccountService) ChangePassword(userID gocql.UUID, oldPassword, newPassword string) error {
 	// retrieve account
 	account, err := s.account_repo.GetAccountByID(userID)
 	if err != nil || account == nil {
 		return errors.New("account not found")
 	}
 
+	// a password without email could never be used, guests set both when claiming the account
+	if account.Guest {
+		return ErrGuestAccount
+	}
+
+	// a stolen session alone must not add a password, passwordless accounts set their first one through the reset email
+	if account.PasswordHash == "" {
+		return ErrPasswordNotSet
+	}
+
+	if err := s.passwordPolicy.Validate(newPassword, account.Email); err != nil {
+		return err
+	}
+
 	// verify current password
 	if !utils.CheckPasswordHash(oldPassword, s.pepper, account.PasswordHash) {
 		return errors.New("invalid current password")
 	}
 
 	// generate new hash
-	newHash, err := utils.HashPassword(newPassword, s.pepper)
+	newHash, err := utils.HashPassword(newPassword, s.pepper, s.passwordParams)
 	if err != nil {
 		return err
 	}
@@ -113,23 +382,177 @@ func (s *AccountService) ChangePassword(userID gocql.UUID, oldPassword, newPassw
 	return s.account_repo.UpdatePassword(userID, newHash)
 }
 
-// ChangePassword updates a user's password
-func (s *AccountService) DeleteAccount(userID gocql.UUID) error {
-	// retrieve account
+// RequestEmailChange stores the new address as pending and mails a confirmation link to it.
+// the current address is notified with a link to cancel (or later undo) the change
+func (s *AccountService) RequestEmailChange(userID gocql.UUID, password, newEmail string) error {
+	if !utils.ValidateEmail(newEmail) {
+		return errors.New("invalid email format")
+	}
+
 	account, err := s.account_repo.GetAccountByID(userID)
 	if err != nil || account == nil {
 		return errors.New("account not found")
 	}
 
-	err = s.account_repo.DeleteAccount(userID)
+	// the password proves the request does not come from a stolen session alone
+	if account.PasswordHash == "" {
+		return ErrPasswordNotSet
+	}
+	if !utils.CheckPasswordHash(password, s.pepper, account.PasswordHash) {
+		return errors.New("invalid current password")
+	}
+
+	if newEmail == account.Email {
+		return ErrEmailUnchanged
+	}
+	existing, err := s.account_repo.GetAccountByEmail(newEmail)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return repository.ErrEmailExists
+	}
+
+	if err := s.account_repo.SetPendingEmail(userID, newEmail); err != nil {
+		return errors.New("failed to store pending email - " + err.Error())
+	}
+
+	// both tokens name both addresses, a newer request makes the older links useless
+	claims := map[string]interface{}{"email": newEmail, "old_email": account.Email}
+
+	confirmToken, err := utils.GenerateTypedToken(userID.String(), emailChangeTokenType, claims, s.jwtKeys, s.verificationTTL)
+	if err != nil {
+		return errors.New("failed to create email change token - " + err.Error())
+	}
+	cancelToken, err := utils.GenerateTypedToken(userID.String(), emailChangeCancelTokenType, claims, s.jwtKeys, s.cancelTTL)
 	if err != nil {
-		return errors.New("failed to delete account - " + err.Error())
+		return errors.New("failed to create email change token - " + err.Error())
 	}
 
-	err = s.session_repo.DeleteAllSessionsForUser(userID)
+	link := s.baseURL + "/auth/confirm_email_change?token=" + url.QueryEscape(confirmToken)
+	body := "Hi,\n\n" +
+		"Please confirm that this should become the new email address of your account by opening the following link:\n\n" +
+		link + "\n\n" +
+		"The link expires in " + strconv.Itoa(int(s.verificationTTL.Hours())) + " hours. If you did not request this change you can ignore this email.\n"
+
+	if err := s.mailer.Send(newEmail, "Confirm your new email address", body); err != nil {
+		return errors.New("failed to send confirmation email - " + err.Error())
+	}
+
+	link = s.baseURL + "/auth/cancel_email_change?token=" + url.QueryEscape(cancelToken)
+	body = "Hi,\n\n" +
+		"A change of your account email address to " + newEmail + " was requested. " +
+		"If this was not you, open the following link to cancel the change and sign out every device:\n\n" +
+		link + "\n\n" +
+		"The link also undoes the change after it was confirmed and stays valid for " + strconv.Itoa(int(s.cancelTTL.Hours()/24)) + " days.\n"
+
+	return s.mailer.Send(account.Email, "Your email address is being changed", body)
+}
+
+// ConfirmEmailChange redeems a confirmation token and switches the account to the pending address
+func (s *AccountService) ConfirmEmailChange(token string) (*models.Account, error) {
+	account, oldEmail, newEmail, err := s.parseEmailChangeToken(token, emailChangeTokenType)
 	if err != nil {
-		return errors.New("failed to delete associated sessions - " + err.Error())
+		return nil, err
+	}
+	if account.Email != oldEmail || account.PendingEmail != newEmail {
+		return nil, ErrInvalidEmailChangeToken
 	}
 
-	return nil
+	// conditional on the pending address, so the token is single use
+	applied, err := s.account_repo.ChangeEmail(account.ID, oldEmail, newEmail, true)
+	if err != nil {
+		return nil, err
+	}
+	if !applied {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	account.Email = newEmail
+	account.PendingEmail = ""
+	account.Verified = true
+	return account, nil
+}
+
+// CancelEmailChange drops a pending change or, once it was confirmed, moves the account back to the
+// previous address. an undone change is treated as a takeover attempt and signs out every device
+func (s *AccountService) CancelEmailChange(token string) error {
+	account, oldEmail, newEmail, err := s.parseEmailChangeToken(token, emailChangeCancelTokenType)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case account.Email == oldEmail && account.PendingEmail == newEmail:
+		cleared, err := s.account_repo.ClearPendingEmail(account.ID, newEmail)
+		if err != nil {
+			return err
+		}
+		if !cleared {
+			return ErrInvalidEmailChangeToken
+		}
+		return nil
+
+	case account.Email == newEmail:
+		reverted, err := s.account_repo.ChangeEmail(account.ID, newEmail, oldEmail, false)
+		if err != nil {
+			return err
+		}
+		if !reverted {
+			return ErrInvalidEmailChangeToken
+		}
+
+		if err := s.session_repo.DeleteAllSessionsForUser(account.ID); err != nil {
+			return errors.New("failed to delete associated sessions - " + err.Error())
+		}
+		return nil
+
+	default:
+		return ErrInvalidEmailChangeToken
+	}
 }
+
+// validate an email change token and load its account
+func (s *AccountService) parseEmailChangeToken(token, tokenType string) (*models.Account, string, string, error) {
+	claims, err := utils.ValidateToken(token, tokenType, s.jwtKeys)
+	if err != nil {
+		return nil, "", "", ErrInvalidEmailChangeToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	newEmail, _ := claims["email"].(string)
+	oldEmail, _ := claims["old_email"].(string)
+
+	userID, err := gocql.ParseUUID(subject)
+	if err != nil || newEmail == "" || oldEmail == "" {
+		return nil, "", "", ErrInvalidEmailChangeToken
+	}
+
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if account == nil {
+		return nil, "", "", ErrInvalidEmailChangeToken
+	}
+
+	return account, oldEmail, newEmail, nil
+}
+
+const (
+	verificationTokenType      = "email_verification"
+	emailChangeTokenType       = "email_change"
+	emailChangeCancelTokenType = "email_change_cancel"
+)
+
+// custom errors for account operations
+var (
+	ErrInvalidCredentials       = errors.New("invalid credentials")
+	ErrAccountDisabled          = errors.New("account disabled")
+	ErrAlreadyVerified          = errors.New("email already verified")
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+	ErrEmailNotVerified         = errors.New("email address not verified")
+	ErrEmailUnchanged           = errors.New("new email is the current email")
+	ErrPasswordNotSet           = errors.New("this account has no password, set one through the password reset first")
+	ErrInvalidEmailChangeToken  = errors.New("invalid or expired email change token")
+)
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/pkg/utils"
+
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// APIKeyPrefix marks api keys so they can be told apart from jwts (and found by secret scanners)
+const APIKeyPrefix = "mpg_"
+
+const (
+	apiKeyIDLength          = 16          // hex characters of the public key id
+	apiKeyLastUsedPrecision = time.Minute // last_used_at is only written once per interval
+)
+
+// APIKeyService manages service accounts and authenticates their api keys.
+// keys look like mpg_<id>_<secret>, only an hmac of the secret is stored
+type APIKeyService struct {
+	repo                 *repository.APIKeyRepository
+	authorizationService *AuthorizationService
+	events               *SecurityEventService
+	pepper               string
+	rotationGrace        time.Duration
+}
+
+// NewAPIKeyService creates a new api key service instance
+func NewAPIKeyService(repo *repository.APIKeyRepository, authorizationService *AuthorizationService, events *SecurityEventService, pepper string, rotationGrace time.Duration) *APIKeyService {
+	return &APIKeyService{
+		repo:                 repo,
+		authorizationService: authorizationService,
+		events:               events,
+		pepper:               pepper,
+		rotationGrace:        rotationGrace,
+	}
+}
+
+// IsAPIKey reports whether a bearer credential is an api key rather than a jwt
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// CreateServiceAccount registers a new non human principal
+func (s *APIKeyService) CreateServiceAccount(name, description string, createdBy gocql.UUID) (*models.ServiceAccount, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, ErrServiceAccountName
+	}
+
+	id, _ := gocql.RandomUUID() // ignoring error since it should never fail
+	account := &models.ServiceAccount{
+		ID:          id,
+		Name:        name,
+		Description: description,
+		CreatedBy:   createdBy,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	if err := s.repo.CreateServiceAccount(account); err != nil {
+		return nil, errors.New("could not create service account - " + err.Error())
+	}
+	return account, nil
+}
+
+// ListServiceAccounts returns all service accounts
+func (s *APIKeyService) ListServiceAccounts() ([]models.ServiceAccount, error) {
+	return s.repo.GetServiceAccounts()
+}
+
+// DeleteServiceAccount revokes all keys of a service account and removes it
+func (s *APIKeyService) DeleteServiceAccount(id, actorID gocql.UUID) error {
+	if _, err := s.getServiceAccount(id); err != nil {
+		return err
+	}
+
+	keys, err := s.repo.GetKeysForServiceAccount(id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, key := range keys {
+		if !key.RevokedAt.IsZero() {
+			continue
+		}
+		if err := s.revoke(&key, actorID, now); err != nil {
+			return err
+		}
+	}
+
+	return s.repo.DeleteServiceAccount(id)
+}
+
+// CreateKey issues a new key, the plain key is only returned here and never stored.
+// the creator can only grant permissions they hold themselves
+func (s *APIKeyService) CreateKey(serviceAccountID gocql.UUID, name string, scopes []models.Permission, expiresAt time.Time, createdBy gocql.UUID) (*models.APIKey, string, error) {
+	account, err := s.getServiceAccount(serviceAccountID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.checkScopes(scopes, createdBy); err != nil {
+		return nil, "", err
+	}
+
+	if !expiresAt.IsZero() && !expiresAt.After(time.Now()) {
+		return nil, "", ErrAPIKeyExpiryInPast
+	}
+
+	if strings.TrimSpace(name) == "" {
+		name = account.Name
+	}
+
+	return s.issue(&models.APIKey{
+		ServiceAccountID: serviceAccountID,
+		Name:             name,
+		Scopes:           scopes,
+		ExpiresAt:        expiresAt,
+	}, createdBy)
+}
+
+// ListKeys returns all keys of a service account (including revoked ones)
+func (s *APIKeyService) ListKeys(serviceAccountID gocql.UUID) ([]models.APIKey, error) {
+	if _, err := s.getServiceAccount(serviceAccountID); err != nil {
+		return nil, err
+	}
+	return s.repo.GetKeysForServiceAccount(serviceAccountID)
+}
+
+// RotateKey issues a replacement with the same scopes, the old key stays valid for the grace period
+func (s *APIKeyService) RotateKey(keyID string, actorID gocql.UUID) (*models.APIKey, string, *models.APIKey, error) {
+	old, err := s.repo.GetKey(keyID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if old == nil {
+		return nil, "", nil, repository.ErrAPIKeyNotFound
+	}
+
+	now := time.Now().UTC()
+	if !old.Active(now) {
+		return nil, "", nil, ErrAPIKeyInactive
+	}
+
+	if err := s.checkScopes(old.Scopes, actorID); err != nil {
+		return nil, "", nil, err
+	}
+
+	key, plain, err := s.issue(&models.APIKey{
+		ServiceAccountID: old.ServiceAccountID,
+		Name:             old.Name,
+		Scopes:           old.Scopes,
+		ExpiresAt:        old.ExpiresAt,
+	}, actorID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if s.rotationGrace <= 0 {
+		if err := s.revoke(old, actorID, now); err != nil {
+			return nil, "", nil, err
+		}
+	} else if graceEnd := now.Add(s.rotationGrace); old.ExpiresAt.IsZero() || graceEnd.Before(old.ExpiresAt) {
+		if err := s.repo.SetExpiry(old.ID, graceEnd); err != nil {
+			return nil, "", nil, errors.New("could not shorten old key - " + err.Error())
+		}
+		old.ExpiresAt = graceEnd
+	}
+
+	return key, plain, old, nil
+}
+
+// RevokeKey disables a key immediately
+func (s *APIKeyService) RevokeKey(keyID string, actorID gocql.UUID) error {
+	key, err := s.repo.GetKey(keyID)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return repository.ErrAPIKeyNotFound
+	}
+	if !key.RevokedAt.IsZero() {
+		return nil
+	}
+
+	return s.revoke(key, actorID, time.Now().UTC())
+}
+
+// Authenticate resolves a plain api key to its active key record
+func (s *APIKeyService) Authenticate(plain string) (*models.APIKey, error) {
+	keyID, secret, ok := parseAPIKey(plain)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.repo.GetKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// hmac.Equal compares in constant time
+	if !hmac.Equal([]byte(utils.HashLookupToken(secret, s.pepper)), []byte(key.SecretHash)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now().UTC()
+	if !key.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// keys of deleted service accounts are revoked, this covers a failed revocation
+	account, err := s.repo.GetServiceAccount(key.ServiceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if now.Sub(key.LastUsedAt) >= apiKeyLastUsedPrecision {
+		if err := s.repo.UpdateLastUsed(key.ID, now); err != nil {
+			return nil, errors.New("could not update key usage - " + err.Error())
+		}
+		key.LastUsedAt = now
+	}
+
+	return key, nil
+}
+
+// generate the secret, store the key and record the event
+func (s *APIKeyService) issue(key *models.APIKey, createdBy gocql.UUID) (*models.APIKey, string, error) {
+	idBytes := make([]byte, apiKeyIDLength/2)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, "", err
+	}
+	secret, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key.ID = hex.EncodeToString(idBytes)
+	key.SecretHash = utils.HashLookupToken(secret, s.pepper)
+	key.CreatedBy = createdBy
+	key.CreatedAt = time.Now().UTC()
+
+	if err := s.repo.CreateKey(key); err != nil {
+		return nil, "", errors.New("could not store api key - " + err.Error())
+	}
+
+	if err := s.events.Record(createdBy, models.EventAPIKeyCreated, gocql.UUID{}, "",
+		"key "+key.ID+" for service account "+key.ServiceAccountID.String()); err != nil {
+		return nil, "", err
+	}
+
+	return key, APIKeyPrefix + key.ID + "_" + secret, nil
+}
+
+// mark a key as revoked and record the event
+func (s *APIKeyService) revoke(key *models.APIKey, actorID gocql.UUID, now time.Time) error {
+	if err := s.repo.RevokeKey(key.ID, now); err != nil {
+		return err
+	}
+	return s.events.Record(actorID, models.EventAPIKeyRevoked, gocql.UUID{}, "",
+		"key "+key.ID+" of service account "+key.ServiceAccountID.String())
+}
+
+// scopes must be non empty and held by the granting user
+func (s *APIKeyService) checkScopes(scopes []models.Permission, granterID gocql.UUID) error {
+	if len(scopes) == 0 {
+		return ErrNoScopes
+	}
+
+	held, err := s.authorizationService.GetPermissions(granterID)
+	if err != nil {
+		return err
+	}
+
+	for _, scope := range scopes {
+		found := false
+		for _, permission := range held {
+			if permission == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrScopeNotAllowed
+		}
+	}
+	return nil
+}
+
+func (s *APIKeyService) getServiceAccount(id gocql.UUID) (*models.ServiceAccount, error) {
+	account, err := s.repo.GetServiceAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, ErrServiceAccountNotFound
+	}
+	return account, nil
+}
+
+// split mpg_<id>_<secret> (the secret itself may contain underscores)
+func parseAPIKey(plain string) (string, string, bool) {
+	rest, found := strings.CutPrefix(plain, APIKeyPrefix)
+	if !found || len(rest) < apiKeyIDLength+2 || rest[apiKeyIDLength] != '_' {
+		return "", "", false
+	}
+	return rest[:apiKeyIDLength], rest[apiKeyIDLength+1:], true
+}
+
+// custom errors for service accounts and api keys
+var (
+	ErrInvalidAPIKey          = errors.New("invalid, expired or revoked api key")
+	ErrAPIKeyInactive         = errors.New("api key is expired or revoked")
+	ErrAPIKeyExpiryInPast     = errors.New("expiry must be in the future")
+	ErrServiceAccountNotFound = errors.New("service account not found")
+	ErrServiceAccountName     = errors.New("service account name is required")
+	ErrNoScopes               = errors.New("at least one scope is required")
+	ErrScopeNotAllowed        = errors.New("cannot grant a permission you do not have")
+)
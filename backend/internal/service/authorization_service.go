@@ -0,0 +1,120 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// AuthorizationService resolves roles to permissions and manages role assignments
+type AuthorizationService struct {
+	role_repo    *repository.RoleRepository
+	account_repo *repository.AccountRepository
+}
+
+// NewAuthorizationService creates a new authorization service instance
+func NewAuthorizationService(role_repo *repository.RoleRepository, account_repo *repository.AccountRepository) *AuthorizationService {
+	return &AuthorizationService{
+		role_repo:    role_repo,
+		account_repo: account_repo,
+	}
+}
+
+// HasPermission reports whether any role of the user grants the permission
+func (s *AuthorizationService) HasPermission(userID gocql.UUID, permission models.Permission) (bool, error) {
+	permissions, err := s.GetPermissions(userID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, granted := range permissions {
+		if granted == permission {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetRoles returns the roles assigned to a user
+func (s *AuthorizationService) GetRoles(userID gocql.UUID) ([]models.RoleAssignment, error) {
+	return s.role_repo.GetRolesForUser(userID)
+}
+
+// GetPermissions returns the sorted union of all permissions granted by the user's roles
+func (s *AuthorizationService) GetPermissions(userID gocql.UUID) ([]models.Permission, error) {
+	assignments, err := s.role_repo.GetRolesForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	unique := make(map[models.Permission]bool)
+	for _, assignment := range assignments {
+		for _, permission := range models.RolePermissions[assignment.Role] {
+			unique[permission] = true
+		}
+	}
+
+	permissions := make([]models.Permission, 0, len(unique))
+	for permission := range unique {
+		permissions = append(permissions, permission)
+	}
+	sort.Slice(permissions, func(i, j int) bool { return permissions[i] < permissions[j] })
+
+	return permissions, nil
+}
+
+// GetRoleRank returns the rank of the highest role of the user (0 without roles)
+func (s *AuthorizationService) GetRoleRank(userID gocql.UUID) (int, error) {
+	assignments, err := s.role_repo.GetRolesForUser(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	rank := 0
+	for _, assignment := range assignments {
+		rank = max(rank, models.RoleRanks[assignment.Role])
+	}
+	return rank, nil
+}
+
+// GrantRole assigns a role to an account
+func (s *AuthorizationService) GrantRole(userID gocql.UUID, role models.Role, grantedBy gocql.UUID) error {
+	if _, ok := models.RolePermissions[role]; !ok {
+		return ErrUnknownRole
+	}
+
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return ErrAccountNotFound
+	}
+
+	return s.role_repo.GrantRole(&models.RoleAssignment{
+		UserID:    userID,
+		Role:      role,
+		GrantedAt: time.Now().UTC(),
+		GrantedBy: grantedBy,
+	})
+}
+
+// RevokeRole removes a role from an account
+func (s *AuthorizationService) RevokeRole(userID gocql.UUID, role models.Role) error {
+	if _, ok := models.RolePermissions[role]; !ok {
+		return ErrUnknownRole
+	}
+
+	return s.role_repo.RevokeRole(userID, role)
+}
+
+// custom errors for role management
+var (
+	ErrUnknownRole     = errors.New("unknown role")
+	ErrAccountNotFound = errors.New("account not found")
+)
@@ -0,0 +1,386 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// maximum number of security events included in an export
+const exportMaxSecurityEvents = 10000
+
+// ExportSection collects one part of the personal data of a user. the result is encoded as json
+type ExportSection func(userID gocql.UUID) (interface{}, error)
+
+// DataExportService generates personal data exports ("download my data") in the background.
+// every kind of user data is a named section, features storing new user data add their own
+// section with AddSection so it shows up in exports
+type DataExportService struct {
+	repo     *repository.DataExportRepository
+	sections map[string]ExportSection
+	workers  chan struct{} // limits the number of exports generated at the same time
+	ttl      time.Duration // lifespan of a finished export
+	syncWait time.Duration // how long a request waits before it falls back to polling
+	timeout  time.Duration // unfinished exports older than this are reported as failed
+}
+
+// NewDataExportService creates a new data export service with the sections of the existing tables
+func NewDataExportService(repo *repository.DataExportRepository, account_repo *repository.AccountRepository, session_repo *repository.SessionRepository,
+	role_repo *repository.RoleRepository, two_factor_repo *repository.TwoFactorRepository, passkey_repo *repository.PasskeyRepository,
+	oidc_repo *repository.OIDCRepository, events *SecurityEventService, scan_repo *repository.UserQRScanRepository,
+	code_repo *repository.QRCodeRepository, action_repo *repository.QRActionRepository,
+	workers int, ttl, syncWait, timeout time.Duration) *DataExportService {
+
+	s := &DataExportService{
+		repo:     repo,
+		sections: make(map[string]ExportSection),
+		workers:  make(chan struct{}, max(workers, 1)),
+		ttl:      ttl,
+		syncWait: syncWait,
+		timeout:  timeout,
+	}
+
+	s.AddSection("account", func(userID gocql.UUID) (interface{}, error) {
+		account, err := account_repo.GetAccountByID(userID)
+		if err != nil || account == nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"id":            account.ID,
+			"email":         account.Email,
+			"pending_email": account.PendingEmail,
+			"created_at":    account.CreatedAt,
+			"verified":      account.Verified,
+			"disabled":      account.Disabled,
+			"guest":         account.Guest,
+			"has_password":  account.PasswordHash != "",
+		}, nil
+	})
+	s.AddSection("sessions", func(userID gocql.UUID) (interface{}, error) {
+		return session_repo.GetSessionsForUser(userID)
+	})
+	s.AddSection("roles", func(userID gocql.UUID) (interface{}, error) {
+		return role_repo.GetRolesForUser(userID)
+	})
+	s.AddSection("two_factor", func(userID gocql.UUID) (interface{}, error) {
+		secret, err := two_factor_repo.GetSecret(userID)
+		if err != nil {
+			return nil, err
+		}
+		codes, err := two_factor_repo.GetRecoveryCodes(userID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"totp":                     secret, // the secret itself is never exported
+			"remaining_recovery_codes": len(codes),
+		}, nil
+	})
+	s.AddSection("passkeys", func(userID gocql.UUID) (interface{}, error) {
+		passkeys, err := passkey_repo.GetCredentialsForUser(userID)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]map[string]interface{}, 0, len(passkeys))
+		for _, passkey := range passkeys {
+			result = append(result, map[string]interface{}{
+				"id":           base64.RawURLEncoding.EncodeToString(passkey.CredentialID), // same encoding as the passkey api
+				"name":         passkey.Name,
+				"transports":   passkey.Transports,
+				"backed_up":    passkey.BackupState,
+				"blocked":      passkey.CloneWarning,
+				"created_at":   passkey.CreatedAt,
+				"last_used_at": passkey.LastUsedAt,
+			})
+		}
+		return result, nil
+	})
+	s.AddSection("linked_identities", func(userID gocql.UUID) (interface{}, error) {
+		return oidc_repo.GetIdentitiesForUser(userID)
+	})
+	s.AddSection("security_events", func(userID gocql.UUID) (interface{}, error) {
+		return events.GetEventsForUser(userID, exportMaxSecurityEvents)
+	})
+	s.AddSection("qr_scans", func(userID gocql.UUID) (interface{}, error) {
+		return collectQRScans(userID, scan_repo, code_repo, action_repo)
+	})
+
+	return s
+}
+
+// AddSection registers a section under a unique name (file name in zip exports)
+func (s *DataExportService) AddSection(name string, collect ExportSection) {
+	s.sections[name] = collect
+}
+
+// StartExport creates an export and generates it in the background. it waits a short time so
+// small exports are returned ready, large ones come back pending and have to be polled.
+// a user can only have one unfinished export at a time, a second request returns the first
+func (s *DataExportService) StartExport(userID gocql.UUID, format string) (*models.DataExport, error) {
+	if format != models.ExportFormatJSON && format != models.ExportFormatZIP {
+		return nil, ErrExportFormat
+	}
+
+	exports, err := s.repo.GetExportsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, export := range exports {
+		if s.unfinished(&export, now) {
+			return &export, nil
+		}
+	}
+
+	export := &models.DataExport{
+		UserID:    userID,
+		ID:        gocql.TimeUUID(),
+		Format:    format,
+		Status:    models.ExportPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.ttl),
+	}
+	if err := s.repo.CreateExport(export); err != nil {
+		return nil, errors.New("could not store export - " + err.Error())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.generate(export)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(s.syncWait):
+		return s.GetExport(userID, export.ID)
+	}
+
+	if export.Status == models.ExportFailed {
+		return nil, errors.New("export failed - " + export.Error)
+	}
+	return export, nil
+}
+
+// GetExport returns the state of an export of the user
+func (s *DataExportService) GetExport(userID, exportID gocql.UUID) (*models.DataExport, error) {
+	export, err := s.repo.GetExport(userID, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil {
+		return nil, ErrExportNotFound
+	}
+
+	s.checkTimeout(export, time.Now())
+	return export, nil
+}
+
+// ListExports returns the exports of the user, newest first
+func (s *DataExportService) ListExports(userID gocql.UUID) ([]models.DataExport, error) {
+	exports, err := s.repo.GetExportsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i := range exports {
+		s.checkTimeout(&exports[i], now)
+	}
+	return exports, nil
+}
+
+// GetExportData returns the generated file of a ready export
+func (s *DataExportService) GetExportData(userID, exportID gocql.UUID) (*models.DataExport, []byte, error) {
+	export, err := s.GetExport(userID, exportID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if export.Status != models.ExportReady {
+		return nil, nil, ErrExportNotReady
+	}
+
+	data, err := s.repo.GetData(userID, exportID)
+	if err != nil {
+		return nil, nil, errors.New("could not read export - " + err.Error())
+	}
+	return export, data, nil
+}
+
+// pending or running and not timed out
+func (s *DataExportService) unfinished(export *models.DataExport, now time.Time) bool {
+	return (export.Status == models.ExportPending || export.Status == models.ExportRunning) &&
+		now.Sub(export.CreatedAt) < s.timeout
+}
+
+// report exports that outlived the timeout as failed, the instance generating them was stopped
+func (s *DataExportService) checkTimeout(export *models.DataExport, now time.Time) {
+	if (export.Status == models.ExportPending || export.Status == models.ExportRunning) && !s.unfinished(export, now) {
+		export.Status = models.ExportFailed
+		export.Error = "export timed out"
+	}
+}
+
+// wait for a worker, collect all sections and store the file. the outcome is written to the
+// export (the caller may still be waiting on it) and to the database
+func (s *DataExportService) generate(export *models.DataExport) {
+	s.workers <- struct{}{}
+	defer func() { <-s.workers }()
+
+	export.Status = models.ExportRunning
+	if err := s.repo.UpdateStatus(export); err != nil {
+		s.fail(export, err)
+		return
+	}
+
+	data, err := s.build(export)
+	if err == nil {
+		export.Size = int64(len(data))
+		err = s.repo.SaveData(export, data)
+	}
+	if err != nil {
+		s.fail(export, err)
+		return
+	}
+
+	export.Status = models.ExportReady
+	export.CompletedAt = time.Now().UTC()
+	if err := s.repo.UpdateStatus(export); err != nil {
+		s.fail(export, err)
+	}
+}
+
+// mark an export as failed (best effort, unfinished exports also fail through the timeout)
+func (s *DataExportService) fail(export *models.DataExport, err error) {
+	export.Status = models.ExportFailed
+	export.Error = err.Error()
+	export.CompletedAt = time.Now().UTC()
+	s.repo.UpdateStatus(export)
+}
+
+// encode all sections as one json document or as a zip archive with one file per section
+func (s *DataExportService) build(export *models.DataExport) ([]byte, error) {
+	names := make([]string, 0, len(s.sections))
+	for name := range s.sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	info := map[string]interface{}{
+		"user_id":      export.UserID,
+		"export_id":    export.ID,
+		"generated_at": time.Now().UTC(),
+	}
+
+	collected := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		data, err := s.sections[name](export.UserID)
+		if err != nil {
+			return nil, errors.New("could not collect " + name + " - " + err.Error())
+		}
+		collected[name] = data
+	}
+
+	if export.Format == models.ExportFormatJSON {
+		info["sections"] = collected
+		return json.MarshalIndent(info, "", "  ")
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	writeFile := func(name string, data interface{}) error {
+		file, err := archive.Create(name + ".json")
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(data)
+	}
+
+	info["sections"] = names
+	if err := writeFile("export", info); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := writeFile(name, collected[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// scans of the user with the details of the scanned code and its action (deleted ones are null)
+func collectQRScans(userID gocql.UUID, scan_repo *repository.UserQRScanRepository, code_repo *repository.QRCodeRepository, action_repo *repository.QRActionRepository) (interface{}, error) {
+	scans, err := scan_repo.GetUserQRScansByUserId(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := make(map[gocql.UUID]*models.QRAction) // codes often share an action
+	result := make([]map[string]interface{}, 0, len(scans))
+
+	for _, scan := range scans {
+		entry := map[string]interface{}{
+			"qr_code_id": scan.QrCodeId,
+			"count":      scan.Count,
+			"qr_code":    nil,
+			"action":     nil,
+		}
+
+		code, err := code_repo.GetQRCodeByID(scan.QrCodeId)
+		if err != nil {
+			return nil, err
+		}
+		if code != nil {
+			entry["qr_code"] = code
+
+			action, cached := actions[code.ActionId]
+			if !cached {
+				action, err = action_repo.GetQRActionByID(code.ActionId)
+				if err != nil {
+					return nil, err
+				}
+				actions[code.ActionId] = action
+			}
+			if action != nil {
+				entry["action"] = map[string]interface{}{
+					"id":     action.ID,
+					"action": rawJSON(action.ActionJson),
+				}
+			}
+		}
+
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+// embed stored json as is, anything else as a string
+func rawJSON(value string) interface{} {
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	return value
+}
+
+// custom errors for data exports
+var (
+	ErrExportFormat   = errors.New("format must be json or zip")
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export is not ready")
+)
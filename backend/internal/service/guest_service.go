@@ -0,0 +1,203 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/pkg/utils"
+
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// GuestService handles anonymous accounts that let new players scan right away. a guest has no
+// email or password, it only signs in through the session of the device it was created on.
+// guests are upgraded by claiming (attach credentials) or by merging into an existing account
+type GuestService struct {
+	account_repo   *repository.AccountRepository
+	scan_repo      *repository.UserQRScanRepository
+	code_repo      *repository.QRCodeRepository
+	limit_repo     *repository.MagicLinkRepository // request log used to rate limit guest creation
+	accounts       *AccountService
+	deletion       *AccountDeletionService
+	events         *SecurityEventService
+	pepper         string
+	passwordParams utils.Argon2Params    // argon2 parameters for new password hashes
+	passwordPolicy *utils.PasswordPolicy // rules new passwords must satisfy
+	window         time.Duration         // rate limit window for guest creation
+	maxPerIP       int                   // guest accounts per ip inside the window
+}
+
+// NewGuestService creates a new guest service instance
+func NewGuestService(account_repo *repository.AccountRepository, scan_repo *repository.UserQRScanRepository, code_repo *repository.QRCodeRepository, limit_repo *repository.MagicLinkRepository, accounts *AccountService, deletion *AccountDeletionService,
+	events *SecurityEventService, pepper string, passwordParams utils.Argon2Params, passwordPolicy *utils.PasswordPolicy, window time.Duration, maxPerIP int) *GuestService {
+	return &GuestService{
+		account_repo:   account_repo,
+		scan_repo:      scan_repo,
+		code_repo:      code_repo,
+		limit_repo:     limit_repo,
+		accounts:       accounts,
+		deletion:       deletion,
+		events:         events,
+		pepper:         pepper,
+		passwordParams: passwordParams,
+		passwordPolicy: passwordPolicy,
+		window:         window,
+		maxPerIP:       maxPerIP,
+	}
+}
+
+// CreateGuest creates an anonymous account for a device (the caller issues the device session).
+// returns how long the caller has to wait if the ip exceeded the rate limit (0 = created)
+func (s *GuestService) CreateGuest(deviceID gocql.UUID, ip string) (*models.Account, time.Duration, error) {
+	requests, err := s.limit_repo.GetRequests(models.GuestLimitIP, ip)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(requests) >= s.maxPerIP {
+		// the next guest is allowed once enough of the oldest ones expired
+		freedAt := requests[len(requests)-s.maxPerIP].Time().Add(s.window)
+		if retryAfter := time.Until(freedAt); retryAfter > 0 {
+			return nil, retryAfter, nil
+		}
+	}
+	if err := s.limit_repo.RecordRequest(models.GuestLimitIP, ip, s.window); err != nil {
+		return nil, 0, err
+	}
+
+	randomUUID, _ := gocql.RandomUUID() // ignoring error since it should never fail
+
+	account := &models.Account{
+		ID:        randomUUID,
+		CreatedAt: time.Now().UTC(),
+		Verified:  false, // there is no email to verify until the guest is claimed
+		Guest:     true,
+	}
+
+	if err := s.account_repo.CreateGuestAccount(account); err != nil {
+		return nil, 0, errors.New("failed to create guest account - " + err.Error())
+	}
+
+	if err := s.events.Record(account.ID, models.EventGuestCreated, deviceID, ip, ""); err != nil {
+		return nil, 0, err
+	}
+	return account, 0, nil
+}
+
+// ClaimAccount turns a guest into a regular account with email and password, the scan progress
+// stays on the account. the caller sends the verification email
+func (s *GuestService) ClaimAccount(userID gocql.UUID, email, password string) (*models.Account, error) {
+	account, err := s.getGuest(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !utils.ValidateEmail(email) {
+		return nil, errors.New("invalid email format")
+	}
+	if err := s.passwordPolicy.Validate(password, email); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.account_repo.GetAccountByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, repository.ErrEmailExists
+	}
+
+	hash, err := utils.HashPassword(password, s.pepper, s.passwordParams)
+	if err != nil {
+		return nil, err
+	}
+
+	// conditional on the guest flag, a concurrent claim or merge wins
+	claimed, err := s.account_repo.ClaimGuestAccount(userID, email, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrNotGuestAccount
+	}
+
+	if err := s.events.Record(userID, models.EventGuestClaimed, gocql.UUID{}, "", ""); err != nil {
+		return nil, err
+	}
+
+	account.Email = email
+	account.PasswordHash = hash
+	account.Guest = false
+	return account, nil
+}
+
+// MergeInto moves the scan progress of a guest to an existing account and deletes the guest.
+// the caller has to authenticate the target account first. returns the number of moved qr codes
+func (s *GuestService) MergeInto(guestID, targetID gocql.UUID, ip string) (int, error) {
+	guest, err := s.getGuest(guestID)
+	if err != nil {
+		return 0, err
+	}
+
+	scans, err := s.scan_repo.GetUserQRScansByUserId(guestID)
+	if err != nil {
+		return 0, errors.New("failed to get guest scans - " + err.Error())
+	}
+
+	// the target row remembers the merged guest, a retry after a failure never counts a scan twice
+	for _, scan := range scans {
+		if err := s.addScans(targetID, guestID, scan); err != nil {
+			return 0, errors.New("failed to move guest scans - " + err.Error())
+		}
+		if err := s.scan_repo.DeleteUserQRScan(guestID, scan.QrCodeId); err != nil {
+			return 0, errors.New("failed to move guest scans - " + err.Error())
+		}
+	}
+
+	if err := s.deletion.DeleteImmediately(guest); err != nil {
+		return 0, errors.New("failed to delete guest account - " + err.Error())
+	}
+
+	details := "guest " + guestID.String() + " merged with " + strconv.Itoa(len(scans)) + " scanned qr codes"
+	if err := s.events.Record(targetID, models.EventGuestMerged, gocql.UUID{}, ip, details); err != nil {
+		return 0, err
+	}
+	return len(scans), nil
+}
+
+// add the scans of a guest to the count of a user, capped at the uses a single account may have
+func (s *GuestService) addScans(userID, guestID gocql.UUID, scan models.UserQRScan) error {
+	qr_code, err := s.code_repo.GetQRCodeByID(scan.QrCodeId)
+	if err != nil {
+		return err
+	}
+	if qr_code == nil {
+		return nil // deleted code, nothing left to count
+	}
+
+	return s.scan_repo.MergeGuestScans(userID, scan.QrCodeId, guestID, scan.Count, qr_code.MaxUsages)
+}
+
+// load an account and make sure it is still a guest
+func (s *GuestService) getGuest(userID gocql.UUID) (*models.Account, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, ErrAccountNotFound
+	}
+	if !account.Guest {
+		return nil, ErrNotGuestAccount
+	}
+	return account, nil
+}
+
+// custom errors for guest accounts
+var (
+	ErrGuestAccountsDisabled = errors.New("guest accounts are disabled")
+	ErrNotGuestAccount       = errors.New("only guest accounts can be claimed")
+	ErrGuestAccount          = errors.New("guest accounts have to be claimed first")
+)
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// how long the lockout level is remembered after the last lockout
+const lockoutMemory = 24 * time.Hour
+
+// LoginProtectionService tracks failed logins and temporarily locks accounts and ips
+type LoginProtectionService struct {
+	repo               *repository.LoginAttemptRepository
+	account_repo       *repository.AccountRepository
+	events             *SecurityEventService
+	maxAccountFailures int           // failures per account inside the window before a lockout
+	maxIPFailures      int           // failures per ip inside the window before a lockout
+	window             time.Duration // how long a failure is counted
+	lockoutBase        time.Duration // first lockout duration, doubled on every following lockout
+	lockoutMax         time.Duration // upper bound for the lockout duration
+}
+
+// NewLoginProtectionService creates a new login protection service instance
+func NewLoginProtectionService(repo *repository.LoginAttemptRepository, account_repo *repository.AccountRepository, events *SecurityEventService, maxAccountFailures, maxIPFailures int, window, lockoutBase, lockoutMax time.Duration) *LoginProtectionService {
+	return &LoginProtectionService{
+		repo:               repo,
+		account_repo:       account_repo,
+		events:             events,
+		maxAccountFailures: maxAccountFailures,
+		maxIPFailures:      maxIPFailures,
+		window:             window,
+		lockoutBase:        lockoutBase,
+		lockoutMax:         lockoutMax,
+	}
+}
+
+// Check returns how long the caller has to wait before the next attempt (0 = not locked)
+// runs before the password hash so locked out requests do not cost any argon2 work
+func (s *LoginProtectionService) Check(scope, key, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+
+	for _, target := range [][2]string{{scope, normalizeLockoutKey(key)}, {models.LockoutScopeIP, ip}} {
+		lockout, err := s.repo.GetLockout(target[0], target[1])
+		if err != nil {
+			return 0, err
+		}
+		if lockout == nil {
+			continue
+		}
+		if remaining := time.Until(lockout.LockedUntil); remaining > retryAfter {
+			retryAfter = remaining
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// RecordFailure counts a failed attempt for the key and the ip and locks them once a threshold is reached,
+// returns the lockout duration if the attempt triggered a lockout
+func (s *LoginProtectionService) RecordFailure(scope, key, ip string) (time.Duration, error) {
+	accountLock, err := s.recordAndLock(scope, normalizeLockoutKey(key), s.maxAccountFailures)
+	if err != nil {
+		return 0, err
+	}
+	ipLock, err := s.recordAndLock(models.LockoutScopeIP, ip, s.maxIPFailures)
+	if err != nil {
+		return 0, err
+	}
+
+	if accountLock > 0 {
+		if err := s.events.Record(s.eventUser(scope, key), models.EventLoginLockout, gocql.UUID{}, ip,
+			fmt.Sprintf("%s locked for %s after %d failed attempts", scope, accountLock, s.maxAccountFailures)); err != nil {
+			return accountLock, err
+		}
+	}
+	if ipLock > 0 {
+		if err := s.events.Record(models.SystemUserID, models.EventLoginLockout, gocql.UUID{}, ip,
+			fmt.Sprintf("ip %s locked for %s after %d failed attempts", ip, ipLock, s.maxIPFailures)); err != nil {
+			return ipLock, err
+		}
+	}
+
+	if ipLock > accountLock {
+		return ipLock, nil
+	}
+	return accountLock, nil
+}
+
+// RecordSuccess resets the failure count of the key after a successful login
+func (s *LoginProtectionService) RecordSuccess(scope, key string) error {
+	return s.repo.ClearFailures(scope, normalizeLockoutKey(key))
+}
+
+// Unlock removes a lockout and its failure history (admin action)
+func (s *LoginProtectionService) Unlock(scope, key string, adminID gocql.UUID, ip string) error {
+	lockKey := normalizeLockoutKey(key)
+
+	if err := s.repo.DeleteLockout(scope, lockKey); err != nil {
+		return err
+	}
+	if err := s.repo.ClearFailures(scope, lockKey); err != nil {
+		return err
+	}
+
+	return s.events.Record(s.eventUser(scope, key), models.EventLoginUnlock, gocql.UUID{}, ip,
+		fmt.Sprintf("%s %s unlocked by admin %s", scope, lockKey, adminID))
+}
+
+// store the failure and lock the key if the threshold is reached
+func (s *LoginProtectionService) recordAndLock(scope, key string, maxFailures int) (time.Duration, error) {
+	if err := s.repo.RecordFailure(scope, key, s.window); err != nil {
+		return 0, err
+	}
+
+	failures, err := s.repo.CountFailures(scope, key)
+	if err != nil {
+		return 0, err
+	}
+	if failures < maxFailures {
+		return 0, nil
+	}
+
+	// every consecutive lockout doubles the duration
+	level := 0
+	previous, err := s.repo.GetLockout(scope, key)
+	if err != nil {
+		return 0, err
+	}
+	if previous != nil {
+		level = previous.Level
+	}
+
+	duration := s.lockoutBase << level
+	if duration > s.lockoutMax || duration <= 0 {
+		duration = s.lockoutMax
+	}
+
+	lockout := &models.LoginLockout{
+		Scope:       scope,
+		Key:         key,
+		LockedUntil: time.Now().UTC().Add(duration),
+		Level:       level + 1,
+	}
+	if err := s.repo.SaveLockout(lockout, duration+lockoutMemory); err != nil {
+		return 0, err
+	}
+
+	// start counting from zero once the lock ends
+	return duration, s.repo.ClearFailures(scope, key)
+}
+
+// resolve the account a lockout event belongs to, the key is the email as entered (emails are stored case sensitive)
+func (s *LoginProtectionService) eventUser(scope, key string) gocql.UUID {
+	switch scope {
+	case models.LockoutScopeAccount:
+		if account, err := s.account_repo.GetAccountByEmail(key); err == nil && account != nil {
+			return account.ID
+		}
+	case models.LockoutScopeTwoFactor:
+		if userID, err := gocql.ParseUUID(key); err == nil {
+			return userID
+		}
+	}
+	return models.SystemUserID
+}
+
+// emails are compared case insensitive for lockouts (accounts are still looked up with the email as entered)
+func normalizeLockoutKey(key string) string {
+	return strings.ToLower(strings.TrimSpace(key))
+}
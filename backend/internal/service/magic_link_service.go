@@ -0,0 +1,184 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/pkg/mail"
+	"backend/pkg/utils"
+
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// MagicLinkService handles passwordless sign-in through emailed one-time links
+type MagicLinkService struct {
+	accountService *AccountService
+	repo           *repository.MagicLinkRepository
+	account_repo   *repository.AccountRepository
+	mailer         mail.Mailer
+	pepper         string
+	appURL         string        // client app url, the emailed link opens its sign-in page
+	tokenTTL       time.Duration // lifespan of link tokens
+	window         time.Duration // rate limit window for link requests
+	maxPerEmail    int           // link requests per email inside the window
+	maxPerIP       int           // link requests per ip inside the window
+	logger         *log.Logger
+}
+
+// NewMagicLinkService creates a new magic link service instance
+func NewMagicLinkService(accountService *AccountService, repo *repository.MagicLinkRepository, account_repo *repository.AccountRepository, mailer mail.Mailer, pepper, appURL string, tokenTTL, window time.Duration, maxPerEmail, maxPerIP int, logger *log.Logger) *MagicLinkService {
+	return &MagicLinkService{
+		accountService: accountService,
+		repo:           repo,
+		account_repo:   account_repo,
+		mailer:         mailer,
+		pepper:         pepper,
+		appURL:         appURL,
+		tokenTTL:       tokenTTL,
+		window:         window,
+		maxPerEmail:    maxPerEmail,
+		maxPerIP:       maxPerIP,
+		logger:         logger,
+	}
+}
+
+// RequestLink mails a sign-in link bound to the requesting device if an account exists for the email.
+// unknown emails are silently ignored so the endpoint cannot be used to probe for accounts.
+// returns how long the caller has to wait if the email or ip exceeded the rate limit (0 = sent)
+func (s *MagicLinkService) RequestLink(email string, deviceID gocql.UUID, deviceName, ip string) (time.Duration, error) {
+	key := normalizeLockoutKey(email)
+
+	// every request counts, whether an account exists or not
+	retryAfter, err := s.checkLimits(key, ip)
+	if err != nil || retryAfter > 0 {
+		return retryAfter, err
+	}
+	if err := s.repo.RecordRequest(models.MagicLinkLimitEmail, key, s.window); err != nil {
+		return 0, err
+	}
+	if err := s.repo.RecordRequest(models.MagicLinkLimitIP, ip, s.window); err != nil {
+		return 0, err
+	}
+
+	account, err := s.account_repo.GetAccountByEmail(email)
+	if err != nil {
+		return 0, err
+	}
+	if account == nil || account.Disabled || account.PendingDeletion() {
+		return 0, nil
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return 0, err
+	}
+
+	// only the hash is stored, a database leak does not expose usable tokens
+	linkToken := &models.MagicLinkToken{
+		TokenHash:  utils.HashLookupToken(token, s.pepper),
+		UserID:     account.ID,
+		Email:      account.Email,
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := s.repo.CreateToken(linkToken, s.tokenTTL); err != nil {
+		return 0, errors.New("failed to store sign-in token - " + err.Error())
+	}
+
+	// the app page posts the token with its device id to /auth/magic_link/redeem
+	link := s.appURL + "/magic-link?token=" + url.QueryEscape(token)
+	body := "Hi,\n\n" +
+		"Open the following link on the device you requested it from to sign in:\n\n" +
+		link + "\n\n" +
+		"The link expires in " + strconv.Itoa(int(s.tokenTTL.Minutes())) + " minutes and can only be used once. " +
+		"If you did not request it you can ignore this email.\n"
+
+	// a failed delivery is only logged, an error response would reveal that the account exists
+	if err := s.mailer.Send(account.Email, "Your sign-in link", body); err != nil {
+		s.logger.Printf("failed to send sign-in link email: %v", err)
+	}
+	return 0, nil
+}
+
+// RedeemLink consumes a link token presented by the device that requested it and returns it
+// (the caller creates the session). redeeming the link proves the email address
+func (s *MagicLinkService) RedeemLink(token string, deviceID gocql.UUID) (*models.MagicLinkToken, error) {
+	tokenHash := utils.HashLookupToken(token, s.pepper)
+
+	// look the token up without redeeming it, a link opened on another device stays usable
+	linkToken, err := s.repo.GetToken(tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if linkToken == nil {
+		return nil, ErrInvalidMagicLink
+	}
+	if linkToken.DeviceID != deviceID {
+		return nil, ErrMagicLinkDevice
+	}
+
+	// a link sent before an email change no longer signs in
+	account, err := s.account_repo.GetAccountByID(linkToken.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil || account.Email != linkToken.Email {
+		return nil, ErrInvalidMagicLink
+	}
+
+	consumed, err := s.repo.ConsumeToken(tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrInvalidMagicLink
+	}
+
+	// redeeming the link proves the address, an unverified account is taken over by its owner
+	if err := s.accountService.ConfirmEmailOwnership(account, ""); err != nil {
+		return nil, err
+	}
+
+	return linkToken, nil
+}
+
+// wait time until the email and the ip are below their limits again
+func (s *MagicLinkService) checkLimits(key, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+
+	for _, limit := range []struct {
+		scope, key string
+		max        int
+	}{
+		{models.MagicLinkLimitEmail, key, s.maxPerEmail},
+		{models.MagicLinkLimitIP, ip, s.maxPerIP},
+	} {
+		requests, err := s.repo.GetRequests(limit.scope, limit.key)
+		if err != nil {
+			return 0, err
+		}
+		if len(requests) < limit.max {
+			continue
+		}
+
+		// the next request is allowed once enough of the oldest ones expired
+		freedAt := requests[len(requests)-limit.max].Time().Add(s.window)
+		if remaining := time.Until(freedAt); remaining > retryAfter {
+			retryAfter = remaining
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// custom errors for sign-in links
+var (
+	ErrInvalidMagicLink = errors.New("invalid or expired sign-in link")
+	ErrMagicLinkDevice  = errors.New("sign-in link was requested on another device")
+)
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/pkg/config"
+	"backend/pkg/utils"
+
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gocql/gocql"
+	"golang.org/x/oauth2"
+)
+
+// timeout for discovery, token and jwks requests to a provider
+const oidcRequestTimeout = 10 * time.Second
+
+// OIDCLogin is the result of a completed provider login
+type OIDCLogin struct {
+	UserID     gocql.UUID
+	DeviceID   gocql.UUID
+	DeviceName string
+	Created    bool // a new account was created for the provider account
+}
+
+// OIDCService implements the openid connect relying party flow (authorization code + pkce)
+type OIDCService struct {
+	account_repo *repository.AccountRepository
+	repo         *repository.OIDCRepository
+	events       *SecurityEventService
+	providers    map[string]*oidcProvider
+	redirectURL  string
+	stateTTL     time.Duration
+	client       *http.Client
+}
+
+// provider config with lazily loaded discovery document
+type oidcProvider struct {
+	cfg config.OIDCProvider
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCService creates a new oidc service instance, providers are discovered on first use
+func NewOIDCService(account_repo *repository.AccountRepository, repo *repository.OIDCRepository, events *SecurityEventService, providers []config.OIDCProvider, redirectURL string, stateTTL time.Duration) *OIDCService {
+	s := &OIDCService{
+		account_repo: account_repo,
+		repo:         repo,
+		events:       events,
+		providers:    make(map[string]*oidcProvider),
+		redirectURL:  redirectURL,
+		stateTTL:     stateTTL,
+		client:       &http.Client{Timeout: oidcRequestTimeout},
+	}
+
+	for _, provider := range providers {
+		s.providers[provider.Name] = &oidcProvider{cfg: provider}
+	}
+	return s
+}
+
+// Providers returns the names of all configured providers
+func (s *OIDCService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StartLogin stores a new login state and returns the provider url the user has to visit
+func (s *OIDCService) StartLogin(providerName string, deviceID gocql.UUID, deviceName string) (string, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return "", ErrUnknownOIDCProvider
+	}
+
+	oauth, _, err := s.discover(provider)
+	if err != nil {
+		return "", err
+	}
+
+	stateValue, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	state := &models.OIDCState{
+		State:        stateValue,
+		Provider:     providerName,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Nonce:        nonce,
+		DeviceID:     deviceID,
+		DeviceName:   deviceName,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	if err := s.repo.CreateState(state, s.stateTTL); err != nil {
+		return "", errors.New("could not store login state - " + err.Error())
+	}
+
+	return oauth.AuthCodeURL(stateValue, oidc.Nonce(nonce), oauth2.S256ChallengeOption(state.CodeVerifier)), nil
+}
+
+// FinishLogin redeems the authorization code and resolves (or creates) the linked account
+func (s *OIDCService) FinishLogin(stateValue, code string) (*OIDCLogin, error) {
+	state, err := s.repo.ConsumeState(stateValue)
+	if err != nil {
+		return nil, errors.New("could not load login state - " + err.Error())
+	}
+	if state == nil {
+		return nil, ErrInvalidOIDCState
+	}
+
+	provider, ok := s.providers[state.Provider]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	claims, err := s.exchange(provider, state, code)
+	if err != nil {
+		return nil, err
+	}
+
+	userID, created, err := s.resolveAccount(state.Provider, claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCLogin{
+		UserID:     userID,
+		DeviceID:   state.DeviceID,
+		DeviceName: state.DeviceName,
+		Created:    created,
+	}, nil
+}
+
+// verified id token claims used for account linking
+type oidcClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// exchange the code (with the pkce verifier) and verify the returned id token
+func (s *OIDCService) exchange(provider *oidcProvider, state *models.OIDCState, code string) (*oidcClaims, error) {
+	oauth, verifier, err := s.discover(provider)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(oidc.ClientContext(context.Background(), s.client), oidcRequestTimeout)
+	defer cancel()
+
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		return nil, errors.New("code exchange failed - " + err.Error())
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("provider returned no id token")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, errors.New("invalid id token - " + err.Error())
+	}
+	if idToken.Nonce != state.Nonce {
+		return nil, errors.New("invalid id token - nonce mismatch")
+	}
+
+	var raw struct {
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"` // some providers send a string
+	}
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, errors.New("invalid id token claims - " + err.Error())
+	}
+
+	verified := false
+	switch value := raw.EmailVerified.(type) {
+	case bool:
+		verified = value
+	case string:
+		verified = value == "true"
+	}
+
+	return &oidcClaims{
+		Subject:       idToken.Subject,
+		Email:         strings.TrimSpace(raw.Email),
+		EmailVerified: verified,
+	}, nil
+}
+
+// find the linked account, or link / create one by verified email
+func (s *OIDCService) resolveAccount(providerName string, claims *oidcClaims) (gocql.UUID, bool, error) {
+	identity, err := s.repo.GetIdentity(providerName, claims.Subject)
+	if err != nil {
+		return gocql.UUID{}, false, errors.New("could not load identity - " + err.Error())
+	}
+
+	if identity != nil {
+		account, err := s.account_repo.GetAccountByID(identity.UserID)
+		if err != nil {
+			return gocql.UUID{}, false, err
+		}
+		if account != nil {
+			return account.ID, false, nil
+		}
+
+		// the linked account was deleted, link again below
+		if err := s.repo.DeleteIdentity(providerName, claims.Subject); err != nil {
+			return gocql.UUID{}, false, err
+		}
+	}
+
+	// linking by an unverified address would let anyone take over the account with that email
+	if claims.Email == "" || !claims.EmailVerified {
+		return gocql.UUID{}, false, ErrOIDCEmailNotVerified
+	}
+
+	account, err := s.account_repo.GetAccountByEmail(claims.Email)
+	if err != nil {
+		return gocql.UUID{}, false, err
+	}
+
+	created := false
+	if account == nil {
+		// passwordless account, a password can be set with the reset flow
+		account = models.NewAccount(claims.Email, "")
+		account.Verified = true
+
+		if err := s.account_repo.CreateAccount(account); err != nil {
+			return gocql.UUID{}, false, errors.New("could not create account - " + err.Error())
+		}
+		created = true
+	} else if !account.Verified {
+		// whoever registered the unverified account may not own the address
+		return gocql.UUID{}, false, ErrOIDCAccountUnverified
+	}
+
+	linked, err := s.repo.LinkIdentity(&models.OIDCIdentity{
+		Provider: providerName,
+		Subject:  claims.Subject,
+		UserID:   account.ID,
+		Email:    claims.Email,
+		LinkedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return gocql.UUID{}, false, errors.New("could not link identity - " + err.Error())
+	}
+
+	if !linked {
+		// a concurrent callback linked the provider account first
+		identity, err := s.repo.GetIdentity(providerName, claims.Subject)
+		if err != nil || identity == nil {
+			return gocql.UUID{}, false, errors.New("could not load identity")
+		}
+		return identity.UserID, false, nil
+	}
+
+	if err := s.events.Record(account.ID, models.EventOIDCLinked, gocql.UUID{}, "",
+		providerName+" account "+claims.Subject+" linked"); err != nil {
+		return gocql.UUID{}, false, err
+	}
+
+	return account.ID, created, nil
+}
+
+// load the discovery document of a provider once (retried on the next call if it failed)
+func (s *OIDCService) discover(provider *oidcProvider) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	if provider.oauth != nil {
+		return provider.oauth, provider.verifier, nil
+	}
+
+	ctx, cancel := context.WithTimeout(oidc.ClientContext(context.Background(), s.client), oidcRequestTimeout)
+	defer cancel()
+
+	discovered, err := oidc.NewProvider(ctx, provider.cfg.Issuer)
+	if err != nil {
+		return nil, nil, errors.New("provider discovery failed - " + err.Error())
+	}
+
+	provider.oauth = &oauth2.Config{
+		ClientID:     provider.cfg.ClientID,
+		ClientSecret: provider.cfg.ClientSecret,
+		Endpoint:     discovered.Endpoint(),
+		RedirectURL:  s.redirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID}, provider.cfg.Scopes...),
+	}
+	provider.verifier = discovered.Verifier(&oidc.Config{ClientID: provider.cfg.ClientID})
+
+	return provider.oauth, provider.verifier, nil
+}
+
+// custom errors for oidc login
+var (
+	ErrUnknownOIDCProvider   = errors.New("unknown identity provider")
+	ErrInvalidOIDCState      = errors.New("invalid or expired login state")
+	ErrOIDCEmailNotVerified  = errors.New("identity provider did not confirm the email address")
+	ErrOIDCAccountUnverified = errors.New("an unverified account with this email exists, verify it first")
+)
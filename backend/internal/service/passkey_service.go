@@ -0,0 +1,425 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/pkg/utils"
+
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gocql/gocql"
+)
+
+// PasskeyLogin is the result of a completed passkey login
+type PasskeyLogin struct {
+	UserID     gocql.UUID
+	DeviceID   gocql.UUID
+	DeviceName string
+}
+
+// PasskeyService implements webauthn registration and login ceremonies
+type PasskeyService struct {
+	accountService *AccountService
+	account_repo   *repository.AccountRepository
+	repo           *repository.PasskeyRepository
+	webauthn       *webauthn.WebAuthn
+	ceremonyTTL    time.Duration
+}
+
+// NewPasskeyService creates a new passkey service instance
+func NewPasskeyService(accountService *AccountService, account_repo *repository.AccountRepository, repo *repository.PasskeyRepository, relyingParty *webauthn.WebAuthn, ceremonyTTL time.Duration) *PasskeyService {
+	return &PasskeyService{
+		accountService: accountService,
+		account_repo:   account_repo,
+		repo:           repo,
+		webauthn:       relyingParty,
+		ceremonyTTL:    ceremonyTTL,
+	}
+}
+
+// BeginSignup starts the registration of a passwordless account with a passkey as its only credential
+func (s *PasskeyService) BeginSignup(email string, deviceID gocql.UUID, deviceName string) (*protocol.CredentialCreation, string, error) {
+	if !utils.ValidateEmail(email) {
+		return nil, "", errors.New("invalid email format")
+	}
+
+	existing, err := s.account_repo.GetAccountByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	if existing != nil {
+		return nil, "", repository.ErrEmailExists
+	}
+
+	// the account is created with this id once the authenticator answered
+	userID, _ := gocql.RandomUUID() // ignoring error since it should never fail
+	user := &passkeyUser{account: &models.Account{ID: userID, Email: email}}
+
+	return s.beginRegistration(user, &models.WebAuthnCeremony{
+		Kind:       models.CeremonySignup,
+		UserID:     userID,
+		Email:      email,
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+	})
+}
+
+// FinishSignup creates the account and stores its first passkey
+func (s *PasskeyService) FinishSignup(ceremonyID string, r *http.Request) (*models.Account, *PasskeyLogin, error) {
+	ceremony, session, err := s.consumeCeremony(ceremonyID, models.CeremonySignup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user := &passkeyUser{account: &models.Account{ID: ceremony.UserID, Email: ceremony.Email}}
+	credential, err := s.webauthn.FinishRegistration(user, *session, r)
+	if err != nil {
+		return nil, nil, ceremonyError(err)
+	}
+
+	account, err := s.accountService.RegisterPasswordlessAccount(ceremony.UserID, ceremony.Email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.repo.CreateCredential(newPasskeyCredential(account.ID, credential, "passkey")); err != nil {
+		return nil, nil, errors.New("could not store passkey - " + err.Error())
+	}
+
+	return account, &PasskeyLogin{
+		UserID:     account.ID,
+		DeviceID:   ceremony.DeviceID,
+		DeviceName: ceremony.DeviceName,
+	}, nil
+}
+
+// BeginRegistration starts adding a passkey to a signed in account
+func (s *PasskeyService) BeginRegistration(userID gocql.UUID) (*protocol.CredentialCreation, string, error) {
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return s.beginRegistration(user, &models.WebAuthnCeremony{
+		Kind:   models.CeremonyRegister,
+		UserID: userID,
+	})
+}
+
+// FinishRegistration stores the new passkey of a signed in account
+func (s *PasskeyService) FinishRegistration(userID gocql.UUID, ceremonyID, name string, r *http.Request) (*models.PasskeyCredential, error) {
+	ceremony, session, err := s.consumeCeremony(ceremonyID, models.CeremonyRegister)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.UserID != userID {
+		return nil, ErrInvalidCeremony
+	}
+
+	user, err := s.loadUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := s.webauthn.FinishRegistration(user, *session, r)
+	if err != nil {
+		return nil, ceremonyError(err)
+	}
+
+	if name == "" {
+		name = "passkey"
+	}
+
+	passkey := newPasskeyCredential(userID, credential, name)
+	if err := s.repo.CreateCredential(passkey); err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+// BeginLogin starts a usernameless login, the authenticator picks the passkey
+func (s *PasskeyService) BeginLogin(deviceID gocql.UUID, deviceName string) (*protocol.CredentialAssertion, string, error) {
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", errors.New("could not start login - " + err.Error())
+	}
+
+	ceremonyID, err := s.storeCeremony(session, &models.WebAuthnCeremony{
+		Kind:       models.CeremonyLogin,
+		DeviceID:   deviceID,
+		DeviceName: deviceName,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, ceremonyID, nil
+}
+
+// FinishLogin verifies the assertion and advances the signature counter of the passkey
+func (s *PasskeyService) FinishLogin(ceremonyID string, r *http.Request) (*PasskeyLogin, error) {
+	ceremony, session, err := s.consumeCeremony(ceremonyID, models.CeremonyLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	// the user handle sent by the authenticator is the account id
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := gocql.UUIDFromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		return s.loadUser(userID)
+	}
+
+	user, credential, err := s.webauthn.FinishPasskeyLogin(handler, *session, r)
+	if err != nil {
+		return nil, ceremonyError(err)
+	}
+	account := user.(*passkeyUser).account
+
+	stored := user.(*passkeyUser).find(credential.ID)
+	if stored == nil || stored.CloneWarning {
+		return nil, ErrPasskeyBlocked
+	}
+
+	// a counter that did not advance means the private key exists twice
+	if credential.Authenticator.CloneWarning {
+		if err := s.repo.FlagClone(account.ID, credential.ID); err != nil {
+			return nil, err
+		}
+		return nil, ErrPasskeyBlocked
+	}
+
+	updated, err := s.repo.UpdateUsage(account.ID, credential.ID, stored.SignCount, int64(credential.Authenticator.SignCount), credential.Flags.BackupState, time.Now().UTC())
+	if err != nil {
+		return nil, errors.New("could not update passkey - " + err.Error())
+	}
+	if !updated {
+		// a concurrent login with the same counter value
+		return nil, ErrPasskeyBlocked
+	}
+
+	return &PasskeyLogin{
+		UserID:     account.ID,
+		DeviceID:   ceremony.DeviceID,
+		DeviceName: ceremony.DeviceName,
+	}, nil
+}
+
+// ListPasskeys returns the passkeys of a user
+func (s *PasskeyService) ListPasskeys(userID gocql.UUID) ([]models.PasskeyCredential, error) {
+	return s.repo.GetCredentialsForUser(userID)
+}
+
+// RenamePasskey changes the label of a passkey
+func (s *PasskeyService) RenamePasskey(userID gocql.UUID, credentialID []byte, name string) error {
+	return s.repo.RenameCredential(userID, credentialID, name)
+}
+
+// DeletePasskey removes a passkey, the last passkey of a passwordless account is kept
+func (s *PasskeyService) DeletePasskey(userID gocql.UUID, credentialID []byte) error {
+	credentials, err := s.repo.GetCredentialsForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, credential := range credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			found = true
+		}
+	}
+	if !found {
+		return repository.ErrPasskeyNotFound
+	}
+
+	if len(credentials) == 1 {
+		hasPassword, err := s.accountService.HasPassword(userID)
+		if err != nil {
+			return err
+		}
+		if !hasPassword {
+			return ErrLastPasskey
+		}
+	}
+
+	return s.repo.DeleteCredential(userID, credentialID)
+}
+
+// create registration options (existing passkeys are excluded so an authenticator is not registered twice)
+func (s *PasskeyService) beginRegistration(user *passkeyUser, ceremony *models.WebAuthnCeremony) (*protocol.CredentialCreation, string, error) {
+	creation, session, err := s.webauthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		}),
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()),
+	)
+	if err != nil {
+		return nil, "", errors.New("could not start registration - " + err.Error())
+	}
+
+	ceremonyID, err := s.storeCeremony(session, ceremony)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, ceremonyID, nil
+}
+
+// persist the library session under a new ceremony id
+func (s *PasskeyService) storeCeremony(session *webauthn.SessionData, ceremony *models.WebAuthnCeremony) (string, error) {
+	sessionData, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	ceremony.ID, err = utils.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	ceremony.SessionData = string(sessionData)
+	ceremony.CreatedAt = time.Now().UTC()
+
+	if err := s.repo.CreateCeremony(ceremony, s.ceremonyTTL); err != nil {
+		return "", errors.New("could not store ceremony - " + err.Error())
+	}
+	return ceremony.ID, nil
+}
+
+// load and delete a ceremony of the expected kind
+func (s *PasskeyService) consumeCeremony(ceremonyID, kind string) (*models.WebAuthnCeremony, *webauthn.SessionData, error) {
+	ceremony, err := s.repo.ConsumeCeremony(ceremonyID)
+	if err != nil {
+		return nil, nil, errors.New("could not load ceremony - " + err.Error())
+	}
+	if ceremony == nil || ceremony.Kind != kind {
+		return nil, nil, ErrInvalidCeremony
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(ceremony.SessionData), &session); err != nil {
+		return nil, nil, errors.New("could not decode ceremony - " + err.Error())
+	}
+	return ceremony, &session, nil
+}
+
+// load an account with its passkeys
+func (s *PasskeyService) loadUser(userID gocql.UUID) (*passkeyUser, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	credentials, err := s.repo.GetCredentialsForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &passkeyUser{account: account, credentials: credentials}, nil
+}
+
+// convert a verified registration into the stored credential
+func newPasskeyCredential(userID gocql.UUID, credential *webauthn.Credential, name string) *models.PasskeyCredential {
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	now := time.Now().UTC()
+	return &models.PasskeyCredential{
+		UserID:          userID,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      transports,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+		Name:            name,
+		CreatedAt:       now,
+		LastUsedAt:      now,
+	}
+}
+
+// verification failures of the client response are the caller's fault
+func ceremonyError(err error) error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) {
+		return ErrPasskeyVerification
+	}
+	return err
+}
+
+// passkeyUser adapts an account to the webauthn library
+type passkeyUser struct {
+	account     *models.Account
+	credentials []models.PasskeyCredential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return u.account.ID.Bytes()
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.account.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.account.Email
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, stored := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+		for _, transport := range stored.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              stored.CredentialID,
+			PublicKey:       stored.PublicKey,
+			AttestationType: stored.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				UserPresent:    true,
+				BackupEligible: stored.BackupEligible,
+				BackupState:    stored.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    stored.AAGUID,
+				SignCount: uint32(stored.SignCount),
+			},
+		})
+	}
+	return credentials
+}
+
+// find a stored credential by id
+func (u *passkeyUser) find(credentialID []byte) *models.PasskeyCredential {
+	for i := range u.credentials {
+		if bytes.Equal(u.credentials[i].CredentialID, credentialID) {
+			return &u.credentials[i]
+		}
+	}
+	return nil
+}
+
+// custom errors for passkeys
+var (
+	ErrInvalidCeremony     = errors.New("invalid or expired ceremony")
+	ErrPasskeyVerification = errors.New("passkey verification failed")
+	ErrPasskeyBlocked      = errors.New("passkey blocked, it may have been cloned")
+	ErrLastPasskey         = errors.New("cannot remove the only credential of a passwordless account")
+)
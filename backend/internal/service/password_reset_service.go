@@ -0,0 +1,159 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/pkg/mail"
+	"backend/pkg/utils"
+
+	"errors"
+	"log"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// PasswordResetService handles forgotten password recovery via emailed one-time tokens
+type PasswordResetService struct {
+	accountService *AccountService
+	account_repo   *repository.AccountRepository
+	session_repo   *repository.SessionRepository
+	reset_repo     *repository.PasswordResetRepository
+	mailer         mail.Mailer
+	pepper         string
+	passwordParams utils.Argon2Params    // argon2 parameters for new password hashes
+	passwordPolicy *utils.PasswordPolicy // rules new passwords must satisfy
+	appURL         string                // client app url, the emailed link opens its reset page
+	tokenTTL       time.Duration         // lifespan of reset tokens
+	logger         *log.Logger
+}
+
+// NewPasswordResetService creates a new password reset service instance
+func NewPasswordResetService(accountService *AccountService, account_repo *repository.AccountRepository, session_repo *repository.SessionRepository, reset_repo *repository.PasswordResetRepository, mailer mail.Mailer, pepper string, passwordParams utils.Argon2Params, passwordPolicy *utils.PasswordPolicy, appURL string, tokenTTL time.Duration, logger *log.Logger) *PasswordResetService {
+	return &PasswordResetService{
+		accountService: accountService,
+		account_repo:   account_repo,
+		session_repo:   session_repo,
+		reset_repo:     reset_repo,
+		mailer:         mailer,
+		pepper:         pepper,
+		passwordParams: passwordParams,
+		passwordPolicy: passwordPolicy,
+		appURL:         appURL,
+		tokenTTL:       tokenTTL,
+		logger:         logger,
+	}
+}
+
+// RequestReset mails a reset link if an account exists for the email
+// (unknown emails are silently ignored so the endpoint cannot be used to probe for accounts)
+func (s *PasswordResetService) RequestReset(email string) error {
+	account, err := s.account_repo.GetAccountByEmail(email)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return nil
+	}
+
+	token, err := utils.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	// only the hash is stored, a database leak does not expose usable tokens
+	resetToken := &models.PasswordResetToken{
+		TokenHash: utils.HashLookupToken(token, s.pepper),
+		UserID:    account.ID,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := s.reset_repo.CreateToken(resetToken, s.tokenTTL); err != nil {
+		return errors.New("failed to store reset token - " + err.Error())
+	}
+
+	// the reset page asks for the new password and posts it with the token to /auth/reset_password
+	link := s.appURL + "/reset-password?token=" + url.QueryEscape(token)
+	body := "Hi,\n\n" +
+		"We received a request to reset the password of your account. Open the following link to choose a new password:\n\n" +
+		link + "\n\n" +
+		"The link expires in " + strconv.Itoa(int(s.tokenTTL.Minutes())) + " minutes and can only be used once. " +
+		"If you did not request a reset you can ignore this email, your password stays unchanged.\n"
+
+	// a failed delivery is only logged, an error response would reveal that the account exists
+	if err := s.mailer.Send(account.Email, "Reset your password", body); err != nil {
+		s.logger.Printf("failed to send password reset email: %v", err)
+	}
+	return nil
+}
+
+// ResetPassword redeems a reset token, sets the new password and logs out every device
+func (s *PasswordResetService) ResetPassword(token, newPassword string) error {
+	tokenHash := utils.HashLookupToken(token, s.pepper)
+
+	// look the token up without redeeming it, a rejected password must not use up the link
+	resetToken, err := s.reset_repo.GetToken(tokenHash)
+	if err != nil {
+		return err
+	}
+	if resetToken == nil {
+		return ErrInvalidResetToken
+	}
+
+	account, err := s.account_repo.GetAccountByID(resetToken.UserID)
+	if err != nil {
+		return err
+	}
+	if account == nil {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.passwordPolicy.Validate(newPassword, account.Email); err != nil {
+		return err
+	}
+
+	// hash before redeeming, nothing is changed if hashing fails
+	newHash, err := utils.HashPassword(newPassword, s.pepper, s.passwordParams)
+	if err != nil {
+		return err
+	}
+
+	redeemed, err := s.reset_repo.ConsumeToken(tokenHash)
+	if err != nil {
+		return err
+	}
+	if redeemed == nil {
+		return ErrInvalidResetToken
+	}
+
+	if err := s.setPassword(account, newHash); err != nil {
+		// give the link back, otherwise a failed write could leave the owner without any way in
+		if remaining := s.tokenTTL - time.Since(redeemed.CreatedAt); remaining >= time.Second {
+			if restoreErr := s.reset_repo.CreateToken(redeemed, remaining); restoreErr != nil {
+				s.logger.Printf("failed to restore password reset token: %v", restoreErr)
+			}
+		}
+		return err
+	}
+
+	// whoever had access before the reset must log in again
+	if err := s.session_repo.DeleteAllSessionsForUser(account.ID); err != nil {
+		return errors.New("failed to delete associated sessions - " + err.Error())
+	}
+
+	return nil
+}
+
+// store the new password, the reset proves the address so an unverified account is taken over at the same time
+func (s *PasswordResetService) setPassword(account *models.Account, newHash string) error {
+	if !account.Verified {
+		return s.accountService.ConfirmEmailOwnership(account, newHash)
+	}
+
+	if err := s.account_repo.UpdatePassword(account.ID, newHash); err != nil {
+		return errors.New("failed to update password - " + err.Error())
+	}
+	return nil
+}
+
+// custom error for unknown, expired or already used reset tokens
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
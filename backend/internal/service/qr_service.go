@@ -3,25 +3,37 @@ package service
 import (
 	"backend/internal/models"
 	"backend/internal/repository"
+	"backend/pkg/qrcode"
+	"backend/pkg/utils"
 	"fmt"
 	"time"
 
+	"archive/zip"
+	"bytes"
+	"encoding/json"
 	"errors"
+	"strings"
 
 	"github.com/gocql/gocql"
 )
 
 type QRService struct {
-	action_repo *repository.QRActionRepository
-	code_repo   *repository.QRCodeRepository
-	scan_repo   *repository.UserQRScanRepository
+	action_repo    *repository.QRActionRepository
+	code_repo      *repository.QRCodeRepository
+	scan_repo      *repository.UserQRScanRepository
+	qrKeys         *utils.QRKeySet // signs and verifies qr payloads
+	acceptUnsigned bool            // scans by plain qr code id are still allowed (codes printed before signing)
+	bulkMaxCodes   int             // codes a single bulk creation may contain
 }
 
-func NewQRService(action_repo *repository.QRActionRepository, code_repo *repository.QRCodeRepository, scan_repo *repository.UserQRScanRepository) *QRService {
+func NewQRService(action_repo *repository.QRActionRepository, code_repo *repository.QRCodeRepository, scan_repo *repository.UserQRScanRepository, qrKeys *utils.QRKeySet, acceptUnsigned bool, bulkMaxCodes int) *QRService {
 	return &QRService{
-		action_repo: action_repo,
-		code_repo:   code_repo,
-		scan_repo:   scan_repo,
+		action_repo:    action_repo,
+		code_repo:      code_repo,
+		scan_repo:      scan_repo,
+		qrKeys:         qrKeys,
+		acceptUnsigned: acceptUnsigned,
+		bulkMaxCodes:   bulkMaxCodes,
 	}
 }
 
@@ -54,6 +66,280 @@ func (s *QRService) AddQRCode(action_id gocql.UUID, qr_code_type models.QRCodeUs
 
 }
 
+// AddQRCodeBatch creates count codes for an action with shared settings. the client chosen batch id makes the
+// call idempotent: a retry returns the codes of the first call (created = false), other settings fail
+func (s *QRService) AddQRCodeBatch(batch_id, action_id gocql.UUID, qr_code_type models.QRCodeUsageType, max_usages, expire_mins, count int) (*models.QRCodeBatch, bool, error) {
+	if count < 1 || count > s.bulkMaxCodes {
+		return nil, false, fmt.Errorf("%w, at most %d codes", ErrQRBatchSize, s.bulkMaxCodes)
+	}
+	action, err := s.action_repo.GetQRActionByID(action_id)
+	if err != nil {
+		return nil, false, errors.New("failed to get qr action - " + err.Error())
+	}
+	if action == nil {
+		return nil, false, ErrQRActionNotFound
+	}
+
+	now := time.Now().UTC()
+	batch := &models.QRCodeBatch{
+		ID:         batch_id,
+		ActionId:   action_id,
+		QrCodeType: qr_code_type,
+		MaxUsages:  max_usages,
+		ExpireMins: expire_mins,
+		ExpiresAt:  now.Add(time.Duration(expire_mins) * time.Minute),
+		CodeIds:    make([]gocql.UUID, count),
+		CreatedAt:  now,
+	}
+	for i := range batch.CodeIds {
+		batch.CodeIds[i], _ = gocql.RandomUUID() // ignoring error since it should never fail
+	}
+
+	created, err := s.code_repo.ClaimQRCodeBatch(batch)
+	if err != nil {
+		return nil, false, errors.New("failed to store qr code batch - " + err.Error())
+	}
+
+	if !created {
+		existing, err := s.code_repo.GetQRCodeBatch(batch_id)
+		if err != nil {
+			return nil, false, errors.New("failed to get qr code batch - " + err.Error())
+		}
+		if existing == nil {
+			return nil, false, errors.New("qr code batch is not readable yet, try again")
+		}
+		if existing.ActionId != action_id || existing.QrCodeType != qr_code_type || existing.MaxUsages != max_usages ||
+			existing.ExpireMins != expire_mins || len(existing.CodeIds) != count {
+			return nil, false, ErrQRBatchConflict
+		}
+		if existing.Complete {
+			return existing, false, nil
+		}
+
+		// the first call stopped before all codes were stored (or is still running), the inserts are upserts
+		batch = existing
+	}
+
+	if err := s.code_repo.CreateQRCodes(batch.Codes()); err != nil {
+		return nil, false, errors.New("failed to store qr codes - " + err.Error())
+	}
+	if err := s.code_repo.CompleteQRCodeBatch(batch.ID); err != nil {
+		return nil, false, errors.New("failed to complete qr code batch - " + err.Error())
+	}
+	batch.Complete = true
+
+	return batch, created, nil
+}
+
+// SignQRCode returns the signed payload to print for a qr code
+func (s *QRService) SignQRCode(qr_code *models.QRCode) (string, error) {
+	return s.qrKeys.Sign(qr_code.ID, qr_code.ExpiresAt)
+}
+
+// GetQRCodePayload returns the signed payload of an existing qr code (signed with the current key)
+func (s *QRService) GetQRCodePayload(id gocql.UUID) (string, error) {
+	qr_code, err := s.code_repo.GetQRCodeByID(id)
+	if err != nil {
+		return "", errors.New("failed to get qr code - " + err.Error())
+	}
+	if qr_code == nil {
+		return "", errors.New("this qr code does not exist")
+	}
+
+	return s.SignQRCode(qr_code)
+}
+
+// RenderQRCodeImage draws the signed payload of a qr code as png or svg image
+func (s *QRService) RenderQRCodeImage(id gocql.UUID, format string, level qrcode.Level, style qrcode.Style) ([]byte, error) {
+	payload, err := s.GetQRCodePayload(id)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := qrcode.Encode([]byte(payload), level)
+	if err != nil {
+		return nil, err
+	}
+
+	return renderQRImage(code, format, style)
+}
+
+// GetQRCodesForPrint resolves the codes of a print job, either explicit ids (kept in order) or all
+// unexpired codes of an action. at most max_count codes are returned
+func (s *QRService) GetQRCodesForPrint(ids []gocql.UUID, action_id *gocql.UUID, max_count int) ([]models.QRCode, error) {
+	if (len(ids) == 0) == (action_id == nil) {
+		return nil, errors.New("select either qr code ids or an action")
+	}
+	if len(ids) > max_count {
+		return nil, fmt.Errorf("at most %d qr codes can be printed at once", max_count)
+	}
+
+	now := time.Now().UTC()
+
+	if action_id != nil {
+		// one more than allowed to tell a full batch from a truncated one
+		codes, err := s.code_repo.GetQRCodesByActionID(*action_id, max_count+1)
+		if err != nil {
+			return nil, errors.New("failed to get qr codes - " + err.Error())
+		}
+		if len(codes) > max_count {
+			return nil, fmt.Errorf("this action has more than %d qr codes, select them by id", max_count)
+		}
+
+		var printable []models.QRCode
+		for _, qr_code := range codes {
+			if qr_code.ExpiresAt.After(now) {
+				printable = append(printable, qr_code)
+			}
+		}
+		if len(printable) == 0 {
+			return nil, errors.New("this action has no unexpired qr codes")
+		}
+		return printable, nil
+	}
+
+	codes := make([]models.QRCode, 0, len(ids))
+	for _, id := range ids {
+		qr_code, err := s.code_repo.GetQRCodeByID(id)
+		if err != nil {
+			return nil, errors.New("failed to get qr code - " + err.Error())
+		}
+		if qr_code == nil {
+			return nil, errors.New("qr code " + id.String() + " does not exist")
+		}
+		if !qr_code.ExpiresAt.After(now) {
+			return nil, errors.New("qr code " + id.String() + " is expired")
+		}
+		codes = append(codes, *qr_code)
+	}
+	return codes, nil
+}
+
+// RenderPrintSheet lays the codes out on pdf pages, labels are the action name, expiry and short code
+func (s *QRService) RenderPrintSheet(codes []models.QRCode, level qrcode.Level, layout qrcode.SheetLayout, labels bool) ([]byte, error) {
+	action_names := make(map[gocql.UUID]string) // codes of one action share the lookup
+	items := make([]qrcode.SheetItem, len(codes))
+
+	for i := range codes {
+		code, err := s.encodeQRCode(&codes[i], level)
+		if err != nil {
+			return nil, err
+		}
+		items[i].Code = code
+
+		if !labels {
+			continue
+		}
+		name, ok := action_names[codes[i].ActionId]
+		if !ok {
+			if name, err = s.getActionName(codes[i].ActionId); err != nil {
+				return nil, err
+			}
+			action_names[codes[i].ActionId] = name
+		}
+		items[i].Labels = []string{name, "expires " + codes[i].ExpiresAt.UTC().Format("2006-01-02 15:04") + " UTC", shortQRCodeID(codes[i].ID)}
+	}
+
+	return qrcode.RenderSheet(items, layout)
+}
+
+// RenderImageArchive returns a zip with one png or svg file per code, labels put the short code below each code
+func (s *QRService) RenderImageArchive(codes []models.QRCode, format string, level qrcode.Level, style qrcode.Style, labels bool) ([]byte, error) {
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+
+	for i := range codes {
+		code, err := s.encodeQRCode(&codes[i], level)
+		if err != nil {
+			return nil, err
+		}
+		if labels {
+			style.Caption = shortQRCodeID(codes[i].ID)
+		}
+
+		data, err := renderQRImage(code, format, style)
+		if err != nil {
+			return nil, err
+		}
+
+		file, err := archive.Create("qr-" + codes[i].ID.String() + "." + format)
+		if err != nil {
+			return nil, errors.New("failed to create archive entry - " + err.Error())
+		}
+		if _, err := file.Write(data); err != nil {
+			return nil, errors.New("failed to write archive entry - " + err.Error())
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, errors.New("failed to finish archive - " + err.Error())
+	}
+	return buffer.Bytes(), nil
+}
+
+// encodes the signed payload of a qr code
+func (s *QRService) encodeQRCode(qr_code *models.QRCode, level qrcode.Level) (*qrcode.Code, error) {
+	payload, err := s.SignQRCode(qr_code)
+	if err != nil {
+		return nil, err
+	}
+
+	return qrcode.Encode([]byte(payload), level)
+}
+
+// printable name of an action, taken from the name, title or type field of its json
+func (s *QRService) getActionName(id gocql.UUID) (string, error) {
+	qr_action, err := s.action_repo.GetQRActionByID(id)
+	if err != nil {
+		return "", errors.New("failed to get qr action - " + err.Error())
+	}
+	if qr_action == nil {
+		return "", nil // the code outlived its action, it is printed without a name
+	}
+
+	var fields map[string]interface{}
+	if json.Unmarshal([]byte(qr_action.ActionJson), &fields) == nil {
+		for _, key := range []string{"name", "title", "type"} {
+			if name, ok := fields[key].(string); ok && name != "" {
+				return name, nil
+			}
+		}
+	}
+	return "action " + shortQRCodeID(id), nil
+}
+
+func renderQRImage(code *qrcode.Code, format string, style qrcode.Style) ([]byte, error) {
+	if format == "svg" {
+		return code.SVG(style)
+	}
+	return code.PNG(style)
+}
+
+// human readable reference printed next to a code (first 8 hex digits of the id)
+func shortQRCodeID(id gocql.UUID) string {
+	hex := strings.ToUpper(strings.ReplaceAll(id.String(), "-", ""))
+	return hex[:4] + "-" + hex[4:8]
+}
+
+// GetActionJsonFromPayload scans a signed payload, forged and expired payloads are rejected before any database access
+func (s *QRService) GetActionJsonFromPayload(payload string, user_id gocql.UUID) (string, error) {
+	verified, err := s.qrKeys.Verify(payload, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+
+	return s.GetActionJsonFromQRCodeId(verified.CodeID, user_id)
+}
+
+// GetActionJsonFromUnsignedId scans a plain qr code id if unsigned codes are still accepted
+func (s *QRService) GetActionJsonFromUnsignedId(qr_code_id, user_id gocql.UUID) (string, error) {
+	if !s.acceptUnsigned {
+		return "", ErrUnsignedQRCode
+	}
+
+	return s.GetActionJsonFromQRCodeId(qr_code_id, user_id)
+}
+
 func (s *QRService) GetActionJsonFromQRCodeId(qr_code_id, user_id gocql.UUID) (string, error) {
 	// get qr code
 	qr_code, err := s.code_repo.GetQRCodeByID(qr_code_id)
@@ -91,23 +377,41 @@ func (s *QRService) GetActionJsonFromQRCodeId(qr_code_id, user_id gocql.UUID) (s
 			return "", fmt.Errorf("this qr code has reached its maximum number of uses for this account (type: %d, max usages: %d, usages: %d)", qr_code.QrCodeType, qr_code.MaxUsages, qr_scan.Count)
 		}
 	case models.Global:
-		global_usage, err := s.scan_repo.GetGlobalUsageCountByQRCodeId(qr_code.ID)
-		if err != nil {
-			return "", errors.New("failed to get global usage count - " + err.Error())
-		}
-		if qr_code.MaxUsages > 0 && (global_usage >= qr_code.MaxUsages || qr_scan.Count > 1) {
+		if qr_code.MaxUsages > 0 && qr_scan.Count > 1 {
 			return "", errors.New("this qr code has reached its maximum number of uses")
 		}
 	}
 
 	qr_action, err := s.action_repo.GetQRActionByID(qr_code.ActionId)
-	if err != nil || qr_action == nil {
-		return "", errors.New("this action doesnt exist - " + err.Error())
+	if err != nil {
+		return "", errors.New("failed to get qr action - " + err.Error())
+	}
+	if qr_action == nil {
+		return "", ErrQRActionNotFound
+	}
+
+	// reserve a global use last, it is given back if the scan fails afterwards
+	claimedGlobal := qr_code.QrCodeType == models.Global && qr_code.MaxUsages > 0
+	if claimedGlobal {
+		claimed, err := s.scan_repo.ClaimGlobalUsage(qr_code.ID, qr_code.MaxUsages)
+		if err == repository.ErrUsageClaimContention {
+			return "", ErrQRCodeBusy
+		} else if err != nil {
+			return "", errors.New("failed to claim global usage - " + err.Error())
+		}
+		if !claimed {
+			return "", errors.New("this qr code has reached its maximum number of uses")
+		}
 	}
 
 	err = s.scan_repo.UpdateCount(user_id, qr_code_id, qr_scan.Count+1)
 
 	if err != nil {
+		if claimedGlobal {
+			if releaseErr := s.scan_repo.ReleaseGlobalUsage(qr_code.ID); releaseErr != nil {
+				return "", errors.New("failed to update usage count - " + err.Error() + ", failed to release global usage - " + releaseErr.Error())
+			}
+		}
 		return "", errors.New("failed to update usage count - " + err.Error())
 	}
 	return qr_action.ActionJson, nil
@@ -127,6 +431,9 @@ func (s *QRService) DeleteQRCode(id gocql.UUID) error {
 	if err != nil {
 		return errors.New("failed to delete associated user qr scans - " + err.Error())
 	}
+	if err = s.scan_repo.DeleteGlobalUsage(id); err != nil {
+		return errors.New("failed to delete global usage - " + err.Error())
+	}
 
 	return s.code_repo.DeleteQRCode(id)
 }
@@ -152,8 +459,11 @@ func (s *QRService) AddQRAction(action_json string) (*models.QRAction, error) {
 
 func (s *QRService) GetQRActionById(id gocql.UUID) (*models.QRAction, error) {
 	qr_action, err := s.action_repo.GetQRActionByID(id)
-	if err != nil || qr_action == nil {
-		return nil, errors.New("this action doesnt exist - " + err.Error())
+	if err != nil {
+		return nil, errors.New("failed to get qr action - " + err.Error())
+	}
+	if qr_action == nil {
+		return nil, ErrQRActionNotFound
 	}
 
 	return qr_action, nil
@@ -171,3 +481,12 @@ func (s *QRService) DeleteQRAction(id gocql.UUID) error {
 func (s *QRService) GetAllQRActions(max_count int) ([]models.QRAction, error) {
 	return s.action_repo.GetAllQRActions(max_count)
 }
+
+// custom errors for qr codes
+var (
+	ErrUnsignedQRCode   = errors.New("unsigned qr codes are not accepted, scan the signed payload")
+	ErrQRBatchSize      = errors.New("invalid number of qr codes")
+	ErrQRBatchConflict  = errors.New("this batch id was already used with other settings")
+	ErrQRActionNotFound = errors.New("this action does not exist")
+	ErrQRCodeBusy       = errors.New("too many concurrent scans of this qr code, try again")
+)
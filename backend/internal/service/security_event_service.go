@@ -0,0 +1,40 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"github.com/gocql/gocql"
+)
+
+// SecurityEventService records security relevant events for auditing
+type SecurityEventService struct {
+	repo *repository.SecurityEventRepository
+}
+
+// NewSecurityEventService creates a new security event service instance
+func NewSecurityEventService(repo *repository.SecurityEventRepository) *SecurityEventService {
+	return &SecurityEventService{
+		repo: repo,
+	}
+}
+
+// Record stores a new event for the user
+func (s *SecurityEventService) Record(userID gocql.UUID, eventType string, deviceID gocql.UUID, ip, details string) error {
+	eventID := gocql.TimeUUID()
+
+	return s.repo.CreateEvent(&models.SecurityEvent{
+		UserID:    userID,
+		EventID:   eventID,
+		EventType: eventType,
+		DeviceID:  deviceID,
+		IP:        ip,
+		Details:   details,
+		CreatedAt: eventID.Time(),
+	})
+}
+
+// GetEventsForUser returns the newest events of a user
+func (s *SecurityEventService) GetEventsForUser(userID gocql.UUID, maxCount int) ([]models.SecurityEvent, error) {
+	return s.repo.GetEventsForUser(userID, maxCount)
+}
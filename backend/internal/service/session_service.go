@@ -5,6 +5,7 @@ import (
 	"backend/internal/repository"
 	"backend/pkg/utils"
 
+	"errors"
 	"time"
 
 	"github.com/gocql/gocql"
@@ -12,33 +13,44 @@ import (
 
 // SessionService handles business logic for persistent sessions
 type SessionService struct {
-	repo     *repository.SessionRepository
-	pepper   string
-	tokenTTL time.Duration
+	repo             *repository.SessionRepository
+	events           *SecurityEventService
+	pepper           string
+	tokenTTL         time.Duration
+	revokeAllOnReuse bool // revoke every session of the user instead of only the affected device
 }
 
 // creates a new session service instance
-func NewSessionService(repo *repository.SessionRepository, pepper string, tokenTTL time.Duration) *SessionService {
+func NewSessionService(repo *repository.SessionRepository, events *SecurityEventService, pepper string, tokenTTL time.Duration, revokeAllOnReuse bool) *SessionService {
 	return &SessionService{
-		repo:     repo,
-		pepper:   pepper,
-		tokenTTL: tokenTTL,
+		repo:             repo,
+		events:           events,
+		pepper:           pepper,
+		tokenTTL:         tokenTTL,
+		revokeAllOnReuse: revokeAllOnReuse,
 	}
 }
 
 // CreatePermanentSession establishes a new device bound session
-func (s *SessionService) CreatePermanentSession(userID, deviceID gocql.UUID, token string) error {
+func (s *SessionService) CreatePermanentSession(userID, deviceID gocql.UUID, token string, meta models.SessionMetadata) error {
 	// secure hash token before storage
 	hashedToken := utils.HashToken(token, s.pepper)
 
+	// every login starts a new refresh token family
+	familyID, _ := gocql.RandomUUID() // ignoring error since it should never fail
+
 	// create session model
 	session := &models.PermanentSession{
-		UserID:    userID,
-		DeviceID:  deviceID,
-		TokenHash: hashedToken,
-		CreatedAt: time.Now().UTC(),
-		LastUsed:  time.Now().UTC(),
-		ExpiresAt: time.Now().UTC().Add(s.tokenTTL),
+		UserID:     userID,
+		DeviceID:   deviceID,
+		TokenHash:  hashedToken,
+		CreatedAt:  time.Now().UTC(),
+		LastUsed:   time.Now().UTC(),
+		ExpiresAt:  time.Now().UTC().Add(s.tokenTTL),
+		FamilyID:   familyID,
+		DeviceName: meta.DeviceName,
+		IP:         meta.IP,
+		UserAgent:  meta.UserAgent,
 	}
 
 	// persist to database
@@ -53,13 +65,7 @@ func (s *SessionService) ValidateSession(userID, deviceID gocql.UUID, token stri
 		return false, err
 	}
 
-	// check expiration
-	if time.Now().UTC().After(session.ExpiresAt) {
-		return false, nil
-	}
-
-	// verify token matches stored hash
-	return utils.VerifyToken(token, s.pepper, session.TokenHash), nil
+	return s.matchesSession(session, token), nil
 }
 
 // CheckSession checks if a session is exist under the user and device id
@@ -74,19 +80,102 @@ func (s *SessionService) CheckSession(userID, deviceID gocql.UUID) (bool, error)
 	return true, nil
 }
 
-// RotateSession updates a session with new credentials
-func (s *SessionService) RotateSession(userID, deviceID gocql.UUID, oldToken, newToken string) error {
-	// validate existing token
-	valid, err := s.ValidateSession(userID, deviceID, oldToken)
-	if err != nil || !valid {
+// RotateSession updates a session with new credentials and remembers the old token for reuse detection
+func (s *SessionService) RotateSession(userID, deviceID gocql.UUID, oldToken, newToken string, meta models.SessionMetadata) error {
+	session, err := s.repo.GetSession(userID, deviceID)
+	if err != nil {
 		return err
 	}
+	if session == nil || !s.matchesSession(session, oldToken) {
+		return ErrInvalidSession
+	}
 
 	// create new secure hash
 	newHash := utils.HashToken(newToken, s.pepper)
 
-	// Update session in database
-	return s.repo.RotateSessionToken(userID, deviceID, newHash)
+	// conditional update so two concurrent refreshes cannot both succeed with the same token
+	applied, err := s.repo.RotateSessionToken(userID, deviceID, session.TokenHash, newHash, meta.IP, meta.UserAgent)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrInvalidSession
+	}
+
+	return s.repo.RecordRotatedToken(&models.RotatedRefreshToken{
+		TokenID:   utils.HashLookupToken(oldToken, s.pepper),
+		UserID:    userID,
+		DeviceID:  deviceID,
+		FamilyID:  session.FamilyID,
+		RotatedAt: time.Now().UTC(),
+	}, s.tokenTTL)
+}
+
+// DetectTokenReuse checks whether a rejected refresh token was already rotated before,
+// in that case the token family is revoked and a security event is recorded
+func (s *SessionService) DetectTokenReuse(userID, deviceID gocql.UUID, token, ip string) (bool, error) {
+	rotated, err := s.repo.GetRotatedToken(utils.HashLookupToken(token, s.pepper))
+	if err != nil {
+		return false, err
+	}
+	if rotated == nil || rotated.UserID != userID {
+		return false, nil
+	}
+
+	details := "rotated refresh token was presented again, "
+	if s.revokeAllOnReuse {
+		if err := s.repo.DeleteAllSessionsForUser(userID); err != nil {
+			return true, err
+		}
+		details += "all sessions revoked"
+	} else {
+		// only revoke the device session if it still belongs to the same family (not a newer login)
+		current, err := s.repo.GetSession(userID, rotated.DeviceID)
+		if err != nil {
+			return true, err
+		}
+		if current != nil && current.FamilyID == rotated.FamilyID {
+			if err := s.repo.DeleteSession(userID, rotated.DeviceID); err != nil {
+				return true, err
+			}
+			details += "device session revoked"
+		} else {
+			details += "token family already ended"
+		}
+	}
+
+	return true, s.events.Record(userID, models.EventRefreshTokenReuse, deviceID, ip, details)
+}
+
+// ListSessions returns every device session of a user
+func (s *SessionService) ListSessions(userID gocql.UUID) ([]models.PermanentSession, error) {
+	return s.repo.GetSessionsForUser(userID)
+}
+
+// RenameSession sets the user assigned name of a device session
+func (s *SessionService) RenameSession(userID, deviceID gocql.UUID, deviceName string) error {
+	return s.repo.UpdateDeviceName(userID, deviceID, deviceName)
+}
+
+// RevokeOtherSessions deletes every session of a user except the given device and returns the amount revoked
+func (s *SessionService) RevokeOtherSessions(userID, currentDeviceID gocql.UUID) (int, error) {
+	sessions, err := s.repo.GetSessionsForUser(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, session := range sessions {
+		if session.DeviceID == currentDeviceID {
+			continue
+		}
+		if err := s.repo.DeleteSession(userID, session.DeviceID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+
+	return revoked, nil
 }
 
 // DeleteSession removes a session from the database
@@ -97,3 +186,14 @@ func (s *SessionService) DeleteSession(userID, deviceID gocql.UUID) error {
 func (s *SessionService) DeleteAllSessionsForUser(userID gocql.UUID) error {
 	return s.repo.DeleteAllSessionsForUser(userID)
 }
+
+// check expiration and verify token matches stored hash
+func (s *SessionService) matchesSession(session *models.PermanentSession, token string) bool {
+	if time.Now().UTC().After(session.ExpiresAt) {
+		return false
+	}
+	return utils.VerifyToken(token, s.pepper, session.TokenHash)
+}
+
+// custom error for unknown, expired or already rotated sessions
+var ErrInvalidSession = errors.New("invalid session")
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// upper bound for cached lookups, the cache is reset when it is reached
+const maxRevocationCacheEntries = 100000
+
+// TokenRevocationService manages the access token denylist with an in-process lookup cache
+type TokenRevocationService struct {
+	repo     *repository.RevokedTokenRepository
+	cacheTTL time.Duration // how long a "not revoked" answer is trusted (revocations by other instances become visible after this)
+
+	mu    sync.Mutex
+	cache map[string]revocationCacheEntry
+}
+
+// cached denylist lookup
+type revocationCacheEntry struct {
+	revoked bool
+	expires time.Time
+}
+
+// NewTokenRevocationService creates a new token revocation service instance
+func NewTokenRevocationService(repo *repository.RevokedTokenRepository, cacheTTL time.Duration) *TokenRevocationService {
+	return &TokenRevocationService{
+		repo:     repo,
+		cacheTTL: cacheTTL,
+		cache:    make(map[string]revocationCacheEntry),
+	}
+}
+
+// Revoke adds the token to the denylist until it expires
+func (s *TokenRevocationService) Revoke(tokenID string, userID gocql.UUID, expiresAt time.Time) error {
+	if tokenID == "" {
+		return ErrMissingTokenID
+	}
+
+	// expired tokens are rejected by the signature check already
+	if !expiresAt.After(time.Now()) {
+		return nil
+	}
+
+	if err := s.repo.RevokeToken(&models.RevokedToken{
+		TokenID:   tokenID,
+		UserID:    userID,
+		RevokedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return errors.New("could not revoke token - " + err.Error())
+	}
+
+	// a revocation is final, so it can stay cached until the token expires
+	s.store(tokenID, revocationCacheEntry{revoked: true, expires: expiresAt})
+	return nil
+}
+
+// IsRevoked reports whether the token is on the denylist
+func (s *TokenRevocationService) IsRevoked(tokenID string) (bool, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	entry, ok := s.cache[tokenID]
+	s.mu.Unlock()
+
+	if ok && now.Before(entry.expires) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := s.repo.IsRevoked(tokenID)
+	if err != nil {
+		return false, err
+	}
+
+	s.store(tokenID, revocationCacheEntry{revoked: revoked, expires: now.Add(s.cacheTTL)})
+	return revoked, nil
+}
+
+// add an entry to the cache, dropping expired entries (or everything) once it is full
+func (s *TokenRevocationService) store(tokenID string, entry revocationCacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.cache) >= maxRevocationCacheEntries {
+		now := time.Now()
+		for id, cached := range s.cache {
+			if !now.Before(cached.expires) {
+				delete(s.cache, id)
+			}
+		}
+
+		if len(s.cache) >= maxRevocationCacheEntries {
+			s.cache = make(map[string]revocationCacheEntry)
+		}
+	}
+
+	s.cache[tokenID] = entry
+}
+
+// custom errors for token revocation
+var (
+	ErrMissingTokenID = errors.New("token has no jti")
+)
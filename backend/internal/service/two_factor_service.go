@@ -0,0 +1,291 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+	"backend/pkg/utils"
+
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	recoveryCodeCount  = 10
+	challengeTokenType = "2fa_challenge"
+)
+
+// TwoFactorService handles totp enrollment, verification and recovery codes
+type TwoFactorService struct {
+	account_repo *repository.AccountRepository
+	repo         *repository.TwoFactorRepository
+	pepper       string
+	jwtKeys      *utils.KeySet
+	issuer       string        // name shown in authenticator apps
+	challengeTTL time.Duration // lifespan of the login challenge token
+}
+
+// NewTwoFactorService creates a new two factor service instance
+func NewTwoFactorService(account_repo *repository.AccountRepository, repo *repository.TwoFactorRepository, pepper string, jwtKeys *utils.KeySet, issuer string, challengeTTL time.Duration) *TwoFactorService {
+	return &TwoFactorService{
+		account_repo: account_repo,
+		repo:         repo,
+		pepper:       pepper,
+		jwtKeys:      jwtKeys,
+		issuer:       issuer,
+		challengeTTL: challengeTTL,
+	}
+}
+
+// Enroll creates a new unconfirmed totp secret and returns it with the otpauth uri
+func (s *TwoFactorService) Enroll(userID gocql.UUID) (string, string, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil || account == nil {
+		return "", "", errors.New("account not found")
+	}
+
+	existing, err := s.repo.GetSecret(userID)
+	if err != nil {
+		return "", "", err
+	}
+	if existing != nil && existing.Confirmed {
+		return "", "", ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	// secret has to be readable later so it is encrypted instead of hashed
+	encrypted, err := utils.EncryptSecret(secret, s.pepper)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.repo.SaveSecret(&models.TOTPSecret{
+		UserID:    userID,
+		Secret:    encrypted,
+		Confirmed: false,
+		CreatedAt: time.Now().UTC(),
+	}); err != nil {
+		return "", "", errors.New("failed to store totp secret - " + err.Error())
+	}
+
+	return secret, utils.TOTPURI(s.issuer, account.Email, secret), nil
+}
+
+// Confirm activates two factor authentication after the first valid code and returns fresh recovery codes
+func (s *TwoFactorService) Confirm(userID gocql.UUID, code string) ([]string, error) {
+	secret, err := s.repo.GetSecret(userID)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	if secret.Confirmed {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	plainSecret, err := utils.DecryptSecret(secret.Secret, s.pepper)
+	if err != nil {
+		return nil, err
+	}
+
+	step, ok := utils.ValidateTOTP(plainSecret, code, time.Now().UTC(), secret.LastUsedStep)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	if err := s.repo.ConfirmSecret(userID, step); err != nil {
+		return nil, errors.New("failed to confirm totp secret - " + err.Error())
+	}
+
+	return s.RegenerateRecoveryCodes(userID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, the plain codes are only returned once
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID gocql.UUID) ([]string, error) {
+	plainCodes := make([]string, 0, recoveryCodeCount)
+	codes := make([]models.RecoveryCode, 0, recoveryCodeCount)
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		plain, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		codeID, _ := gocql.RandomUUID() // ignoring error since it should never fail
+		codes = append(codes, models.RecoveryCode{
+			UserID:   userID,
+			CodeID:   codeID,
+			CodeHash: utils.HashToken(normalizeRecoveryCode(plain), s.pepper),
+		})
+		plainCodes = append(plainCodes, plain)
+	}
+
+	if err := s.repo.ReplaceRecoveryCodes(userID, codes); err != nil {
+		return nil, errors.New("failed to store recovery codes - " + err.Error())
+	}
+
+	return plainCodes, nil
+}
+
+// IsEnabled reports whether the user has a confirmed authenticator
+func (s *TwoFactorService) IsEnabled(userID gocql.UUID) (bool, error) {
+	secret, err := s.repo.GetSecret(userID)
+	if err != nil {
+		return false, err
+	}
+	return secret != nil && secret.Confirmed, nil
+}
+
+// Verify checks a totp code or, if no code is given, a recovery code (both are single use)
+func (s *TwoFactorService) Verify(userID gocql.UUID, code, recoveryCode string) error {
+	secret, err := s.repo.GetSecret(userID)
+	if err != nil {
+		return err
+	}
+	if secret == nil || !secret.Confirmed {
+		return ErrTwoFactorNotEnrolled
+	}
+
+	if code == "" {
+		return s.useRecoveryCode(userID, recoveryCode)
+	}
+
+	plainSecret, err := utils.DecryptSecret(secret.Secret, s.pepper)
+	if err != nil {
+		return err
+	}
+
+	step, ok := utils.ValidateTOTP(plainSecret, code, time.Now().UTC(), secret.LastUsedStep)
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	// conditional update so a code cannot be replayed concurrently
+	applied, err := s.repo.UseStep(userID, step)
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// Disable removes the authenticator and recovery codes after verifying a code
+func (s *TwoFactorService) Disable(userID gocql.UUID, code, recoveryCode string) error {
+	if err := s.Verify(userID, code, recoveryCode); err != nil {
+		return err
+	}
+
+	if err := s.repo.DeleteSecret(userID); err != nil {
+		return errors.New("failed to delete totp secret - " + err.Error())
+	}
+	return s.repo.DeleteRecoveryCodes(userID)
+}
+
+// IssueChallenge creates the short lived token returned by the first login step
+func (s *TwoFactorService) IssueChallenge(userID, deviceID gocql.UUID, deviceName string) (string, error) {
+	return utils.GenerateTypedToken(
+		userID.String(),
+		challengeTokenType,
+		map[string]interface{}{"device_id": deviceID.String(), "device_name": deviceName},
+		s.jwtKeys,
+		s.challengeTTL,
+	)
+}
+
+// ValidateChallenge parses a challenge token and returns the user, device and device name it was issued for
+func (s *TwoFactorService) ValidateChallenge(token string) (gocql.UUID, gocql.UUID, string, error) {
+	claims, err := utils.ValidateToken(token, challengeTokenType, s.jwtKeys)
+	if err != nil {
+		return gocql.UUID{}, gocql.UUID{}, "", ErrInvalidChallenge
+	}
+
+	subject, _ := claims["sub"].(string)
+	device, _ := claims["device_id"].(string)
+	deviceName, _ := claims["device_name"].(string)
+
+	userID, err := gocql.ParseUUID(subject)
+	if err != nil {
+		return gocql.UUID{}, gocql.UUID{}, "", ErrInvalidChallenge
+	}
+	deviceID, err := gocql.ParseUUID(device)
+	if err != nil {
+		return gocql.UUID{}, gocql.UUID{}, "", ErrInvalidChallenge
+	}
+
+	return userID, deviceID, deviceName, nil
+}
+
+// ChallengeTTL returns the lifespan of challenge tokens
+func (s *TwoFactorService) ChallengeTTL() time.Duration {
+	return s.challengeTTL
+}
+
+// find and consume a matching recovery code
+func (s *TwoFactorService) useRecoveryCode(userID gocql.UUID, recoveryCode string) error {
+	normalized := normalizeRecoveryCode(recoveryCode)
+	if normalized == "" {
+		return ErrInvalidTwoFactorCode
+	}
+
+	codes, err := s.repo.GetRecoveryCodes(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		if !utils.VerifyToken(normalized, s.pepper, code.CodeHash) {
+			continue
+		}
+
+		applied, err := s.repo.ConsumeRecoveryCode(userID, code.CodeID)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			break // used by a concurrent request
+		}
+		return nil
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+// generate a human friendly recovery code (xxxxx-xxxxx)
+func generateRecoveryCode() (string, error) {
+	const alphabet = "abcdefghjkmnpqrstuvwxyz23456789" // no ambiguous characters
+	buf := make([]byte, 10)
+	for i := range buf {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		buf[i] = alphabet[n.Int64()]
+	}
+	return string(buf[:5]) + "-" + string(buf[5:]), nil
+}
+
+// strip formatting so codes can be typed with or without dashes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// custom errors for two factor authentication
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two factor authentication already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two factor authentication not enrolled")
+	ErrInvalidTwoFactorCode    = errors.New("invalid two factor code")
+	ErrInvalidChallenge        = errors.New("invalid or expired login challenge")
+)
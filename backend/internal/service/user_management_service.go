@@ -0,0 +1,214 @@
+package service
+
+import (
+	"backend/internal/models"
+	"backend/internal/repository"
+
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gocql/gocql"
+)
+
+// maximum number of pages scanned for a single search request
+const maxSearchPages = 20
+
+// UserDetails bundles an account with everything an admin needs to review it
+type UserDetails struct {
+	Account  *models.Account           `json:"account"`
+	Roles    []models.RoleAssignment   `json:"roles"`
+	Sessions []models.PermanentSession `json:"sessions"`
+	Scans    []models.UserQRScan       `json:"scans"`
+	Events   []models.SecurityEvent    `json:"events"`
+}
+
+// UserManagementService implements the admin actions on user accounts
+type UserManagementService struct {
+	account_repo  *repository.AccountRepository
+	session_repo  *repository.SessionRepository
+	user_qr_scans *repository.UserQRScanRepository
+	authorization *AuthorizationService
+	events        *SecurityEventService
+}
+
+// NewUserManagementService creates a new user management service instance
+func NewUserManagementService(
+	account_repo *repository.AccountRepository,
+	session_repo *repository.SessionRepository,
+	user_qr_scans *repository.UserQRScanRepository,
+	authorization *AuthorizationService,
+	events *SecurityEventService,
+) *UserManagementService {
+	return &UserManagementService{
+		account_repo:  account_repo,
+		session_repo:  session_repo,
+		user_qr_scans: user_qr_scans,
+		authorization: authorization,
+		events:        events,
+	}
+}
+
+// ListAccounts returns a page of accounts and the paging state of the next page (nil if done).
+// with a search term the pages are filtered by email substring, so a page can hold fewer or
+// slightly more than pageSize entries. truncated is set when the search stopped after maxSearchPages
+// before the page was filled, the next page continues the search where it stopped
+func (s *UserManagementService) ListAccounts(search string, pageSize int, pageState []byte) (accounts []models.Account, nextPage []byte, truncated bool, err error) {
+	search = strings.ToLower(strings.TrimSpace(search))
+
+	accounts = []models.Account{}
+	for scanned := 0; scanned < maxSearchPages; scanned++ {
+		page, nextPage, err := s.account_repo.ListAccounts(pageSize, pageState)
+		if err != nil {
+			return nil, nil, false, errors.New("could not list accounts - " + err.Error())
+		}
+
+		for _, account := range page {
+			if search == "" || strings.Contains(strings.ToLower(account.Email), search) {
+				accounts = append(accounts, account)
+			}
+		}
+
+		pageState = nextPage
+		if pageState == nil || len(accounts) >= pageSize {
+			return accounts, pageState, false, nil
+		}
+	}
+
+	return accounts, pageState, true, nil
+}
+
+// GetUserDetails returns an account with its roles, sessions, scan history and recent events
+func (s *UserManagementService) GetUserDetails(userID gocql.UUID) (*UserDetails, error) {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return nil, errors.New("could not get account - " + err.Error())
+	}
+	if account == nil {
+		return nil, ErrAccountNotFound
+	}
+
+	roles, err := s.authorization.GetRoles(userID)
+	if err != nil {
+		return nil, errors.New("could not get roles - " + err.Error())
+	}
+
+	sessions, err := s.session_repo.GetSessionsForUser(userID)
+	if err != nil {
+		return nil, errors.New("could not get sessions - " + err.Error())
+	}
+
+	scans, err := s.user_qr_scans.GetUserQRScansByUserId(userID)
+	if err != nil {
+		return nil, errors.New("could not get scans - " + err.Error())
+	}
+
+	events, err := s.events.GetEventsForUser(userID, 50)
+	if err != nil {
+		return nil, errors.New("could not get events - " + err.Error())
+	}
+
+	return &UserDetails{
+		Account:  account,
+		Roles:    roles,
+		Sessions: sessions,
+		Scans:    scans,
+		Events:   events,
+	}, nil
+}
+
+// DisableAccount blocks the account and ends all of its sessions
+func (s *UserManagementService) DisableAccount(userID, adminID gocql.UUID, ip string) error {
+	if err := s.checkTarget(userID, adminID); err != nil {
+		return err
+	}
+	if err := s.setDisabled(userID, true); err != nil {
+		return err
+	}
+
+	if err := s.session_repo.DeleteAllSessionsForUser(userID); err != nil {
+		return errors.New("could not delete sessions - " + err.Error())
+	}
+
+	return s.events.Record(userID, models.EventAccountDisabled, gocql.UUID{}, ip,
+		fmt.Sprintf("disabled by admin %s", adminID))
+}
+
+// EnableAccount lifts the block of a disabled account
+func (s *UserManagementService) EnableAccount(userID, adminID gocql.UUID, ip string) error {
+	if err := s.checkTarget(userID, adminID); err != nil {
+		return err
+	}
+	if err := s.setDisabled(userID, false); err != nil {
+		return err
+	}
+
+	return s.events.Record(userID, models.EventAccountEnabled, gocql.UUID{}, ip,
+		fmt.Sprintf("enabled by admin %s", adminID))
+}
+
+// ForceLogout ends all sessions of the user (access tokens stay valid until they expire)
+func (s *UserManagementService) ForceLogout(userID, adminID gocql.UUID, ip string) error {
+	if err := s.checkTarget(userID, adminID); err != nil {
+		return err
+	}
+	if err := s.session_repo.DeleteAllSessionsForUser(userID); err != nil {
+		return errors.New("could not delete sessions - " + err.Error())
+	}
+
+	return s.events.Record(userID, models.EventForcedLogout, gocql.UUID{}, ip,
+		fmt.Sprintf("logged out by admin %s", adminID))
+}
+
+// SetAdmin grants or revokes the admin role
+func (s *UserManagementService) SetAdmin(userID gocql.UUID, admin bool, adminID gocql.UUID) error {
+	if err := s.checkTarget(userID, adminID); err != nil {
+		return err
+	}
+	if admin {
+		return s.authorization.GrantRole(userID, models.RoleAdmin, adminID)
+	}
+	return s.authorization.RevokeRole(userID, models.RoleAdmin)
+}
+
+// admins cannot act on their own account or on accounts holding a higher role
+func (s *UserManagementService) checkTarget(userID, adminID gocql.UUID) error {
+	if userID == adminID {
+		return ErrOwnAccount
+	}
+
+	targetRank, err := s.authorization.GetRoleRank(userID)
+	if err != nil {
+		return errors.New("could not get roles - " + err.Error())
+	}
+	adminRank, err := s.authorization.GetRoleRank(adminID)
+	if err != nil {
+		return errors.New("could not get roles - " + err.Error())
+	}
+	if targetRank > adminRank {
+		return ErrHigherRole
+	}
+	return nil
+}
+
+// update the disabled flag of an existing account
+func (s *UserManagementService) setDisabled(userID gocql.UUID, disabled bool) error {
+	account, err := s.account_repo.GetAccountByID(userID)
+	if err != nil {
+		return errors.New("could not get account - " + err.Error())
+	}
+	if account == nil {
+		return ErrAccountNotFound
+	}
+
+	if err := s.account_repo.SetDisabled(userID, disabled); err != nil {
+		return errors.New("could not update account - " + err.Error())
+	}
+	return nil
+}
+
+// custom errors for user management
+var (
+	ErrOwnAccount = errors.New("cannot perform this action on your own account")
+	ErrHigherRole = errors.New("the account holds a higher role than yours")
+)
@@ -3,15 +3,104 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 // holds all application configuration parameters
 type Config struct {
 	ScyllaHost      string
-	JWTSecret       string
+	JWTSecret       string // legacy hs256 secret, only used to verify tokens issued before asymmetric signing (empty = rejected)
+	JWTLegacyUntil  string // rfc 3339 time after which legacy hs256 tokens are rejected (required with JWTSecret)
 	PepperSecret    string
 	AccessTokenTTL  int // token lifespan in minutes
 	RefreshTokenTTL int // token lifespan in days
+
+	PasswordHashTime    int // argon2id iterations for new password hashes
+	PasswordHashMemory  int // argon2id memory in KiB
+	PasswordHashThreads int // argon2id parallelism
+
+	PasswordMinLength     int    // minimum password length in characters
+	PasswordMaxLength     int    // maximum password length in characters (0 = unlimited)
+	PasswordRequireUpper  bool   // require an uppercase letter
+	PasswordRequireLower  bool   // require a lowercase letter
+	PasswordRequireDigit  bool   // require a digit
+	PasswordRequireSymbol bool   // require a character that is neither letter nor digit
+	PasswordMaxRepeated   int    // longest allowed run of the same character (0 = unlimited)
+	PasswordForbidEmail   bool   // reject passwords containing the email local part
+	PasswordBlocklistFile string // sorted sha1 hash file of common and breached passwords (empty = disabled)
+
+	JWTSigningKeyFile       string // pem file with the ed25519 or rsa private key used to sign new tokens
+	JWTVerificationKeyFiles string // comma separated pem files of rotated out keys that are still accepted
+	JWTGenerateSigningKey   bool   // create a new ed25519 signing key if the file does not exist
+
+	QRSigningKeyFile       string // pem file with the ed25519 private key used to sign qr payloads
+	QRVerificationKeyFiles string // comma separated pem files of rotated out qr keys whose codes are still accepted
+	QRGenerateSigningKey   bool   // create a new qr signing key if the file does not exist
+	QRAcceptUnsigned       bool   // still accept scans by plain qr code id (opt in while codes printed before signing are replaced)
+	QRBulkMaxCodes         int    // codes a single bulk creation may contain
+
+	RevocationCacheTTL int // seconds a denylist miss is cached per instance (delay until a revocation on another instance applies)
+
+	RevokeAllOnTokenReuse bool // revoke all sessions of a user (not only the device) when a rotated refresh token is replayed
+
+	LoginMaxAccountFailures int // failed logins per account inside the window before a lockout
+	LoginMaxIPFailures      int // failed logins per ip inside the window before a lockout
+	LoginFailureWindow      int // failure counting window in minutes
+	LoginLockoutBase        int // first lockout in minutes (doubles on every following lockout)
+	LoginLockoutMax         int // maximum lockout in minutes
+
+	TOTPIssuer            string // issuer name shown in authenticator apps
+	TwoFactorChallengeTTL int    // login challenge lifespan in minutes
+
+	PublicBaseURL        string // base url used for links in emails (e.g. https://example.com)
+	AppBaseURL           string // base url of the client app, links that need a form or the device id open its pages
+	EmailVerificationTTL int    // verification link lifespan in hours (also used for email change confirmations)
+	EmailChangeCancelTTL int    // days the previous address can undo an email change
+	PasswordResetTTL     int    // reset link lifespan in minutes
+
+	MagicLinkTTL         int // sign-in link lifespan in minutes
+	MagicLinkWindow      int // rate limit window for sign-in link requests in minutes
+	MagicLinkMaxPerEmail int // sign-in link requests per email inside the window
+	MagicLinkMaxPerIP    int // sign-in link requests per ip inside the window
+
+	AccountDeletionGrace int // days a deleted account can be restored before it is purged
+	AccountPurgeInterval int // minutes between runs of the account purger
+
+	GuestAccountsEnabled bool // allow anonymous device accounts (existing guests can always be claimed)
+	GuestWindow          int  // rate limit window for guest account creation in minutes
+	GuestMaxPerIP        int  // guest accounts per ip inside the window
+
+	OIDCProviders []OIDCProvider // external identity providers enabled for login
+	OIDCStateTTL  int            // minutes a started provider login may take
+
+	WebAuthnRPID        string // relying party id, the domain passkeys are bound to
+	WebAuthnRPName      string // relying party name shown by authenticators
+	WebAuthnRPOrigins   string // comma separated origins allowed to run ceremonies (empty = PublicBaseURL)
+	WebAuthnCeremonyTTL int    // minutes a started passkey ceremony may take
+
+	APIKeyRotationGrace int // minutes the old key stays valid after a rotation (time to roll out the new key)
+
+	DataExportWorkers  int // exports generated at the same time per instance
+	DataExportTTL      int // hours a finished export can be downloaded
+	DataExportSyncWait int // seconds a request waits for its export before the client has to poll
+	DataExportTimeout  int // minutes after which an unfinished export is reported as failed
+
+	MailDriver     string // "smtp" or "outbox"
+	MailFrom       string // sender address for outgoing mails
+	MailOutboxPath string // file the outbox driver appends mails to (empty = log only)
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+}
+
+// OIDCProvider configures one openid connect identity provider
+type OIDCProvider struct {
+	Name         string // used in urls (e.g. google)
+	Issuer       string // discovery is loaded from <issuer>/.well-known/openid-configuration
+	ClientID     string
+	ClientSecret string   // empty for public clients (pkce only)
+	Scopes       []string // requested in addition to openid
 }
 
 // load configuration from environment variables
@@ -19,9 +108,88 @@ func Load() *Config {
 	return &Config{
 		ScyllaHost:      getEnv("SCYLLA_HOST", "scylladb"),
 		JWTSecret:       getEnv("JWT_SECRET", ""),
+		JWTLegacyUntil:  getEnv("JWT_LEGACY_UNTIL", ""),
 		PepperSecret:    getEnv("PEPPER_SECRET", ""),
 		AccessTokenTTL:  getEnvAsInt("ACCESS_TOKEN_TTL", 15),
 		RefreshTokenTTL: getEnvAsInt("REFRESH_TOKEN_TTL", 365),
+
+		PasswordHashTime:    getEnvAsInt("PASSWORD_HASH_TIME", 3),
+		PasswordHashMemory:  getEnvAsInt("PASSWORD_HASH_MEMORY", 64*1024),
+		PasswordHashThreads: getEnvAsInt("PASSWORD_HASH_THREADS", 4),
+
+		PasswordMinLength:     getEnvAsInt("PASSWORD_MIN_LENGTH", 8),
+		PasswordMaxLength:     getEnvAsInt("PASSWORD_MAX_LENGTH", 128),
+		PasswordRequireUpper:  getEnvAsBool("PASSWORD_REQUIRE_UPPER", true),
+		PasswordRequireLower:  getEnvAsBool("PASSWORD_REQUIRE_LOWER", true),
+		PasswordRequireDigit:  getEnvAsBool("PASSWORD_REQUIRE_DIGIT", true),
+		PasswordRequireSymbol: getEnvAsBool("PASSWORD_REQUIRE_SYMBOL", false),
+		PasswordMaxRepeated:   getEnvAsInt("PASSWORD_MAX_REPEATED", 4),
+		PasswordForbidEmail:   getEnvAsBool("PASSWORD_FORBID_EMAIL", true),
+		PasswordBlocklistFile: getEnv("PASSWORD_BLOCKLIST_FILE", "blocklist/passwords.txt"),
+
+		JWTSigningKeyFile:       getEnv("JWT_SIGNING_KEY_FILE", "keys/jwt_signing.pem"),
+		JWTVerificationKeyFiles: getEnv("JWT_VERIFICATION_KEY_FILES", ""),
+		JWTGenerateSigningKey:   getEnvAsBool("JWT_GENERATE_SIGNING_KEY", false),
+
+		QRSigningKeyFile:       getEnv("QR_SIGNING_KEY_FILE", "keys/qr_signing.pem"),
+		QRVerificationKeyFiles: getEnv("QR_VERIFICATION_KEY_FILES", ""),
+		QRGenerateSigningKey:   getEnvAsBool("QR_GENERATE_SIGNING_KEY", false),
+		QRAcceptUnsigned:       getEnvAsBool("QR_ACCEPT_UNSIGNED", false),
+		QRBulkMaxCodes:         getEnvAsInt("QR_BULK_MAX_CODES", 1000),
+
+		RevocationCacheTTL: getEnvAsInt("REVOCATION_CACHE_TTL", 10),
+
+		RevokeAllOnTokenReuse: getEnvAsBool("REVOKE_ALL_ON_TOKEN_REUSE", false),
+
+		LoginMaxAccountFailures: getEnvAsInt("LOGIN_MAX_ACCOUNT_FAILURES", 5),
+		LoginMaxIPFailures:      getEnvAsInt("LOGIN_MAX_IP_FAILURES", 20),
+		LoginFailureWindow:      getEnvAsInt("LOGIN_FAILURE_WINDOW", 15),
+		LoginLockoutBase:        getEnvAsInt("LOGIN_LOCKOUT_BASE", 1),
+		LoginLockoutMax:         getEnvAsInt("LOGIN_LOCKOUT_MAX", 60),
+
+		TOTPIssuer:            getEnv("TOTP_ISSUER", "MPG Achievements"),
+		TwoFactorChallengeTTL: getEnvAsInt("TWO_FACTOR_CHALLENGE_TTL", 5),
+
+		PublicBaseURL:        getEnv("PUBLIC_BASE_URL", "http://localhost"),
+		AppBaseURL:           getEnv("APP_BASE_URL", getEnv("PUBLIC_BASE_URL", "http://localhost")),
+		EmailVerificationTTL: getEnvAsInt("EMAIL_VERIFICATION_TTL", 48),
+		EmailChangeCancelTTL: getEnvAsInt("EMAIL_CHANGE_CANCEL_TTL", 7),
+		PasswordResetTTL:     getEnvAsInt("PASSWORD_RESET_TTL", 30),
+
+		MagicLinkTTL:         getEnvAsInt("MAGIC_LINK_TTL", 15),
+		MagicLinkWindow:      getEnvAsInt("MAGIC_LINK_WINDOW", 60),
+		MagicLinkMaxPerEmail: getEnvAsInt("MAGIC_LINK_MAX_PER_EMAIL", 3),
+		MagicLinkMaxPerIP:    getEnvAsInt("MAGIC_LINK_MAX_PER_IP", 20),
+
+		AccountDeletionGrace: getEnvAsInt("ACCOUNT_DELETION_GRACE", 30),
+		AccountPurgeInterval: getEnvAsInt("ACCOUNT_PURGE_INTERVAL", 60),
+
+		GuestAccountsEnabled: getEnvAsBool("GUEST_ACCOUNTS_ENABLED", true),
+		GuestWindow:          getEnvAsInt("GUEST_WINDOW", 60),
+		GuestMaxPerIP:        getEnvAsInt("GUEST_MAX_PER_IP", 10),
+
+		OIDCProviders: loadOIDCProviders(),
+		OIDCStateTTL:  getEnvAsInt("OIDC_STATE_TTL", 10),
+
+		WebAuthnRPID:        getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:      getEnv("WEBAUTHN_RP_NAME", "MPG Achievements"),
+		WebAuthnRPOrigins:   getEnv("WEBAUTHN_RP_ORIGINS", ""),
+		WebAuthnCeremonyTTL: getEnvAsInt("WEBAUTHN_CEREMONY_TTL", 5),
+
+		APIKeyRotationGrace: getEnvAsInt("API_KEY_ROTATION_GRACE", 1440),
+
+		DataExportWorkers:  getEnvAsInt("DATA_EXPORT_WORKERS", 2),
+		DataExportTTL:      getEnvAsInt("DATA_EXPORT_TTL", 24),
+		DataExportSyncWait: getEnvAsInt("DATA_EXPORT_SYNC_WAIT", 3),
+		DataExportTimeout:  getEnvAsInt("DATA_EXPORT_TIMEOUT", 15),
+
+		MailDriver:     getEnv("MAIL_DRIVER", "outbox"),
+		MailFrom:       getEnv("MAIL_FROM", "noreply@localhost"),
+		MailOutboxPath: getEnv("MAIL_OUTBOX_PATH", ""),
+		SMTPHost:       getEnv("SMTP_HOST", ""),
+		SMTPPort:       getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername:   getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:   getEnv("SMTP_PASSWORD", ""),
 	}
 }
 
@@ -42,3 +210,36 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// get boolean environment variables
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+// load providers listed in OIDC_PROVIDERS (comma separated names) from OIDC_<NAME>_* variables
+func loadOIDCProviders() []OIDCProvider {
+	var providers []OIDCProvider
+
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProvider{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "email profile")),
+		})
+	}
+
+	return providers
+}
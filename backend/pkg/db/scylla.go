@@ -68,11 +68,34 @@ func RunMigrations(session *gocql.Session, logger *log.Logger) error {
 			email TEXT,
 			password_hash TEXT,
 			created_at TIMESTAMP,
-			admin BOOLEAN,
+			admin BOOLEAN, -- legacy flag, migrated to auth.account_roles
+			verified BOOLEAN,
+			disabled BOOLEAN,
+			pending_email TEXT, -- requested new address until it is confirmed
+			deletion_requested_at TIMESTAMP, -- set while the account waits for the purge
+			guest BOOLEAN, -- anonymous device account without email until it is claimed
 		)`,
 
 		`CREATE INDEX IF NOT EXISTS idx_email ON auth.accounts(email);`,
 
+		// accounts waiting for the purge after their deletion grace period (small, scanned by the purger)
+		`CREATE TABLE IF NOT EXISTS auth.pending_deletions (
+			user_id UUID PRIMARY KEY,
+			requested_at TIMESTAMP
+		)`,
+
+		// one row per email in use, claimed with a lightweight transaction to keep emails unique
+		`CREATE TABLE IF NOT EXISTS auth.account_emails (
+			email TEXT PRIMARY KEY,
+			user_id UUID
+		)`,
+
+		// one-time data migrations that already ran
+		`CREATE TABLE IF NOT EXISTS auth.migrations (
+			name TEXT PRIMARY KEY,
+			applied_at TIMESTAMP
+		)`,
+
 		// permanent sessions table
 		`CREATE TABLE IF NOT EXISTS auth.permanent_sessions (
 			user_id UUID,
@@ -81,9 +104,207 @@ func RunMigrations(session *gocql.Session, logger *log.Logger) error {
 			created_at TIMESTAMP,
 			last_used TIMESTAMP,
 			expires_at TIMESTAMP,
+			family_id UUID,
+			device_name TEXT,
+			ip TEXT,
+			user_agent TEXT,
 			PRIMARY KEY (user_id, device_id)
 		)`,
 
+		// refresh tokens that were already rotated, used to detect replays (rows expire through ttl)
+		`CREATE TABLE IF NOT EXISTS auth.rotated_refresh_tokens (
+			token_id TEXT PRIMARY KEY,
+			user_id UUID,
+			device_id UUID,
+			family_id UUID,
+			rotated_at TIMESTAMP
+		)`,
+
+		// security relevant events per user (newest first)
+		`CREATE TABLE IF NOT EXISTS auth.security_events (
+			user_id UUID,
+			event_id TIMEUUID,
+			event_type TEXT,
+			device_id UUID,
+			ip TEXT,
+			details TEXT,
+			PRIMARY KEY (user_id, event_id)
+		) WITH CLUSTERING ORDER BY (event_id DESC)`,
+
+		// password reset tokens table (rows expire through ttl)
+		`CREATE TABLE IF NOT EXISTS auth.password_reset_tokens (
+			token_hash TEXT PRIMARY KEY,
+			user_id UUID,
+			created_at TIMESTAMP
+		)`,
+
+		// passwordless sign-in link tokens (rows expire through ttl)
+		`CREATE TABLE IF NOT EXISTS auth.magic_link_tokens (
+			token_hash TEXT PRIMARY KEY,
+			user_id UUID,
+			email TEXT,
+			device_id UUID,
+			device_name TEXT,
+			created_at TIMESTAMP
+		)`,
+
+		// sign-in link requests per email and ip, counted for rate limiting until the ttl expires
+		`CREATE TABLE IF NOT EXISTS auth.magic_link_requests (
+			scope TEXT,
+			key TEXT,
+			request_id TIMEUUID,
+			PRIMARY KEY ((scope, key), request_id)
+		) WITH CLUSTERING ORDER BY (request_id ASC)`,
+
+		// totp two factor secrets (secret is encrypted with the pepper)
+		`CREATE TABLE IF NOT EXISTS auth.totp_secrets (
+			user_id UUID PRIMARY KEY,
+			secret TEXT,
+			confirmed BOOLEAN,
+			last_used_step BIGINT,
+			created_at TIMESTAMP
+		)`,
+
+		// one time two factor recovery codes (argon2 hashed)
+		`CREATE TABLE IF NOT EXISTS auth.recovery_codes (
+			user_id UUID,
+			code_id UUID,
+			code_hash TEXT,
+			PRIMARY KEY (user_id, code_id)
+		)`,
+
+		// failed login attempts per account or ip (rows expire after the counting window)
+		`CREATE TABLE IF NOT EXISTS auth.login_failures (
+			scope TEXT,
+			key TEXT,
+			attempt_id TIMEUUID,
+			PRIMARY KEY ((scope, key), attempt_id)
+		)`,
+
+		// active login lockouts, the level is kept after expiry to grow the next lockout
+		`CREATE TABLE IF NOT EXISTS auth.login_lockouts (
+			scope TEXT,
+			key TEXT,
+			locked_until TIMESTAMP,
+			level INT,
+			PRIMARY KEY ((scope, key))
+		)`,
+
+		// role assignments per account (replaces the legacy accounts.admin flag)
+		`CREATE TABLE IF NOT EXISTS auth.account_roles (
+			user_id UUID,
+			role TEXT,
+			granted_at TIMESTAMP,
+			granted_by UUID,
+			PRIMARY KEY (user_id, role)
+		)`,
+
+		// denylist of revoked access tokens (rows expire together with the token)
+		`CREATE TABLE IF NOT EXISTS auth.revoked_tokens (
+			token_id TEXT PRIMARY KEY,
+			user_id UUID,
+			revoked_at TIMESTAMP,
+		)`,
+
+		// started openid connect logins (consumed by the callback)
+		`CREATE TABLE IF NOT EXISTS auth.oidc_states (
+			state TEXT PRIMARY KEY,
+			provider TEXT,
+			code_verifier TEXT,
+			nonce TEXT,
+			device_id UUID,
+			device_name TEXT,
+			created_at TIMESTAMP,
+		)`,
+
+		// identity provider accounts linked to local accounts
+		`CREATE TABLE IF NOT EXISTS auth.oidc_identities (
+			provider TEXT,
+			subject TEXT,
+			user_id UUID,
+			email TEXT,
+			linked_at TIMESTAMP,
+			PRIMARY KEY ((provider, subject))
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_oidc_identities_user ON auth.oidc_identities(user_id);`,
+
+		// webauthn credentials (passkeys) per account
+		`CREATE TABLE IF NOT EXISTS auth.passkey_credentials (
+			user_id UUID,
+			credential_id BLOB,
+			public_key BLOB,
+			attestation_type TEXT,
+			transports LIST<TEXT>,
+			aaguid BLOB,
+			sign_count BIGINT,
+			backup_eligible BOOLEAN,
+			backup_state BOOLEAN,
+			clone_warning BOOLEAN,
+			name TEXT,
+			created_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			PRIMARY KEY (user_id, credential_id)
+		)`,
+
+		// started webauthn ceremonies (consumed by the finish request)
+		`CREATE TABLE IF NOT EXISTS auth.webauthn_ceremonies (
+			id TEXT PRIMARY KEY,
+			kind TEXT,
+			user_id UUID,
+			email TEXT,
+			device_id UUID,
+			device_name TEXT,
+			session_data TEXT,
+			created_at TIMESTAMP,
+		)`,
+
+		// non human principals authenticating with api keys
+		`CREATE TABLE IF NOT EXISTS auth.service_accounts (
+			id UUID PRIMARY KEY,
+			name TEXT,
+			description TEXT,
+			created_by UUID,
+			created_at TIMESTAMP,
+		)`,
+
+		// api keys by their public id (the secret part is only stored as hmac)
+		`CREATE TABLE IF NOT EXISTS auth.api_keys (
+			id TEXT PRIMARY KEY,
+			service_account_id UUID,
+			name TEXT,
+			secret_hash TEXT,
+			scopes SET<TEXT>,
+			created_by UUID,
+			created_at TIMESTAMP,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP,
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_api_keys_service_account ON auth.api_keys(service_account_id);`,
+
+		// personal data exports per user, newest first (rows expire through ttl)
+		`CREATE TABLE IF NOT EXISTS auth.data_exports (
+			user_id UUID,
+			id TIMEUUID,
+			format TEXT,
+			status TEXT,
+			error TEXT,
+			size BIGINT,
+			created_at TIMESTAMP,
+			completed_at TIMESTAMP,
+			expires_at TIMESTAMP,
+			PRIMARY KEY (user_id, id)
+		) WITH CLUSTERING ORDER BY (id DESC)`,
+
+		// generated export files split into chunks to stay below the mutation size limit
+		`CREATE TABLE IF NOT EXISTS auth.data_export_chunks (
+			user_id UUID,
+			export_id TIMEUUID,
+			chunk INT,
+			data BLOB,
+			PRIMARY KEY ((user_id, export_id), chunk)
+		)`,
+
 		// qr actions table
 		`CREATE TABLE IF NOT EXISTS qr.qr_actions (
 			id UUID PRIMARY KEY,
@@ -97,6 +318,13 @@ func RunMigrations(session *gocql.Session, logger *log.Logger) error {
 			count INT,
 			PRIMARY KEY (user_id, qr_code_id)
 		)`,
+		`CREATE INDEX IF NOT EXISTS idx_user_qr_scans_code ON qr.user_qr_scans(qr_code_id);`,
+
+		// claimed uses of global qr codes, reserved with compare-and-set so concurrent scans cannot overshoot
+		`CREATE TABLE IF NOT EXISTS qr.qr_code_usages (
+			qr_code_id UUID PRIMARY KEY,
+			used INT
+		)`,
 
 		// qr codes table
 		`CREATE TABLE IF NOT EXISTS qr.qr_codes (
@@ -106,6 +334,20 @@ func RunMigrations(session *gocql.Session, logger *log.Logger) error {
 			max_usages INT,
 			expires_at TIMESTAMP
 		)`,
+		`CREATE INDEX IF NOT EXISTS idx_qr_codes_action ON qr.qr_codes(action_id);`,
+
+		// bulk code creations by client supplied batch id (retries return the same codes)
+		`CREATE TABLE IF NOT EXISTS qr.qr_code_batches (
+			id UUID PRIMARY KEY,
+			action_id UUID,
+			qr_code_type INT,
+			max_usages INT,
+			expire_mins INT,
+			expires_at TIMESTAMP,
+			code_ids LIST<UUID>,
+			complete BOOLEAN,
+			created_at TIMESTAMP
+		)`,
 	}
 
 	// execute all table creation queries
@@ -115,6 +357,198 @@ func RunMigrations(session *gocql.Session, logger *log.Logger) error {
 		}
 	}
 
+	// add columns introduced after the initial schema to existing tables
+	sessionColumns := [][2]string{
+		{"family_id", "UUID"},
+		{"device_name", "TEXT"},
+		{"ip", "TEXT"},
+		{"user_agent", "TEXT"},
+	}
+	for _, column := range sessionColumns {
+		if _, err := addColumn(session, "auth", "permanent_sessions", column[0], column[1]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := addColumn(session, "auth", "accounts", "disabled", "BOOLEAN"); err != nil {
+		return err
+	}
+
+	if _, err := addColumn(session, "auth", "accounts", "pending_email", "TEXT"); err != nil {
+		return err
+	}
+
+	if _, err := addColumn(session, "auth", "accounts", "deletion_requested_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+
+	if _, err := addColumn(session, "auth", "accounts", "guest", "BOOLEAN"); err != nil {
+		return err
+	}
+	// guests whose scans were merged into the row (a repeated merge is skipped)
+	if _, err := addColumn(session, "qr", "user_qr_scans", "merged_guests", "SET<UUID>"); err != nil {
+		return err
+	}
+
+	added, err := addColumn(session, "auth", "accounts", "verified", "BOOLEAN")
+	if err != nil {
+		return err
+	}
+	if added {
+		// accounts created before email verification existed are treated as verified
+		if err := backfillVerified(session); err != nil {
+			return fmt.Errorf("verified backfill failed: %w", err)
+		}
+		logger.Println("marked existing accounts as verified")
+	}
+
+	// convert legacy admin flags into role assignments
+	migrated, err := migrateAdminFlags(session)
+	if err != nil {
+		return fmt.Errorf("admin flag migration failed: %w", err)
+	}
+	if migrated > 0 {
+		logger.Printf("migrated %d admin accounts to the superadmin role", migrated)
+	}
+
+	// claim the emails of accounts created before the email table existed (once, it scans every account)
+	applied, err := migrationApplied(session, "email_claims_backfill")
+	if err != nil {
+		return err
+	}
+	if !applied {
+		claimed, err := backfillEmailClaims(session)
+		if err != nil {
+			return fmt.Errorf("email claim backfill failed: %w", err)
+		}
+		if err := markMigrationApplied(session, "email_claims_backfill"); err != nil {
+			return err
+		}
+		logger.Printf("claimed %d existing account emails", claimed)
+	}
+
 	logger.Println("database schema initialized")
 	return nil
 }
+
+// add a column to an existing table if it is missing, returns true if the column was added
+func addColumn(session *gocql.Session, keyspace, table, column, columnType string) (bool, error) {
+	var name string
+	err := session.Query(`SELECT column_name FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ? AND column_name = ?`,
+		keyspace, table, column,
+	).Scan(&name)
+
+	if err == nil {
+		return false, nil // column already exists
+	} else if err != gocql.ErrNotFound {
+		return false, fmt.Errorf("column lookup %s.%s.%s failed: %w", keyspace, table, column, err)
+	}
+
+	query := fmt.Sprintf("ALTER TABLE %s.%s ADD %s %s", keyspace, table, column, columnType)
+	if err := session.Query(query).Exec(); err != nil {
+		return false, fmt.Errorf("adding column %s.%s.%s failed: %w", keyspace, table, column, err)
+	}
+	return true, nil
+}
+
+// check the marker of a one-time data migration
+func migrationApplied(session *gocql.Session, name string) (bool, error) {
+	var appliedAt time.Time
+	err := session.Query(`SELECT applied_at FROM auth.migrations WHERE name = ?`, name).Scan(&appliedAt)
+	if err == gocql.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("migration lookup %s failed: %w", name, err)
+	}
+	return true, nil
+}
+
+// record a finished one-time data migration, a failed run is retried on the next start
+func markMigrationApplied(session *gocql.Session, name string) error {
+	if err := session.Query(`INSERT INTO auth.migrations (name, applied_at) VALUES (?, ?)`, name, time.Now().UTC()).Exec(); err != nil {
+		return fmt.Errorf("marking migration %s failed: %w", name, err)
+	}
+	return nil
+}
+
+// grant the superadmin role to every account with the legacy admin flag and clear the flag
+// (admins could do everything before roles existed, so they keep full access)
+func migrateAdminFlags(session *gocql.Session) (int, error) {
+	iter := session.Query(`SELECT id, admin FROM auth.accounts`).Iter()
+
+	var id gocql.UUID
+	var admin bool
+	migrated := 0
+
+	for iter.Scan(&id, &admin) {
+		if !admin {
+			continue
+		}
+		if err := session.Query(`INSERT INTO auth.account_roles (user_id, role, granted_at) VALUES (?, 'superadmin', ?)`, id, time.Now().UTC()).Exec(); err != nil {
+			iter.Close()
+			return migrated, err
+		}
+		if err := session.Query(`DELETE admin FROM auth.accounts WHERE id = ?`, id).Exec(); err != nil {
+			iter.Close()
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, iter.Close()
+}
+
+// set verified = true on all accounts that have no value yet
+func backfillVerified(session *gocql.Session) error {
+	iter := session.Query(`SELECT id, verified FROM auth.accounts`).Iter()
+
+	var id gocql.UUID
+	var verified *bool
+
+	for iter.Scan(&id, &verified) {
+		if verified != nil {
+			continue
+		}
+		if err := session.Query(`UPDATE auth.accounts SET verified = true WHERE id = ?`, id).Exec(); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+
+	return iter.Close()
+}
+
+// insert the missing auth.account_emails rows, an email already claimed by another account is kept
+func backfillEmailClaims(session *gocql.Session) (int, error) {
+	iter := session.Query(`SELECT id, email FROM auth.accounts`).Iter()
+
+	var id, owner gocql.UUID
+	var email string
+	claimed := 0
+
+	for iter.Scan(&id, &email) {
+		if email == "" {
+			continue
+		}
+
+		err := session.Query(`SELECT user_id FROM auth.account_emails WHERE email = ?`, email).Scan(&owner)
+		if err == nil {
+			continue // already claimed
+		} else if err != gocql.ErrNotFound {
+			iter.Close()
+			return claimed, err
+		}
+
+		applied, err := session.Query(`INSERT INTO auth.account_emails (email, user_id) VALUES (?, ?) IF NOT EXISTS`, email, id).
+			MapScanCAS(make(map[string]interface{}))
+		if err != nil {
+			iter.Close()
+			return claimed, err
+		}
+		if applied {
+			claimed++
+		}
+	}
+
+	return claimed, iter.Close()
+}
@@ -0,0 +1,28 @@
+package mail
+
+import (
+	"backend/pkg/config"
+
+	"fmt"
+	"log"
+)
+
+// Mailer delivers plain text emails
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer creates the mailer selected by MAIL_DRIVER
+func NewMailer(cfg *config.Config, logger *log.Logger) (Mailer, error) {
+	switch cfg.MailDriver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("smtp mail driver requires SMTP_HOST")
+		}
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom), nil
+	case "outbox", "":
+		return NewOutboxMailer(cfg.MailOutboxPath, cfg.MailFrom, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.MailDriver)
+	}
+}
@@ -0,0 +1,48 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// OutboxMailer writes emails to the log and optionally to a file instead of sending them (local development)
+type OutboxMailer struct {
+	path   string
+	from   string
+	logger *log.Logger
+	mu     sync.Mutex // serialize file appends
+}
+
+// NewOutboxMailer creates a new outbox mailer (empty path = log only)
+func NewOutboxMailer(path, from string, logger *log.Logger) *OutboxMailer {
+	return &OutboxMailer{
+		path:   path,
+		from:   from,
+		logger: logger,
+	}
+}
+
+// Send records the email in the outbox
+func (m *OutboxMailer) Send(to, subject, body string) error {
+	m.logger.Printf("outbox mail to %s: %s\n%s", to, subject, body)
+
+	if m.path == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("outbox open failed: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(buildMessage(m.from, to, subject, body), []byte("\r\n\r\n")...)); err != nil {
+		return fmt.Errorf("outbox write failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends emails through an smtp relay
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a new smtp mailer (auth is skipped when no username is set)
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr: host + ":" + strconv.Itoa(port),
+		auth: auth,
+		from: from,
+	}
+}
+
+// Send delivers a single plain text email (starttls is used automatically if offered)
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, buildMessage(m.from, to, subject, body)); err != nil {
+		return fmt.Errorf("smtp send failed: %w", err)
+	}
+	return nil
+}
+
+// build a rfc 5322 message with minimal headers
+func buildMessage(from, to, subject, body string) []byte {
+	var sb strings.Builder
+	sb.WriteString("From: " + headerValue(from) + "\r\n")
+	sb.WriteString("To: " + headerValue(to) + "\r\n")
+	sb.WriteString("Subject: " + headerValue(subject) + "\r\n")
+	sb.WriteString("Date: " + time.Now().UTC().Format(time.RFC1123Z) + "\r\n")
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	sb.WriteString("\r\n")
+	sb.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(sb.String())
+}
+
+// strip line breaks from header values to prevent header injection
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
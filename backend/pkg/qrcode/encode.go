@@ -0,0 +1,501 @@
+// Package qrcode encodes qr symbols (iso 18004, byte mode) and renders them as png, svg or pdf print sheets
+package qrcode
+
+import (
+	"errors"
+	"strings"
+)
+
+// Level is the error correction level, higher levels survive more damage (or a bigger logo) but need more modules
+type Level int
+
+const (
+	LevelL Level = iota // ~7% of the codewords can be restored
+	LevelM              // ~15%
+	LevelQ              // ~25%
+	LevelH              // ~30%
+)
+
+// ParseLevel parses L, M, Q or H (case insensitive)
+func ParseLevel(level string) (Level, error) {
+	switch strings.ToUpper(level) {
+	case "L":
+		return LevelL, nil
+	case "M":
+		return LevelM, nil
+	case "Q":
+		return LevelQ, nil
+	case "H":
+		return LevelH, nil
+	}
+	return 0, errors.New("invalid error correction level, use L, M, Q or H")
+}
+
+func (l Level) String() string {
+	return [...]string{"L", "M", "Q", "H"}[l]
+}
+
+// Code is an encoded qr symbol without quiet zone
+type Code struct {
+	Version int   // 1 to 40
+	Level   Level // error correction level
+	Size    int   // modules per side (4 * version + 17)
+
+	modules    [][]bool // [y][x], true = dark
+	isFunction [][]bool // finder, timing, alignment, format and version modules (not masked)
+}
+
+// Dark reports whether the module at x, y is dark
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+// Encode creates the smallest symbol holding data in byte mode at the given level
+func Encode(data []byte, level Level) (*Code, error) {
+	if level < LevelL || level > LevelH {
+		return nil, errors.New("invalid error correction level")
+	}
+
+	version := 0
+	for v := 1; v <= 40; v++ {
+		if 4+charCountBits(v)+8*len(data) <= numDataCodewords(v, level)*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrDataTooLong
+	}
+
+	// mode indicator, character count, data, terminator and pad bytes
+	capacity := numDataCodewords(version, level)
+	var bits bitBuffer
+	bits.append(0x4, 4)
+	bits.append(len(data), charCountBits(version))
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	bits.append(0, min(4, capacity*8-len(bits)))
+	bits.append(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity*8; pad ^= 0xEC ^ 0x11 {
+		bits.append(pad, 8)
+	}
+
+	codewords := make([]byte, capacity)
+	for i, bit := range bits {
+		if bit {
+			codewords[i>>3] |= 1 << (7 - i&7)
+		}
+	}
+
+	c := &Code{Version: version, Level: level, Size: version*4 + 17}
+	c.modules = newGrid(c.Size)
+	c.isFunction = newGrid(c.Size)
+
+	c.drawFunctionPatterns()
+	c.drawCodewords(c.addErrorCorrection(codewords))
+
+	// the mask with the lowest penalty is kept, masks are their own inverse
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormatBits(mask)
+		if penalty := c.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		c.applyMask(mask)
+	}
+	c.applyMask(best)
+	c.drawFormatBits(best)
+
+	c.isFunction = nil
+	return c, nil
+}
+
+type bitBuffer []bool
+
+func (b *bitBuffer) append(value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		*b = append(*b, (value>>i)&1 != 0)
+	}
+}
+
+func newGrid(size int) [][]bool {
+	grid := make([][]bool, size)
+	for i := range grid {
+		grid[i] = make([]bool, size)
+	}
+	return grid
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.isFunction[y][x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	// timing patterns
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	// finder patterns with separators
+	for _, center := range [][2]int{{3, 3}, {c.Size - 4, 3}, {3, c.Size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := center[0]+dx, center[1]+dy
+				if x >= 0 && x < c.Size && y >= 0 && y < c.Size {
+					distance := max(abs(dx), abs(dy))
+					c.setFunction(x, y, distance != 2 && distance != 4)
+				}
+			}
+		}
+	}
+
+	// alignment patterns, except where they would overlap a finder
+	positions := alignmentPositions(c.Version)
+	last := len(positions) - 1
+	for i, y := range positions {
+		for j, x := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	// reserve the format area, the real bits are drawn after masking
+	c.drawFormatBits(0)
+
+	if c.Version >= 7 {
+		remainder := c.Version
+		for i := 0; i < 12; i++ {
+			remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+		}
+		bits := c.Version<<12 | remainder
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 != 0
+			a, b := c.Size-11+i%3, i/3
+			c.setFunction(a, b, dark)
+			c.setFunction(b, a, dark)
+		}
+	}
+}
+
+func (c *Code) drawFormatBits(mask int) {
+	// bch(15, 5) code of level and mask
+	data := [...]int{1, 0, 3, 2}[c.Level]<<3 | mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	// copy around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	// copy split between the other two finders
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true) // always dark
+}
+
+// zigzag through the data area in two module wide columns from the bottom right
+func (c *Code) drawCodewords(data []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vertical := 0; vertical < c.Size; vertical++ {
+			y := vertical
+			if upward {
+				y = c.Size - 1 - vertical
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if !c.isFunction[y][x] && i < len(data)*8 {
+					c.modules[y][x] = (data[i>>3]>>(7-i&7))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !c.isFunction[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// splits the data into blocks, appends their reed-solomon codewords and interleaves them
+func (c *Code) addErrorCorrection(data []byte) []byte {
+	numBlocks := numErrorCorrectionBlocks[c.Level][c.Version]
+	eccLength := eccCodewordsPerBlock[c.Level][c.Version]
+	rawCodewords := numRawDataModules(c.Version) / 8
+	numShortBlocks := numBlocks - rawCodewords%numBlocks
+	shortBlockLength := rawCodewords / numBlocks
+
+	divisor := reedSolomonDivisor(eccLength)
+	blocks := make([][]byte, numBlocks)
+	for i, offset := 0, 0; i < numBlocks; i++ {
+		dataLength := shortBlockLength - eccLength
+		if i >= numShortBlocks {
+			dataLength++
+		}
+		block := append([]byte{}, data[offset:offset+dataLength]...)
+		offset += dataLength
+		ecc := reedSolomonRemainder(block, divisor)
+		if i < numShortBlocks {
+			block = append(block, 0) // placeholder, short blocks lack the last data codeword
+		}
+		blocks[i] = append(block, ecc...)
+	}
+
+	result := make([]byte, 0, rawCodewords)
+	for i := range blocks[0] {
+		for j, block := range blocks {
+			if i != shortBlockLength-eccLength || j >= numShortBlocks {
+				result = append(result, block[i])
+			}
+		}
+	}
+	return result
+}
+
+// generator polynomial of the given degree, highest coefficient (always 1) left out
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// multiplication in gf(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// penalty score of the current modules (lower is easier to scan)
+func (c *Code) penalty() int {
+	const (
+		penaltyRun    = 3
+		penaltyBlock  = 3
+		penaltyFinder = 40
+		penaltyRatio  = 10
+	)
+	result := 0
+
+	for _, vertical := range []bool{false, true} {
+		for a := 0; a < c.Size; a++ {
+			runColor := false
+			runLength := 0
+			var history [7]int
+			for b := 0; b < c.Size; b++ {
+				dark := c.modules[a][b]
+				if vertical {
+					dark = c.modules[b][a]
+				}
+				if dark == runColor {
+					runLength++
+					if runLength == 5 {
+						result += penaltyRun
+					} else if runLength > 5 {
+						result++
+					}
+				} else {
+					c.addRunHistory(runLength, &history)
+					if !runColor {
+						result += finderLikePatterns(history) * penaltyFinder
+					}
+					runColor = dark
+					runLength = 1
+				}
+			}
+
+			// the quiet zone counts as a light run after the last module
+			if runColor {
+				c.addRunHistory(runLength, &history)
+				runLength = 0
+			}
+			c.addRunHistory(runLength+c.Size, &history)
+			result += finderLikePatterns(history) * penaltyFinder
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size {
+				color := c.modules[y][x]
+				if color == c.modules[y][x+1] && color == c.modules[y+1][x] && color == c.modules[y+1][x+1] {
+					result += penaltyBlock
+				}
+			}
+		}
+	}
+
+	// every 5% the dark share differs from 50%
+	total := c.Size * c.Size
+	result += ((abs(dark*20-total*10)+total-1)/total - 1) * penaltyRatio
+
+	return result
+}
+
+func (c *Code) addRunHistory(runLength int, history *[7]int) {
+	if history[0] == 0 {
+		runLength += c.Size // the quiet zone counts as a light run before the first module
+	}
+	copy(history[1:], history[:6])
+	history[0] = runLength
+}
+
+// number of 1:1:3:1:1 patterns with 4 light modules on one side in the run history
+func finderLikePatterns(history [7]int) int {
+	n := history[1]
+	core := n > 0 && history[2] == n && history[3] == n*3 && history[4] == n && history[5] == n
+	count := 0
+	if core && history[0] >= n*4 && history[6] >= n {
+		count++
+	}
+	if core && history[6] >= n*4 && history[0] >= n {
+		count++
+	}
+	return count
+}
+
+func charCountBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+	count := version/7 + 2
+	step := (version*8 + count*3 + 5) / (count*4 - 4) * 2
+	result := make([]int, count)
+	result[0] = 6
+	for i, position := count-1, version*4+17-7; i >= 1; i, position = i-1, position-step {
+		result[i] = position
+	}
+	return result
+}
+
+// modules available for data and error correction after all function patterns
+func numRawDataModules(version int) int {
+	result := (16*version+128)*version + 64
+	if version >= 2 {
+		count := version/7 + 2
+		result -= (25*count-10)*count - 55
+		if version >= 7 {
+			result -= 36
+		}
+	}
+	return result
+}
+
+func numDataCodewords(version int, level Level) int {
+	return numRawDataModules(version)/8 - eccCodewordsPerBlock[level][version]*numErrorCorrectionBlocks[level][version]
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// error correction tables by level and version (index 0 unused)
+var eccCodewordsPerBlock = [4][41]int{
+	{-1, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	{-1, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	{-1, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var numErrorCorrectionBlocks = [4][41]int{
+	{-1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	{-1, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	{-1, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	{-1, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// custom errors for qr encoding
+var ErrDataTooLong = errors.New("data too long for a qr code at this error correction level")
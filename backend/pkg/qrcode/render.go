@@ -0,0 +1,280 @@
+package qrcode
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // logo formats
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Style controls how a code is drawn
+type Style struct {
+	Size       int         // width of the code including the quiet zone in pixels
+	QuietZone  int         // light border in modules (4 by the standard)
+	Foreground color.NRGBA // dark modules and caption
+	Background color.NRGBA
+	Logo       image.Image // optional, drawn centered on a background plate
+	Caption    string      // optional line below the code (ascii, other characters are drawn as ?)
+}
+
+// MaxLogoPixels limits the dimensions of decoded logos (decompression bombs)
+const MaxLogoPixels = 4096 * 4096
+
+// DecodeLogo reads a png, jpeg or gif logo
+func DecodeLogo(r io.Reader) (image.Image, error) {
+	var buffer bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(r, &buffer))
+	if err != nil {
+		return nil, ErrInvalidLogo
+	}
+	if config.Width*config.Height > MaxLogoPixels {
+		return nil, errors.New("logo dimensions too large")
+	}
+
+	logo, _, err := image.Decode(io.MultiReader(&buffer, r))
+	if err != nil {
+		return nil, ErrInvalidLogo
+	}
+	return logo, nil
+}
+
+// ParseColor parses a hex color (rrggbb or rrggbbaa, leading # optional)
+func ParseColor(hex string) (color.NRGBA, error) {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 && len(hex) != 8 {
+		return color.NRGBA{}, errors.New("invalid color " + hex + ", use rrggbb or rrggbbaa")
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, errors.New("invalid color " + hex + ", use rrggbb or rrggbbaa")
+	}
+	return color.NRGBA{R: uint8(value >> 24), G: uint8(value >> 16), B: uint8(value >> 8), A: uint8(value)}, nil
+}
+
+// PNG draws the code as png image
+func (c *Code) PNG(style Style) ([]byte, error) {
+	l, err := c.layout(style)
+	if err != nil {
+		return nil, err
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, l.width, l.height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(style.Background), image.Point{}, draw.Src)
+	foreground := image.NewUniform(style.Foreground)
+	for _, r := range l.rects {
+		draw.Draw(img, r, foreground, image.Point{}, draw.Over)
+	}
+	if l.logo != nil {
+		draw.Draw(img, l.logoRect, l.logo, image.Point{}, draw.Over)
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, errors.New("png encoding failed - " + err.Error())
+	}
+	return buffer.Bytes(), nil
+}
+
+// SVG draws the code as svg image with the same geometry as the png, the logo is embedded as png
+func (c *Code) SVG(style Style) ([]byte, error) {
+	l, err := c.layout(style)
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, l.width, l.height, l.width, l.height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" %s/>`, l.width, l.height, svgFill(style.Background))
+	fmt.Fprintf(&b, `<path %s d="`, svgFill(style.Foreground))
+	for _, r := range l.rects {
+		fmt.Fprintf(&b, "M%d %dh%dv%dh-%dz", r.Min.X, r.Min.Y, r.Dx(), r.Dy(), r.Dx())
+	}
+	b.WriteString(`"/>`)
+
+	if l.logo != nil {
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, l.logo); err != nil {
+			return nil, errors.New("logo encoding failed - " + err.Error())
+		}
+		fmt.Fprintf(&b, `<image x="%d" y="%d" width="%d" height="%d" href="data:image/png;base64,%s"/>`,
+			l.logoRect.Min.X, l.logoRect.Min.Y, l.logoRect.Dx(), l.logoRect.Dy(), base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+
+	// the caption is kept as text for search and accessibility, it is drawn by the path
+	if style.Caption != "" {
+		b.WriteString("<title>")
+		xml.EscapeText(&b, []byte(style.Caption))
+		b.WriteString("</title>")
+	}
+	b.WriteString("</svg>")
+
+	return []byte(b.String()), nil
+}
+
+func svgFill(c color.NRGBA) string {
+	fill := fmt.Sprintf(`fill="#%02x%02x%02x"`, c.R, c.G, c.B)
+	if c.A != 0xff {
+		fill += fmt.Sprintf(` fill-opacity="%.3f"`, float64(c.A)/0xff)
+	}
+	return fill
+}
+
+// pixel geometry shared by png and svg
+type layout struct {
+	width, height int
+	rects         []image.Rectangle // foreground areas (modules and caption)
+	logo          image.Image       // logo scaled to logoRect
+	logoRect      image.Rectangle
+}
+
+func (c *Code) layout(style Style) (*layout, error) {
+	if style.QuietZone < 0 {
+		return nil, errors.New("quiet zone must not be negative")
+	}
+
+	// whole pixels per module keep the edges sharp, the rest widens the quiet zone
+	modules := c.Size + 2*style.QuietZone
+	scale := style.Size / modules
+	if scale < 1 {
+		return nil, fmt.Errorf("size too small for this code, at least %d pixels needed", modules)
+	}
+	offset := (style.Size - scale*c.Size) / 2
+
+	l := &layout{width: style.Size, height: style.Size}
+
+	// modules under the logo plate are left out, error correction restores them
+	plateStart, plateEnd := c.Size, c.Size
+	if style.Logo != nil {
+		plate := c.Size / 5
+		if plate%2 == 0 {
+			plate++ // the size is odd, the plate has to be odd to be centered
+		}
+		plateStart = (c.Size - plate) / 2
+		plateEnd = plateStart + plate
+	}
+	onPlate := func(x, y int) bool {
+		return x >= plateStart && x < plateEnd && y >= plateStart && y < plateEnd
+	}
+
+	// one rectangle per horizontal run of dark modules
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.modules[y][x] || onPlate(x, y) {
+				continue
+			}
+			start := x
+			for x+1 < c.Size && c.modules[y][x+1] && !onPlate(x+1, y) {
+				x++
+			}
+			l.rects = append(l.rects, image.Rect(offset+start*scale, offset+y*scale, offset+(x+1)*scale, offset+(y+1)*scale))
+		}
+	}
+
+	if style.Logo != nil {
+		// half a module of padding around the logo
+		box := image.Rect(offset+plateStart*scale, offset+plateStart*scale, offset+plateEnd*scale, offset+plateEnd*scale).Inset(max(scale/2, 1))
+		l.logo = scaleToFit(style.Logo, box.Dx(), box.Dy())
+		size := l.logo.Bounds().Size()
+		l.logoRect = image.Rectangle{Min: box.Min.Add(box.Size().Sub(size).Div(2))}
+		l.logoRect.Max = l.logoRect.Min.Add(size)
+	}
+
+	if style.Caption != "" {
+		if err := l.addCaption(style.Caption); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
+}
+
+// draws the caption centered in a band below the code, as large as fits
+func (l *layout) addCaption(caption string) error {
+	text := make([]byte, 0, len(caption))
+	for _, ch := range caption {
+		if ch < ' ' || ch > '~' {
+			ch = '?'
+		}
+		text = append(text, byte(ch))
+	}
+
+	margin := l.width / 16
+	textWidth := func(scale int) int { return (len(text)*(glyphWidth+1) - 1) * scale }
+	scale := max(l.width/128, 1)
+	for scale > 1 && textWidth(scale) > l.width-2*margin {
+		scale--
+	}
+	if textWidth(scale) > l.width {
+		return ErrCaptionTooLong
+	}
+
+	left := (l.width - textWidth(scale)) / 2
+	top := l.height
+	l.height += (glyphHeight + 3) * scale
+
+	for i, ch := range text {
+		glyph := font[ch-' ']
+		for column := 0; column < glyphWidth; column++ {
+			for row := 0; row < glyphHeight; row++ {
+				if glyph[column]&(1<<row) == 0 {
+					continue
+				}
+				x := left + (i*(glyphWidth+1)+column)*scale
+				y := top + (row+1)*scale
+				l.rects = append(l.rects, image.Rect(x, y, x+scale, y+scale))
+			}
+		}
+	}
+	return nil
+}
+
+// scales an image into width x height keeping its aspect ratio, every target pixel averages its source area
+func scaleToFit(src image.Image, width, height int) *image.NRGBA {
+	bounds := src.Bounds()
+	if bounds.Dx()*height > bounds.Dy()*width {
+		height = max(bounds.Dy()*width/bounds.Dx(), 1)
+	} else {
+		width = max(bounds.Dx()*height/bounds.Dy(), 1)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+
+			// premultiplied sums so transparent pixels do not darken the edges
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// custom errors for qr rendering
+var (
+	ErrInvalidLogo    = errors.New("logo must be a png, jpeg or gif image")
+	ErrCaptionTooLong = errors.New("caption too long for this image size")
+)
@@ -0,0 +1,266 @@
+package qrcode
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SheetLayout is the grid of a print sheet, all lengths in points (1/72 inch)
+type SheetLayout struct {
+	PageWidth  float64
+	PageHeight float64
+	Columns    int
+	Rows       int
+	Margin     float64 // page edge to grid, cut marks are drawn inside
+	QuietZone  int     // light border around each code in modules
+	CutMarks   bool
+}
+
+// SheetItem is one cell of a print sheet
+type SheetItem struct {
+	Code   *Code
+	Labels []string // lines printed below the code
+}
+
+const (
+	pointsPerMM  = 72 / 25.4
+	cellPadding  = 4.0 // points between cell edge and code or labels
+	minCodeSide  = 36.0
+	cutMarkInset = 2.0
+)
+
+// PageSize returns width and height in points of a4, a3, a5, letter or legal
+func PageSize(name string, landscape bool) (float64, float64, error) {
+	sizes := map[string][2]float64{
+		"a3":     {297 * pointsPerMM, 420 * pointsPerMM},
+		"a4":     {210 * pointsPerMM, 297 * pointsPerMM},
+		"a5":     {148 * pointsPerMM, 210 * pointsPerMM},
+		"letter": {612, 792},
+		"legal":  {612, 1008},
+	}
+
+	size, ok := sizes[strings.ToLower(name)]
+	if !ok {
+		return 0, 0, errors.New("invalid page size, use a3, a4, a5, letter or legal")
+	}
+	if landscape {
+		return size[1], size[0], nil
+	}
+	return size[0], size[1], nil
+}
+
+// MM converts millimeters to points
+func MM(mm float64) float64 {
+	return mm * pointsPerMM
+}
+
+// RenderSheet lays the codes out in a grid as pdf with as many pages as needed. codes are drawn as
+// vector rectangles (sharp at any printer resolution), labels use the builtin helvetica font
+func RenderSheet(items []SheetItem, layout SheetLayout) ([]byte, error) {
+	if len(items) == 0 {
+		return nil, errors.New("no qr codes to print")
+	}
+	if layout.Columns < 1 || layout.Rows < 1 {
+		return nil, errors.New("columns and rows must be at least 1")
+	}
+	if layout.Margin < 0 || layout.QuietZone < 0 {
+		return nil, errors.New("margin and quiet zone must not be negative")
+	}
+
+	cellWidth := (layout.PageWidth - 2*layout.Margin) / float64(layout.Columns)
+	cellHeight := (layout.PageHeight - 2*layout.Margin) / float64(layout.Rows)
+
+	lines := 0
+	for _, item := range items {
+		lines = max(lines, len(item.Labels))
+	}
+	fontSize := min(max(cellWidth/16, 6), 10)
+	lineHeight := fontSize * 1.25
+	labelHeight := float64(lines) * lineHeight
+
+	side := min(cellWidth, cellHeight-labelHeight) - 2*cellPadding
+	if side < minCodeSide {
+		return nil, ErrSheetTooDense
+	}
+
+	var pages []string
+	perPage := layout.Columns * layout.Rows
+	for start := 0; start < len(items); start += perPage {
+		var content strings.Builder
+		if layout.CutMarks {
+			writeCutMarks(&content, layout, cellWidth, cellHeight)
+		}
+
+		for i, item := range items[start:min(start+perPage, len(items))] {
+			// pdf coordinates start at the bottom left, cells are filled from the top left
+			left := layout.Margin + float64(i%layout.Columns)*cellWidth
+			top := layout.PageHeight - layout.Margin - float64(i/layout.Columns)*cellHeight
+
+			modules := float64(item.Code.Size + 2*layout.QuietZone)
+			moduleSize := side / modules
+			codeLeft := left + (cellWidth-side)/2 + float64(layout.QuietZone)*moduleSize
+			codeTop := top - cellPadding - float64(layout.QuietZone)*moduleSize
+
+			content.WriteString("0 g\n")
+			for y := 0; y < item.Code.Size; y++ {
+				for x := 0; x < item.Code.Size; x++ {
+					if !item.Code.modules[y][x] {
+						continue
+					}
+					run := x
+					for x+1 < item.Code.Size && item.Code.modules[y][x+1] {
+						x++
+					}
+					fmt.Fprintf(&content, "%s %s %s %s re\n",
+						pdfNumber(codeLeft+float64(run)*moduleSize), pdfNumber(codeTop-float64(y+1)*moduleSize),
+						pdfNumber(float64(x+1-run)*moduleSize), pdfNumber(moduleSize))
+				}
+			}
+			content.WriteString("f\n")
+
+			baseline := top - cellPadding - side - fontSize
+			for _, label := range item.Labels {
+				text := fitText(label, fontSize, cellWidth-2*cellPadding)
+				x := left + (cellWidth-textWidth(text, fontSize))/2
+				fmt.Fprintf(&content, "BT /F1 %s Tf %s %s Td (%s) Tj ET\n", pdfNumber(fontSize), pdfNumber(x), pdfNumber(baseline), pdfEscape(text))
+				baseline -= lineHeight
+			}
+		}
+		pages = append(pages, content.String())
+	}
+
+	return writePDF(pages, layout.PageWidth, layout.PageHeight)
+}
+
+// crop marks in the margin at every grid line
+func writeCutMarks(content *strings.Builder, layout SheetLayout, cellWidth, cellHeight float64) {
+	if layout.Margin <= 2*cutMarkInset {
+		return
+	}
+
+	content.WriteString("0.25 w 0 G\n")
+	line := func(x1, y1, x2, y2 float64) {
+		fmt.Fprintf(content, "%s %s m %s %s l S\n", pdfNumber(x1), pdfNumber(y1), pdfNumber(x2), pdfNumber(y2))
+	}
+
+	top, right := layout.PageHeight-layout.Margin, layout.PageWidth-layout.Margin
+	for column := 0; column <= layout.Columns; column++ {
+		x := layout.Margin + float64(column)*cellWidth
+		line(x, cutMarkInset, x, layout.Margin-cutMarkInset)
+		line(x, top+cutMarkInset, x, layout.PageHeight-cutMarkInset)
+	}
+	for row := 0; row <= layout.Rows; row++ {
+		y := layout.Margin + float64(row)*cellHeight
+		line(cutMarkInset, y, layout.Margin-cutMarkInset, y)
+		line(right+cutMarkInset, y, layout.PageWidth-cutMarkInset, y)
+	}
+}
+
+// pdf with one helvetica font and a compressed content stream per page
+func writePDF(pages []string, width, height float64) ([]byte, error) {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1 catalog, 2 page tree, 3 font, then page and content objects in pairs
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = strconv.Itoa(4+2*i) + " 0 R"
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfNumber(width), pdfNumber(height), 5+2*i))
+
+		var stream bytes.Buffer
+		writer := zlib.NewWriter(&stream)
+		if _, err := writer.Write([]byte(page)); err != nil {
+			return nil, errors.New("pdf compression failed - " + err.Error())
+		}
+		if err := writer.Close(); err != nil {
+			return nil, errors.New("pdf compression failed - " + err.Error())
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes(), nil
+}
+
+func pdfNumber(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// winansi string literal, characters outside latin-1 are printed as ?
+func pdfEscape(text string) string {
+	var b strings.Builder
+	for _, ch := range text {
+		switch {
+		case ch == '(' || ch == ')' || ch == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(ch)
+		case ch >= ' ' && ch <= '~':
+			b.WriteRune(ch)
+		case ch >= 0xA0 && ch <= 0xFF:
+			fmt.Fprintf(&b, "\\%03o", ch)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// shortens text with ... until it fits into width
+func fitText(text string, fontSize, width float64) string {
+	if textWidth(text, fontSize) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && textWidth(string(runes)+"...", fontSize) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+func textWidth(text string, fontSize float64) float64 {
+	width := 0
+	for _, ch := range text {
+		if ch >= ' ' && ch <= '~' {
+			width += helveticaWidths[ch-' ']
+		} else {
+			width += 556 // latin-1 letters are close to the width of their base letter
+		}
+	}
+	return float64(width) * fontSize / 1000
+}
+
+// helvetica advance widths for printable ascii in 1/1000 of the font size
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // space to /
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // 0 to ?
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // @ to O
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // P to _
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // ` to o
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // p to ~
+}
+
+// custom errors for print sheets
+var ErrSheetTooDense = errors.New("grid too dense for the page, use fewer columns or rows or a larger page")
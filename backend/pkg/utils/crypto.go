@@ -1,16 +1,22 @@
 package utils
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"strings"
-	"unicode"
 
 	"golang.org/x/crypto/argon2"
 )
 
+// token hashes (and legacy password hashes) use these fixed parameters
 const (
 	saltLength  = 16
 	timeCost    = 1         // Argon2 time parameter
@@ -77,8 +83,106 @@ func VerifyToken(token, pepper, storedHash string) bool {
 	return subtle.ConstantTimeCompare(storedHashBytes, computedHash) == 1
 }
 
-// HashPassword creates a secure hash of a password using Argon2id
-func HashPassword(password, pepper string) (string, error) {
+// GenerateRandomToken creates a url safe random token with the given amount of entropy bytes
+func GenerateRandomToken(length int) (string, error) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("token generation failed: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashLookupToken creates a deterministic hash of a high entropy token so it can be used as a database key
+// (argon2 is not needed here since random tokens cannot be brute forced)
+func HashLookupToken(token, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// EncryptSecret encrypts a secret that has to be recovered later (e.g. totp seeds) with aes-gcm
+// the key is derived from the server side pepper
+func EncryptSecret(plaintext, pepper string) (string, error) {
+	gcm, err := secretCipher(pepper)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("nonce generation failed: %w", err)
+	}
+
+	// format: nonce followed by ciphertext
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptSecret reverses EncryptSecret
+func DecryptSecret(encrypted, pepper string) (string, error) {
+	gcm, err := secretCipher(pepper)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", fmt.Errorf("secret decoding failed: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("secret too short")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("secret decryption failed: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// derive an aes-256-gcm cipher from the pepper
+func secretCipher(pepper string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("secret-encryption:" + pepper))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Argon2Params are the argon2id cost parameters of a password hash
+type Argon2Params struct {
+	Time      uint32 // iterations
+	Memory    uint32 // memory in KiB
+	Threads   uint8
+	KeyLength uint32
+}
+
+// LegacyArgon2Params were used for the salt$hash format that did not store its parameters
+var LegacyArgon2Params = Argon2Params{
+	Time:      timeCost,
+	Memory:    memoryCost,
+	Threads:   parallelism,
+	KeyLength: keyLength,
+}
+
+// Validate rejects parameters argon2 cannot run with or that are too weak for passwords
+func (p Argon2Params) Validate() error {
+	switch {
+	case p.Time < 1:
+		return errors.New("argon2 time must be at least 1")
+	case p.Threads < 1:
+		return errors.New("argon2 threads must be at least 1")
+	case p.Memory < 19*1024:
+		return errors.New("argon2 memory must be at least 19456 KiB")
+	case p.KeyLength < 16:
+		return errors.New("argon2 key length must be at least 16 bytes")
+	}
+	return nil
+}
+
+// HashPassword creates a phc formatted argon2id hash ($argon2id$v=19$m=...,t=...,p=...$salt$hash)
+func HashPassword(password, pepper string, params Argon2Params) (string, error) {
 	// generate cryptographically secure salt
 	salt := make([]byte, saltLength)
 	if _, err := rand.Read(salt); err != nil {
@@ -92,78 +196,109 @@ func HashPassword(password, pepper string) (string, error) {
 	hash := argon2.IDKey(
 		[]byte(peppered),
 		salt,
-		timeCost,
-		memoryCost,
-		parallelism,
-		keyLength,
+		params.Time,
+		params.Memory,
+		params.Threads,
+		params.KeyLength,
 	)
 
-	// encode to string: salt$hash
+	// the parameters are stored with the hash so they can be raised later
 	return fmt.Sprintf(
-		"%s$%s",
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.Memory,
+		params.Time,
+		params.Threads,
 		base64.RawStdEncoding.EncodeToString(salt),
 		base64.RawStdEncoding.EncodeToString(hash),
 	), nil
 }
 
-// CheckPasswordHash verifies a password against a stored hash
+// CheckPasswordHash verifies a password against a stored phc or legacy salt$hash value
 func CheckPasswordHash(password, pepper, storedHash string) bool {
-	// split stored hash into components
-	parts := strings.Split(storedHash, "$")
-	if len(parts) != 2 {
-		return false
-	}
-
-	// decode salt
-	salt, err := base64.RawStdEncoding.DecodeString(parts[0])
-	if err != nil {
-		return false
-	}
-
-	// decode stored hash
-	storedHashBytes, err := base64.RawStdEncoding.DecodeString(parts[1])
+	params, salt, storedHashBytes, err := decodePasswordHash(storedHash)
 	if err != nil {
 		return false
 	}
 
-	// recompute hash with same parameters
+	// recompute hash with the stored parameters
 	peppered := password + pepper
 	computedHash := argon2.IDKey(
 		[]byte(peppered),
 		salt,
-		timeCost,
-		memoryCost,
-		parallelism,
-		keyLength,
+		params.Time,
+		params.Memory,
+		params.Threads,
+		uint32(len(storedHashBytes)),
 	)
 
 	// constant time comparison to prevent timing attacks
 	return subtle.ConstantTimeCompare(storedHashBytes, computedHash) == 1
 }
 
-// ValidateEmail checks if an email address is properly formatted
-func ValidateEmail(email string) bool {
-	// simplified regex for basic validation
-	return strings.Contains(email, "@") && strings.Contains(email, ".")
-}
+// PasswordNeedsRehash reports whether a stored hash is in the legacy format or uses other parameters
+func PasswordNeedsRehash(storedHash string, params Argon2Params) bool {
+	if !strings.HasPrefix(storedHash, "$") {
+		return true
+	}
 
-func ValidatePassword(password string) bool {
-	if len(password) < 8 { // check for lenght
-		return false
+	stored, _, hash, err := decodePasswordHash(storedHash)
+	if err != nil {
+		return true
 	}
+	return stored.Time != params.Time || stored.Memory != params.Memory || stored.Threads != params.Threads || uint32(len(hash)) != params.KeyLength
+}
+
+// split a stored hash into parameters, salt and hash
+func decodePasswordHash(storedHash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+	var encodedSalt, encodedHash string
+
+	if strings.HasPrefix(storedHash, "$") {
+		// phc format: "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+		parts := strings.Split(storedHash, "$")
+		if len(parts) != 6 || parts[1] != "argon2id" {
+			return params, nil, nil, errors.New("unsupported password hash format")
+		}
 
-	var hasUpper, hasLower, hasNumber bool
+		var version int
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return params, nil, nil, errors.New("unsupported argon2 version")
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+			return params, nil, nil, errors.New("invalid argon2 parameters")
+		}
+		if params.Time < 1 || params.Threads < 1 {
+			return params, nil, nil, errors.New("invalid argon2 parameters")
+		}
 
-	for _, c := range password { // check for big small and digits
-		switch {
-		case unicode.IsUpper(c):
-			hasUpper = true
-		case unicode.IsLower(c):
-			hasLower = true
-		case unicode.IsDigit(c):
-			hasNumber = true
+		encodedSalt, encodedHash = parts[4], parts[5]
+	} else {
+		// legacy format: salt$hash with the package constants
+		parts := strings.Split(storedHash, "$")
+		if len(parts) != 2 {
+			return params, nil, nil, errors.New("unsupported password hash format")
 		}
+
+		params = LegacyArgon2Params
+		encodedSalt, encodedHash = parts[0], parts[1]
 	}
 
-	return hasUpper && hasLower && hasNumber
+	salt, err := base64.RawStdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return params, nil, nil, err
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(encodedHash)
+	if err != nil || len(hash) == 0 {
+		return params, nil, nil, errors.New("invalid password hash")
+	}
+
+	params.KeyLength = uint32(len(hash))
+	return params, salt, hash, nil
+}
+
+// ValidateEmail checks if an email address is properly formatted
+func ValidateEmail(email string) bool {
+	// simplified regex for basic validation
+	return strings.Contains(email, "@") && strings.Contains(email, ".")
 }
@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIP returns the client address, preferring the header set by the nginx reverse proxy
+func ClientIP(r *http.Request) string {
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
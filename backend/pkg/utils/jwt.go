@@ -8,18 +8,30 @@ import (
 )
 
 // GenerateTokenPair creates access and refresh tokens
-func GenerateTokenPair(userID string, secret []byte, accessTTL, refreshTTL time.Duration) (string, string, error) {
+func GenerateTokenPair(userID string, keys *KeySet, accessTTL, refreshTTL time.Duration) (string, string, error) {
+	// unique id so a single access token can be revoked (denylist)
+	accessID, err := GenerateRandomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
 	// access token claims
 	accessClaims := jwt.MapClaims{
 		"sub": userID, // subject (user ID)
 		"exp": time.Now().Add(accessTTL).Unix(),
 		"iat": time.Now().Unix(),
 		"typ": "access",
+		"jti": accessID,
 	}
 
-	// create and sign access token
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	accessSigned, err := accessToken.SignedString(secret)
+	// sign access token
+	accessSigned, err := keys.sign(accessClaims)
+	if err != nil {
+		return "", "", err
+	}
+
+	// unique id so two refresh tokens minted in the same second differ (needed for reuse detection)
+	refreshID, err := GenerateRandomToken(16)
 	if err != nil {
 		return "", "", err
 	}
@@ -30,25 +42,38 @@ func GenerateTokenPair(userID string, secret []byte, accessTTL, refreshTTL time.
 		"exp": time.Now().Add(refreshTTL).Unix(),
 		"iat": time.Now().Unix(),
 		"typ": "refresh",
+		"jti": refreshID,
 	}
 
-	// create and sign refresh token
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-	refreshSigned, err := refreshToken.SignedString(secret)
+	// sign refresh token
+	refreshSigned, err := keys.sign(refreshClaims)
 
 	return accessSigned, refreshSigned, err
 }
 
-// ParseToken validates and parses a JWT token
-func ParseToken(tokenString string, secret []byte) (jwt.MapClaims, error) {
-	// parse token with validation
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		// validate signing algorithm
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrSignatureInvalid
+// GenerateTypedToken creates a single purpose token (e.g. email verification) with additional claims
+func GenerateTypedToken(userID, tokenType string, extraClaims map[string]interface{}, keys *KeySet, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"exp": time.Now().Add(ttl).Unix(),
+		"iat": time.Now().Unix(),
+		"typ": tokenType,
+	}
+
+	// extra claims must not override the registered ones
+	for key, value := range extraClaims {
+		if _, exists := claims[key]; !exists {
+			claims[key] = value
 		}
-		return secret, nil
-	})
+	}
+
+	return keys.sign(claims)
+}
+
+// ParseToken validates and parses a JWT token (the verification key is selected by the kid header)
+func ParseToken(tokenString string, keys *KeySet) (jwt.MapClaims, error) {
+	// parse token with validation
+	token, err := jwt.Parse(tokenString, keys.keyFunc, jwt.WithValidMethods([]string{"EdDSA", "RS256", "HS256"}))
 
 	if err != nil {
 		return nil, err
@@ -68,8 +93,8 @@ func ParseToken(tokenString string, secret []byte) (jwt.MapClaims, error) {
 }
 
 // ValidateToken checks if a token is valid and of specific type
-func ValidateToken(tokenString, expectedType string, secret []byte) (jwt.MapClaims, error) {
-	claims, err := ParseToken(tokenString, secret)
+func ValidateToken(tokenString, expectedType string, keys *KeySet) (jwt.MapClaims, error) {
+	claims, err := ParseToken(tokenString, keys)
 	if err != nil {
 		return nil, err
 	}
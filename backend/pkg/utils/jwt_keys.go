@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is an asymmetric jwt key identified by its kid
+type SigningKey struct {
+	ID      string            // rfc 7638 thumbprint of the public key
+	Method  jwt.SigningMethod // EdDSA for ed25519 keys, RS256 for rsa keys
+	private crypto.Signer     // nil for verification only keys
+	public  crypto.PublicKey
+}
+
+// KeySet holds the key used to sign new tokens and all keys accepted for verification
+type KeySet struct {
+	signing      *SigningKey
+	verification map[string]*SigningKey
+	legacySecret []byte    // hs256 secret accepted for tokens issued before the switch (nil = rejected)
+	legacyUntil  time.Time // legacy tokens are rejected after this time
+}
+
+// JWK is the public part of a key as served in the jwks document
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"` // OKP
+	X         string `json:"x,omitempty"`   // OKP
+	N         string `json:"n,omitempty"`   // RSA
+	E         string `json:"e,omitempty"`   // RSA
+}
+
+// LoadKeySet reads the signing key and additional verification keys from pem files.
+// a missing signing key file is created with a new ed25519 key if generate is set. the legacy secret
+// needs a cutoff, a leaked or default secret must not allow forging tokens forever
+func LoadKeySet(signingKeyFile string, verificationKeyFiles []string, generate bool, legacySecret string, legacyUntil time.Time) (*KeySet, error) {
+	if signingKeyFile == "" {
+		return nil, errors.New("no jwt signing key file configured")
+	}
+	if legacySecret != "" && legacyUntil.IsZero() {
+		return nil, errors.New("legacy jwt secret configured without a cutoff")
+	}
+
+	signing, rotated, err := loadKeyFiles(signingKeyFile, verificationKeyFiles, generate)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &KeySet{
+		signing:      signing,
+		verification: map[string]*SigningKey{signing.ID: signing},
+	}
+	if legacySecret != "" {
+		keys.legacySecret = []byte(legacySecret)
+		keys.legacyUntil = legacyUntil
+	}
+
+	// old keys stay valid for verification until their tokens expired
+	for _, key := range rotated {
+		if _, exists := keys.verification[key.ID]; !exists {
+			keys.verification[key.ID] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// load the signing key (created with a new ed25519 key if missing and generate is set) and the verification only keys
+func loadKeyFiles(signingKeyFile string, verificationKeyFiles []string, generate bool) (*SigningKey, []*SigningKey, error) {
+	if _, err := os.Stat(signingKeyFile); errors.Is(err, os.ErrNotExist) && generate {
+		if err := GenerateSigningKeyFile(signingKeyFile, "ed25519"); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	signing, err := loadKeyFile(signingKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if signing.private == nil {
+		return nil, nil, fmt.Errorf("%s does not contain a private key", signingKeyFile)
+	}
+
+	var rotated []*SigningKey
+	for _, file := range verificationKeyFiles {
+		file = strings.TrimSpace(file)
+		if file == "" {
+			continue
+		}
+
+		key, err := loadKeyFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		rotated = append(rotated, key)
+	}
+
+	return signing, rotated, nil
+}
+
+// SigningKeyID returns the kid of the key used for new tokens
+func (k *KeySet) SigningKeyID() string {
+	return k.signing.ID
+}
+
+// PublicJWKs returns all verification keys in jwk format (signing key first)
+func (k *KeySet) PublicJWKs() []JWK {
+	jwks := []JWK{k.signing.jwk()}
+	for id, key := range k.verification {
+		if id != k.signing.ID {
+			jwks = append(jwks, key.jwk())
+		}
+	}
+	return jwks
+}
+
+// sign the claims with the current signing key
+func (k *KeySet) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	token.Header["kid"] = k.signing.ID
+	return token.SignedString(k.signing.private)
+}
+
+// pick the verification key by the kid header of the token
+func (k *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	// tokens without kid can only be legacy hs256 tokens, accepted until the cutoff
+	if kid == "" {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok && k.legacySecret != nil && time.Now().Before(k.legacyUntil) {
+			return k.legacySecret, nil
+		}
+		return nil, jwt.ErrTokenUnverifiable
+	}
+
+	key, ok := k.verification[kid]
+	if !ok {
+		return nil, jwt.ErrTokenUnverifiable
+	}
+
+	// the algorithm is bound to the key, never taken from the token
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, jwt.ErrSignatureInvalid
+	}
+	return key.public, nil
+}
+
+// GenerateSigningKeyFile writes a new private key ("ed25519" or "rsa") as pkcs8 pem
+func GenerateSigningKeyFile(path, keyType string) error {
+	var private crypto.Signer
+	var err error
+
+	switch keyType {
+	case "ed25519":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "rsa":
+		private, err = rsa.GenerateKey(rand.Reader, 3072)
+	default:
+		return fmt.Errorf("unknown key type %q", keyType)
+	}
+	if err != nil {
+		return errors.New("key generation failed - " + err.Error())
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return errors.New("key encoding failed - " + err.Error())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.New("could not create key directory - " + err.Error())
+	}
+
+	// O_EXCL so an existing key is never overwritten
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return errors.New("could not create key file - " + err.Error())
+	}
+	defer file.Close()
+
+	return pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// read a private (pkcs8 or pkcs1) or public (pkix) key from a pem file
+func loadKeyFile(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("could not read key file - " + err.Error())
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a pem file", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s has unsupported pem type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s - %s", path, err.Error())
+	}
+
+	key := &SigningKey{}
+	switch k := parsed.(type) {
+	case ed25519.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case *rsa.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodRS256, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.public = jwt.SigningMethodEdDSA, k
+	case *rsa.PublicKey:
+		key.Method, key.public = jwt.SigningMethodRS256, k
+	default:
+		return nil, fmt.Errorf("%s contains an unsupported key type", path)
+	}
+
+	if rsaKey, ok := key.public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("%s contains an rsa key shorter than 2048 bits", path)
+	}
+
+	key.ID = thumbprint(key.jwk())
+	return key, nil
+}
+
+// public jwk of the key (kid is empty until the thumbprint is computed)
+func (k *SigningKey) jwk() JWK {
+	jwk := JWK{KeyID: k.ID, Use: "sig", Algorithm: k.Method.Alg()}
+
+	switch public := k.public.(type) {
+	case ed25519.PublicKey:
+		jwk.KeyType, jwk.Curve, jwk.X = "OKP", "Ed25519", base64.RawURLEncoding.EncodeToString(public)
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	}
+	return jwk
+}
+
+// rfc 7638 jwk thumbprint (required members in lexicographic order)
+func thumbprint(jwk JWK) string {
+	var members interface{}
+	if jwk.KeyType == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.KeyType, jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Curve, jwk.KeyType, jwk.X}
+	}
+
+	encoded, _ := json.Marshal(members) // marshalling plain strings cannot fail
+	sum := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,248 @@
+package utils
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// violation codes returned to clients, one per failed rule
+const (
+	PasswordTooShort        = "too_short"
+	PasswordTooLong         = "too_long"
+	PasswordMissingUpper    = "missing_uppercase"
+	PasswordMissingLower    = "missing_lowercase"
+	PasswordMissingDigit    = "missing_digit"
+	PasswordMissingSymbol   = "missing_symbol"
+	PasswordRepeatedChars   = "repeated_characters"
+	PasswordContainsEmail   = "contains_email"
+	PasswordBreached        = "breached"
+	minEmailLocalPartLength = 3 // shorter local parts would reject too many unrelated passwords
+)
+
+// PasswordPolicy holds the rules new passwords are checked against
+type PasswordPolicy struct {
+	MinLength            int  // characters, not bytes
+	MaxLength            int  // 0 = unlimited
+	RequireUpper         bool // at least one uppercase letter
+	RequireLower         bool // at least one lowercase letter
+	RequireDigit         bool // at least one digit
+	RequireSymbol        bool // at least one character that is neither letter nor digit
+	MaxRepeated          int  // longest allowed run of the same character (0 = unlimited)
+	ForbidEmailLocalPart bool // reject passwords containing the part of the email before the @
+
+	Blocklist *PasswordBlocklist // common and breached passwords (nil = disabled)
+}
+
+// PasswordViolation describes one failed rule
+type PasswordViolation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError is returned when a password fails one or more rules
+type PasswordPolicyError struct {
+	Violations []PasswordViolation
+}
+
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return "password does not meet the requirements - " + strings.Join(messages, ", ")
+}
+
+// Check returns all rules the password violates (empty if it is acceptable)
+func (p *PasswordPolicy) Check(password, email string) []PasswordViolation {
+	var violations []PasswordViolation
+	add := func(code, message string) {
+		violations = append(violations, PasswordViolation{Code: code, Message: message})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(PasswordTooShort, "must be at least "+strconv.Itoa(p.MinLength)+" characters")
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(PasswordTooLong, "must be at most "+strconv.Itoa(p.MaxLength)+" characters")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	var previous rune
+	run, longestRun := 0, 0
+
+	for _, c := range password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasDigit = true
+		case !unicode.IsLetter(c):
+			hasSymbol = true
+		}
+
+		if run > 0 && c == previous {
+			run++
+		} else {
+			run = 1
+		}
+		previous = c
+		longestRun = max(longestRun, run)
+	}
+
+	if p.RequireUpper && !hasUpper {
+		add(PasswordMissingUpper, "must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		add(PasswordMissingLower, "must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		add(PasswordMissingDigit, "must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		add(PasswordMissingSymbol, "must contain a symbol")
+	}
+	if p.MaxRepeated > 0 && longestRun > p.MaxRepeated {
+		add(PasswordRepeatedChars, "must not repeat a character more than "+strconv.Itoa(p.MaxRepeated)+" times in a row")
+	}
+
+	if p.ForbidEmailLocalPart {
+		localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+		if utf8.RuneCountInString(localPart) >= minEmailLocalPartLength && strings.Contains(strings.ToLower(password), localPart) {
+			add(PasswordContainsEmail, "must not contain your email address")
+		}
+	}
+
+	// only checked when everything else passed, a weak password is most likely also listed
+	if len(violations) == 0 && p.Blocklist != nil && p.Blocklist.Contains(password) {
+		add(PasswordBreached, "must not be a common or breached password")
+	}
+
+	return violations
+}
+
+// Validate returns a *PasswordPolicyError if the password violates any rule
+func (p *PasswordPolicy) Validate(password, email string) error {
+	if violations := p.Check(password, email); len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// PasswordBlocklist looks up passwords in a file of sha1 hashes sorted ascending, one
+// "HASH[:COUNT]" per line (the format of the "ordered by hash" pwned passwords download).
+// like the k-anonymity range api only the lines sharing the 5 character hash prefix are
+// read, found by binary search, so the file is never loaded into memory
+type PasswordBlocklist struct {
+	file *os.File
+	size int64
+}
+
+const blocklistPrefixLength = 5
+
+// OpenPasswordBlocklist opens a sorted hash file (kept open for the lifetime of the server)
+func OpenPasswordBlocklist(path string) (*PasswordBlocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.New("could not open password blocklist - " + err.Error())
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.New("could not read password blocklist - " + err.Error())
+	}
+
+	return &PasswordBlocklist{file: file, size: info.Size()}, nil
+}
+
+// Contains reports whether the password is listed. read errors are treated as not listed
+// so a broken file cannot block all password changes
+func (b *PasswordBlocklist) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix := hash[:blocklistPrefixLength]
+
+	offset, err := b.findRange(prefix)
+	if err != nil {
+		return false
+	}
+
+	// scan the range of lines sharing the prefix
+	scanner := bufio.NewScanner(io.NewSectionReader(b.file, offset, b.size-offset))
+	for scanner.Scan() {
+		lineHash := blocklistHash(scanner.Text())
+		if !strings.HasPrefix(lineHash, prefix) {
+			return false
+		}
+		if lineHash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// binary search for the offset of the first line whose hash is >= prefix
+func (b *PasswordBlocklist) findRange(prefix string) (int64, error) {
+	low, high := int64(0), b.size
+	for low < high {
+		mid := low + (high-low)/2
+
+		start, line, err := b.lineAfter(mid)
+		if err != nil {
+			return 0, err
+		}
+		if start >= b.size || blocklistHash(line) >= prefix {
+			high = mid
+		} else {
+			low = start + int64(len(line)) + 1 // skip past the line that is still too small
+		}
+	}
+
+	start, _, err := b.lineAfter(low)
+	return start, err
+}
+
+// first complete line starting at or after offset (offset itself if it starts a line)
+func (b *PasswordBlocklist) lineAfter(offset int64) (int64, string, error) {
+	start := offset
+	if start > 0 {
+		start-- // a line starts at offset if the byte before it is a newline
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(b.file, start, b.size-start))
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return b.size, "", nil
+		} else if err != nil {
+			return 0, "", err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	if line == "" {
+		return b.size, "", nil
+	}
+	return start, strings.TrimRight(line, "\r\n"), nil
+}
+
+// hash part of a blocklist line (the count is ignored)
+func blocklistHash(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
@@ -0,0 +1,192 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// signed qr payload, base64url (no padding) of
+//
+//	version (1 byte) | flags (1 byte) | code id (16 bytes)
+//	| expiry (4 bytes, unix seconds, if qrFlagExpiry) | key id (4 bytes, if qrFlagKeyID)
+//	| ed25519 signature over everything before it (64 bytes)
+//
+// ed25519 instead of a (shorter) hmac so clients can verify codes offline with the published
+// public keys. payloads without key id are checked against every verification key
+const (
+	qrPayloadVersion = 1
+	qrFlagExpiry     = 1 << 0
+	qrFlagKeyID      = 1 << 1
+	qrHeaderLength   = 2 + 16
+)
+
+// QRPayload is the verified content of a signed qr payload
+type QRPayload struct {
+	CodeID    gocql.UUID
+	ExpiresAt time.Time // zero if the payload does not expire
+	KeyID     string    // key that signed the payload
+}
+
+// QRKeySet holds the ed25519 key that signs new qr payloads and all keys accepted for verification.
+// printed codes live long, rotated out keys should stay configured until their codes were replaced
+type QRKeySet struct {
+	signing      *SigningKey
+	verification map[uint32]*SigningKey // by short key id
+}
+
+// LoadQRKeySet reads the ed25519 signing key and additional verification keys from pem files.
+// a missing signing key file is created with a new key if generate is set
+func LoadQRKeySet(signingKeyFile string, verificationKeyFiles []string, generate bool) (*QRKeySet, error) {
+	if signingKeyFile == "" {
+		return nil, errors.New("no qr signing key file configured")
+	}
+
+	signing, rotated, err := loadKeyFiles(signingKeyFile, verificationKeyFiles, generate)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := &QRKeySet{signing: signing, verification: make(map[uint32]*SigningKey)}
+	for _, key := range append([]*SigningKey{signing}, rotated...) {
+		if _, ok := key.public.(ed25519.PublicKey); !ok {
+			return nil, errors.New("qr keys must be ed25519 keys, key " + key.ID + " is not")
+		}
+
+		// the short id is part of the payload, two keys sharing one would be ambiguous
+		id := qrKeyID(key)
+		if existing, exists := keys.verification[id]; exists && existing.ID != key.ID {
+			return nil, fmt.Errorf("qr keys %s and %s share the key id %08x, generate a new key", existing.ID, key.ID, id)
+		}
+		if _, exists := keys.verification[id]; !exists {
+			keys.verification[id] = key
+		}
+	}
+
+	return keys, nil
+}
+
+// SigningKeyID returns the short id of the key used for new payloads
+func (k *QRKeySet) SigningKeyID() string {
+	return formatQRKeyID(qrKeyID(k.signing))
+}
+
+// PublicJWKs returns all verification keys in jwk format with their short id as kid (signing key first)
+func (k *QRKeySet) PublicJWKs() []JWK {
+	signingID := qrKeyID(k.signing)
+	jwks := []JWK{qrJWK(signingID, k.signing)}
+	for id, key := range k.verification {
+		if id != signingID {
+			jwks = append(jwks, qrJWK(id, key))
+		}
+	}
+	return jwks
+}
+
+// Sign creates the payload for a qr code, expiresAt is left out if zero
+func (k *QRKeySet) Sign(codeID gocql.UUID, expiresAt time.Time) (string, error) {
+	if !expiresAt.IsZero() && (expiresAt.Unix() <= 0 || expiresAt.Unix() > int64(^uint32(0))) {
+		return "", errors.New("qr code expiry out of range")
+	}
+
+	message := make([]byte, 0, qrHeaderLength+8+ed25519.SignatureSize)
+	message = append(message, qrPayloadVersion, qrFlagKeyID)
+	message = append(message, codeID.Bytes()...)
+	if !expiresAt.IsZero() {
+		message[1] |= qrFlagExpiry
+		message = binary.BigEndian.AppendUint32(message, uint32(expiresAt.Unix()))
+	}
+	message = binary.BigEndian.AppendUint32(message, qrKeyID(k.signing))
+
+	// ed25519 signs the plain message, crypto.Hash(0) tells the signer no hash was applied
+	signature, err := k.signing.private.Sign(nil, message, crypto.Hash(0))
+	if err != nil {
+		return "", errors.New("qr payload signing failed - " + err.Error())
+	}
+
+	return base64.RawURLEncoding.EncodeToString(append(message, signature...)), nil
+}
+
+// Verify checks the signature and expiry of a payload without touching the database
+func (k *QRKeySet) Verify(payload string, now time.Time) (*QRPayload, error) {
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(data) < qrHeaderLength+ed25519.SignatureSize || data[0] != qrPayloadVersion {
+		return nil, ErrInvalidQRPayload
+	}
+
+	flags := data[1]
+	length := qrHeaderLength
+	if flags&qrFlagExpiry != 0 {
+		length += 4
+	}
+	if flags&qrFlagKeyID != 0 {
+		length += 4
+	}
+	if flags&^(qrFlagExpiry|qrFlagKeyID) != 0 || len(data) != length+ed25519.SignatureSize {
+		return nil, ErrInvalidQRPayload
+	}
+
+	message, signature := data[:length], data[length:]
+	result := &QRPayload{}
+	result.CodeID, _ = gocql.UUIDFromBytes(message[2:qrHeaderLength]) // length is checked above
+
+	offset := qrHeaderLength
+	if flags&qrFlagExpiry != 0 {
+		result.ExpiresAt = time.Unix(int64(binary.BigEndian.Uint32(message[offset:])), 0).UTC()
+		offset += 4
+	}
+
+	// with a key id only that key is tried, otherwise every key
+	candidates := k.verification
+	if flags&qrFlagKeyID != 0 {
+		id := binary.BigEndian.Uint32(message[offset:])
+		key, ok := k.verification[id]
+		if !ok {
+			return nil, ErrInvalidQRPayload
+		}
+		candidates = map[uint32]*SigningKey{id: key}
+	}
+
+	for id, key := range candidates {
+		if ed25519.Verify(key.public.(ed25519.PublicKey), message, signature) {
+			result.KeyID = formatQRKeyID(id)
+
+			// checked after the signature, an unsigned expiry says nothing
+			if !result.ExpiresAt.IsZero() && !now.Before(result.ExpiresAt) {
+				return nil, ErrQRPayloadExpired
+			}
+			return result, nil
+		}
+	}
+	return nil, ErrInvalidQRPayload
+}
+
+// short key id: the first 4 bytes of the rfc 7638 thumbprint
+func qrKeyID(key *SigningKey) uint32 {
+	sum, _ := base64.RawURLEncoding.DecodeString(key.ID) // thumbprints are always valid base64
+	return binary.BigEndian.Uint32(sum)
+}
+
+func formatQRKeyID(id uint32) string {
+	return hex.EncodeToString(binary.BigEndian.AppendUint32(nil, id))
+}
+
+// jwk of a qr key, the kid is the short id found in payloads
+func qrJWK(id uint32, key *SigningKey) JWK {
+	jwk := key.jwk()
+	jwk.KeyID = formatQRKeyID(id)
+	return jwk
+}
+
+// custom errors for qr payloads
+var (
+	ErrInvalidQRPayload = errors.New("invalid or forged qr code")
+	ErrQRPayloadExpired = errors.New("this qr code is expired")
+)
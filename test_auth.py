@@ -2,6 +2,16 @@ import requests
 import json
 import uuid
 import time
+import hmac
+import hashlib
+import struct
+import zlib
+import zipfile
+import csv
+import io
+import base64
+import subprocess
+from concurrent.futures import ThreadPoolExecutor
 
 # config
 BASE_URL = "http://localhost:80"
@@ -10,6 +20,7 @@ TEST_PASSWORD = "BIGsmal123#"
 DEVICE_ID = str(uuid.uuid4())
 ADMIN_EMAIL = "admin@admin.com"
 ADMIN_PASSWORD = "Admin123#"
+PEPPER_SECRET = "TEMP_CHANGEME_TEMP" # must match PEPPER_SECRET in compose.yaml
 
 # helper functions
 def print_response(response):
@@ -37,6 +48,11 @@ def delete_user(access_token, device_id):
     respose = requests.post(url, headers=headers)
     return respose
 
+def cql(query):
+    # runs a query in the database container, only for states the api cannot create
+    result = subprocess.run(["docker", "exec", "scylladb", "cqlsh", "-e", query], capture_output=True, text=True, check=True)
+    return result.stdout
+
 def login_user(email, password, device_id):
     url = f"{BASE_URL}/auth/login"
     payload = {
@@ -125,8 +141,8 @@ def delete_qr_code(access_token, device_id, qr_code_id):
     response = requests.post(url, headers=headers, json=payload)
     return response
 
-def get_qr_action(access_token, device_id, qr_code_id):
-    url = f"{BASE_URL}/qr/scan?qr_code_id={qr_code_id}"
+def get_qr_action(access_token, device_id, payload):
+    url = f"{BASE_URL}/qr/scan?payload={payload}"
     headers = {
         "Authorization": f"Bearer {access_token}",
         "X-Device-ID": device_id
@@ -161,6 +177,7 @@ def test_full_authentication_flow():
     assert register_response.status_code == 201
     user_id = register_response.json().get("id")
     assert user_id is not None
+    assert register_response.json().get("verified") is False
     
     print("\n[2] Logging in...")
     login_response = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID)
@@ -205,6 +222,295 @@ def test_full_authentication_flow():
     print_response(protected_response)
     assert protected_response.status_code == 401
 
+    print("\n[9] Verifying the token stays revoked after a new login on the same device...")
+    login_response = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID)
+    assert login_response.status_code == 200
+    protected_response = protected_request(new_access_token, DEVICE_ID)
+    print_response(protected_response)
+    assert protected_response.status_code == 401
+    assert "revoked" in protected_response.text
+
+def test_jwks():
+    print("\n=== Testing JWKS Endpoint ===")
+
+    response = requests.get(f"{BASE_URL}/.well-known/jwks.json")
+    print_response(response)
+    assert response.status_code == 200
+    keys = response.json().get("keys")
+    assert len(keys) >= 1
+
+    # the kid of issued tokens must be published
+    access_token = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID).json().get("access_token")
+    header = json.loads(base64.urlsafe_b64decode(access_token.split(".")[0] + "=="))
+    assert header.get("alg") in ("EdDSA", "RS256")
+    assert header.get("kid") in [key.get("kid") for key in keys]
+
+def oidc_login(email, device_id, email_verified=True):
+    # the backend redirects to the provider with its compose hostname, the host reaches it via localhost
+    response = requests.get(f"{BASE_URL}/auth/oidc/start", params={"provider": "mock", "device_id": device_id}, allow_redirects=False)
+    assert response.status_code == 302
+    authorize_url = response.headers["Location"].replace("http://mock-oidc:9000", "http://localhost:9000")
+    authorize_url += "&login_hint=" + requests.utils.quote(email)
+    if not email_verified:
+        authorize_url += "&email_verified=false"
+
+    response = requests.get(authorize_url, allow_redirects=False)
+    assert response.status_code == 302
+    callback_url = response.headers["Location"]
+    return requests.get(callback_url, allow_redirects=False)
+
+def test_oidc_login():
+    print("\n=== Testing OpenID Connect Login ===")
+
+    print("\n[1] Listing providers...")
+    response = requests.get(f"{BASE_URL}/auth/oidc/providers")
+    print_response(response)
+    assert "mock" in response.json().get("providers")
+
+    print("\n[2] First login creates a verified account...")
+    email = f"oidc_{uuid.uuid4().hex[:8]}@example.com"
+    device_id = str(uuid.uuid4())
+    response = oidc_login(email, device_id)
+    print_response(response)
+    assert response.status_code == 200
+    user_id = response.json().get("user_id")
+    access_token = response.json().get("access_token")
+    assert protected_request(access_token, device_id).status_code == 200
+
+    print("\n[3] Second login resolves the linked account...")
+    response = oidc_login(email, device_id)
+    assert response.status_code == 200
+    assert response.json().get("user_id") == user_id
+
+    print("\n[4] Unverified provider email is rejected...")
+    response = oidc_login(f"oidc_{uuid.uuid4().hex[:8]}@example.com", device_id, email_verified=False)
+    print_response(response)
+    assert response.status_code == 401
+
+    print("\n[5] Replaying a callback fails...")
+    response = requests.get(f"{BASE_URL}/auth/oidc/start", params={"provider": "mock", "device_id": device_id}, allow_redirects=False)
+    authorize_url = response.headers["Location"].replace("http://mock-oidc:9000", "http://localhost:9000")
+    callback_url = requests.get(authorize_url, allow_redirects=False).headers["Location"]
+    assert requests.get(callback_url).status_code == 200
+    assert requests.get(callback_url).status_code == 401
+
+    print("\n[6] A session alone cannot add a password to a provider-only account...")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": device_id}
+    response = requests.post(f"{BASE_URL}/auth/change_password", headers=headers, json={"old_password": "", "new_password": TEST_PASSWORD})
+    print_response(response)
+    assert response.status_code == 403
+
+    delete_user(access_token, device_id)
+
+# minimal ed25519 (rfc 8032) so the software authenticator needs no extra packages
+ED_P = 2**255 - 19
+ED_Q = 2**252 + 27742317777372353535851937790883648493
+ED_D = -121665 * pow(121666, ED_P - 2, ED_P) % ED_P
+
+def ed_add(a, b):
+    A = (a[1] - a[0]) * (b[1] - b[0]) % ED_P
+    B = (a[1] + a[0]) * (b[1] + b[0]) % ED_P
+    C = 2 * a[3] * b[3] * ED_D % ED_P
+    D = 2 * a[2] * b[2] % ED_P
+    E, F, G, H = B - A, D - C, D + C, B + A
+    return (E * F % ED_P, G * H % ED_P, F * G % ED_P, E * H % ED_P)
+
+def ed_mul(s, point):
+    result = (0, 1, 1, 0)
+    while s > 0:
+        if s & 1:
+            result = ed_add(result, point)
+        point = ed_add(point, point)
+        s >>= 1
+    return result
+
+def ed_compress(point):
+    z_inv = pow(point[2], ED_P - 2, ED_P)
+    x, y = point[0] * z_inv % ED_P, point[1] * z_inv % ED_P
+    return int.to_bytes(y | ((x & 1) << 255), 32, "little")
+
+def ed_base():
+    y = 4 * pow(5, ED_P - 2, ED_P) % ED_P
+    x2 = (y * y - 1) * pow(ED_D * y * y + 1, ED_P - 2, ED_P)
+    x = pow(x2, (ED_P + 3) // 8, ED_P)
+    if (x * x - x2) % ED_P != 0:
+        x = x * pow(2, (ED_P - 1) // 4, ED_P) % ED_P
+    if x & 1:
+        x = ED_P - x
+    return (x, y, 1, x * y % ED_P)
+
+def ed_expand(seed):
+    digest = hashlib.sha512(seed).digest()
+    a = int.from_bytes(digest[:32], "little")
+    a &= (1 << 254) - 8
+    a |= 1 << 254
+    return a, digest[32:]
+
+def ed_public(seed):
+    return ed_compress(ed_mul(ed_expand(seed)[0], ed_base()))
+
+def ed_sign(seed, message):
+    a, prefix = ed_expand(seed)
+    r = int.from_bytes(hashlib.sha512(prefix + message).digest(), "little") % ED_Q
+    R = ed_compress(ed_mul(r, ed_base()))
+    h = int.from_bytes(hashlib.sha512(R + ed_public(seed) + message).digest(), "little") % ED_Q
+    return R + int.to_bytes((r + h * a) % ED_Q, 32, "little")
+
+# minimal cbor encoder for attestation objects and cose keys
+def cbor(value):
+    def head(major, length):
+        if length < 24:
+            return bytes([major << 5 | length])
+        for info, size in ((24, 1), (25, 2), (26, 4), (27, 8)):
+            if length < 1 << (8 * size):
+                return bytes([major << 5 | info]) + length.to_bytes(size, "big")
+    if isinstance(value, int):
+        return head(0, value) if value >= 0 else head(1, -1 - value)
+    if isinstance(value, bytes):
+        return head(2, len(value)) + value
+    if isinstance(value, str):
+        return head(3, len(value.encode())) + value.encode()
+    if isinstance(value, dict):
+        return head(5, len(value)) + b"".join(cbor(k) + cbor(v) for k, v in value.items())
+    raise TypeError(type(value))
+
+def b64url(data):
+    return base64.urlsafe_b64encode(data).rstrip(b"=").decode()
+
+def b64url_decode(data):
+    return base64.urlsafe_b64decode(data + "=" * (-len(data) % 4))
+
+class SoftwareAuthenticator:
+    """a resident ed25519 passkey with user verification, 'none' attestation"""
+    RP_ID = "localhost"
+    ORIGIN = "http://localhost"
+
+    def __init__(self):
+        self.seed = uuid.uuid4().bytes + uuid.uuid4().bytes
+        self.credential_id = uuid.uuid4().bytes
+        self.user_handle = None
+        self.counter = 0
+
+    def client_data(self, kind, challenge):
+        return json.dumps({"type": kind, "challenge": challenge, "origin": self.ORIGIN, "crossOrigin": False}).encode()
+
+    def auth_data(self, flags, attested=b""):
+        return hashlib.sha256(self.RP_ID.encode()).digest() + bytes([flags]) + struct.pack(">I", self.counter) + attested
+
+    def create(self, options):
+        public_key = options["publicKey"]
+        self.user_handle = b64url_decode(public_key["user"]["id"])
+        cose_key = cbor({1: 1, 3: -8, -1: 6, -2: ed_public(self.seed)})
+        attested = bytes(16) + struct.pack(">H", len(self.credential_id)) + self.credential_id + cose_key
+        attestation = cbor({"fmt": "none", "attStmt": {}, "authData": self.auth_data(0x45, attested)})  # UP, UV, AT
+        return {
+            "id": b64url(self.credential_id),
+            "rawId": b64url(self.credential_id),
+            "type": "public-key",
+            "response": {
+                "clientDataJSON": b64url(self.client_data("webauthn.create", public_key["challenge"])),
+                "attestationObject": b64url(attestation),
+                "transports": ["internal"],
+            },
+        }
+
+    def get(self, options, counter_step=1):
+        self.counter += counter_step
+        client_data = self.client_data("webauthn.get", options["publicKey"]["challenge"])
+        auth_data = self.auth_data(0x05)  # UP, UV
+        return {
+            "id": b64url(self.credential_id),
+            "rawId": b64url(self.credential_id),
+            "type": "public-key",
+            "response": {
+                "clientDataJSON": b64url(client_data),
+                "authenticatorData": b64url(auth_data),
+                "signature": b64url(ed_sign(self.seed, auth_data + hashlib.sha256(client_data).digest())),
+                "userHandle": b64url(self.user_handle),
+            },
+        }
+
+def passkey_login(authenticator, device_id, counter_step=1):
+    response = requests.post(f"{BASE_URL}/auth/passkeys/login/start", json={"device_id": device_id})
+    assert response.status_code == 200
+    ceremony_id = response.json()["ceremony_id"]
+    assertion = authenticator.get(response.json()["options"], counter_step)
+    return requests.post(f"{BASE_URL}/auth/passkeys/login/finish", params={"ceremony_id": ceremony_id}, json=assertion)
+
+def test_passkeys():
+    print("\n=== Testing Passkeys ===")
+
+    email = f"passkey_{uuid.uuid4().hex[:8]}@example.com"
+    device_id = str(uuid.uuid4())
+    authenticator = SoftwareAuthenticator()
+
+    print("\n[1] Signing up without password...")
+    response = requests.post(f"{BASE_URL}/auth/passkeys/signup/start", json={"email": email, "device_id": device_id})
+    print_response(response)
+    assert response.status_code == 200
+    ceremony_id = response.json()["ceremony_id"]
+    credential = authenticator.create(response.json()["options"])
+    response = requests.post(f"{BASE_URL}/auth/passkeys/signup/finish", params={"ceremony_id": ceremony_id}, json=credential)
+    print_response(response)
+    assert response.status_code == 200
+    user_id = response.json().get("user_id")
+
+    print("\n[2] Replaying the signup ceremony fails...")
+    response = requests.post(f"{BASE_URL}/auth/passkeys/signup/finish", params={"ceremony_id": ceremony_id}, json=credential)
+    assert response.status_code == 401
+
+    print("\n[3] Password login is impossible for the passwordless account...")
+    assert login_user(email, "", device_id).status_code == 401
+
+    print("\n[4] Logging in with the passkey...")
+    response = passkey_login(authenticator, device_id)
+    print_response(response)
+    assert response.status_code == 200
+    assert response.json().get("user_id") == user_id
+    access_token = response.json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": device_id}
+
+    print("\n[5] A signature counter that did not advance blocks the passkey...")
+    clone = SoftwareAuthenticator()
+    clone.seed, clone.credential_id, clone.user_handle, clone.counter = authenticator.seed, authenticator.credential_id, authenticator.user_handle, 0
+    response = passkey_login(clone, device_id)
+    print_response(response)
+    assert response.status_code == 401
+    assert passkey_login(authenticator, device_id).status_code == 401
+
+    print("\n[6] Registering a second passkey...")
+    second = SoftwareAuthenticator()
+    response = requests.post(f"{BASE_URL}/auth/passkeys/register/start", headers=headers)
+    assert response.status_code == 200
+    ceremony_id = response.json()["ceremony_id"]
+    response = requests.post(f"{BASE_URL}/auth/passkeys/register/finish", headers=headers,
+                             params={"ceremony_id": ceremony_id, "name": "laptop"}, json=second.create(response.json()["options"]))
+    print_response(response)
+    assert response.status_code == 201
+    second_id = response.json()["id"]
+
+    response = requests.get(f"{BASE_URL}/auth/passkeys", headers=headers)
+    print_response(response)
+    passkeys = response.json()["passkeys"]
+    assert len(passkeys) == 2
+    assert [p for p in passkeys if p["id"] == b64url(authenticator.credential_id)][0]["blocked"] is True
+
+    print("\n[7] Renaming and deleting passkeys...")
+    response = requests.post(f"{BASE_URL}/auth/passkeys/rename", headers=headers, json={"id": second_id, "name": "work laptop"})
+    assert response.status_code == 200
+    response = requests.post(f"{BASE_URL}/auth/passkeys/delete", headers=headers, json={"id": b64url(authenticator.credential_id)})
+    assert response.status_code == 200
+
+    print("\n[8] The last passkey of a passwordless account cannot be deleted...")
+    response = requests.post(f"{BASE_URL}/auth/passkeys/delete", headers=headers, json={"id": second_id})
+    print_response(response)
+    assert response.status_code == 409
+
+    response = passkey_login(second, device_id)
+    assert response.status_code == 200
+
+    delete_user(response.json().get("access_token"), device_id)
+
 def test_invalid_credentials():
     print("\n=== Testing Invalid Credentials ===")
     
@@ -218,6 +524,37 @@ def test_invalid_credentials():
     print_response(response)
     assert response.status_code != 200
 
+def test_login_lockout():
+    print("\n=== Testing Login Lockout ===")
+
+    email = f"lockout_{uuid.uuid4().hex[:8]}@example.com"
+    register_user(email, TEST_PASSWORD)
+
+    print("\n[1] Failing logins until the account is locked...")
+    statuses = []
+    for _ in range(5):
+        response = login_user(email, "WrongPass123#", DEVICE_ID)
+        statuses.append(response.status_code)
+    print(statuses)
+    assert statuses[:4] == [401] * 4
+    assert statuses[4] == 429
+
+    print("\n[2] Correct password is rejected while locked...")
+    response = login_user(email, TEST_PASSWORD, DEVICE_ID)
+    print_response(response)
+    assert response.status_code == 429
+    assert int(response.headers.get("Retry-After")) > 0
+
+    print("\n[3] Admin unlocks the account...")
+    admin_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    response = requests.post(f"{BASE_URL}/admin/unlock_login", headers={"Authorization": f"Bearer {admin_token}", "X-Device-ID": DEVICE_ID}, json={"email": email})
+    print_response(response)
+    assert response.status_code == 200
+
+    response = login_user(email, TEST_PASSWORD, DEVICE_ID)
+    print_response(response)
+    assert response.status_code == 200
+
 def test_device_binding():
     print("\n=== Testing Device Binding ===")
     
@@ -235,6 +572,33 @@ def test_device_binding():
     print_response(response)
     assert response.status_code == 401
 
+def test_refresh_token_reuse():
+    print("\n=== Testing Refresh Token Reuse Detection ===")
+
+    device_id = str(uuid.uuid4())
+    login_response = login_user(TEST_EMAIL, TEST_PASSWORD, device_id)
+    old_refresh_token = login_response.json().get("refresh_token")
+
+    print("\n[1] Rotating refresh token...")
+    response = refresh_token(old_refresh_token, device_id)
+    print_response(response)
+    assert response.status_code == 200
+    new_refresh_token = response.json().get("refresh_token")
+    new_access_token = response.json().get("access_token")
+
+    print("\n[2] Replaying rotated refresh token...")
+    response = refresh_token(old_refresh_token, device_id)
+    print_response(response)
+    assert response.status_code == 401
+
+    print("\n[3] Verifying the whole device session was revoked...")
+    response = refresh_token(new_refresh_token, device_id)
+    print_response(response)
+    assert response.status_code == 401
+    response = protected_request(new_access_token, device_id)
+    print_response(response)
+    assert response.status_code == 401
+
 def test_concurrent_sessions():
     print("\n=== Testing Concurrent Sessions ===")
     
@@ -254,6 +618,557 @@ def test_concurrent_sessions():
         print_response(response)
         assert response.status_code == 200
 
+    headers = {"Authorization": f"Bearer {tokens[0]}", "X-Device-ID": devices[0]}
+
+    print("\n[3] Listing sessions...")
+    response = requests.get(f"{BASE_URL}/auth/sessions", headers=headers)
+    print_response(response)
+    assert response.status_code == 200
+    listed = {s["device_id"]: s for s in response.json().get("sessions")}
+    assert all(device_id in listed for device_id in devices)
+    assert listed[devices[0]]["current"] is True
+
+    print("\n[4] Revoking a single device...")
+    response = requests.post(f"{BASE_URL}/auth/sessions/revoke", headers=headers, json={"device_id": devices[1]})
+    print_response(response)
+    assert response.status_code == 200
+    assert protected_request(tokens[1], devices[1]).status_code == 401
+
+    print("\n[5] Revoking all other devices...")
+    response = requests.post(f"{BASE_URL}/auth/sessions/revoke", headers=headers, json={"all_others": True})
+    print_response(response)
+    assert response.status_code == 200
+    assert protected_request(tokens[2], devices[2]).status_code == 401
+    assert protected_request(tokens[0], devices[0]).status_code == 200
+
+def test_unverified_scan_blocked():
+    print("\n=== Testing Unverified Account Restrictions ===")
+
+    print("\n[1] Logging in with unverified account...")
+    login_response = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID)
+    print_response(login_response)
+    assert login_response.status_code == 200
+
+    access_token = login_response.json().get("access_token")
+
+    print("\n[2] Attempting to scan before verifying email...")
+    response = get_qr_action(access_token, DEVICE_ID, str(uuid.uuid4()))
+    print_response(response)
+    assert response.status_code == 403
+
+    print("\n[3] Verifying with invalid token...")
+    response = requests.get(f"{BASE_URL}/auth/verify_email?token=invalid")
+    print_response(response)
+    assert response.status_code == 400
+
+def test_password_reset():
+    print("\n=== Testing Password Reset ===")
+
+    print("\n[1] Requesting reset for unknown email...")
+    response = requests.post(f"{BASE_URL}/auth/forgot_password", json={"email": f"unknown_{uuid.uuid4().hex[:8]}@example.com"})
+    print_response(response)
+    assert response.status_code == 200
+
+    print("\n[2] Requesting reset for existing email...")
+    response = requests.post(f"{BASE_URL}/auth/forgot_password", json={"email": TEST_EMAIL})
+    print_response(response)
+    assert response.status_code == 200
+
+    print("\n[3] Resetting with invalid token...")
+    response = requests.post(f"{BASE_URL}/auth/reset_password", json={"token": "invalid", "new_password": "NewPass123#"})
+    print_response(response)
+    assert response.status_code == 400
+
+def test_password_policy():
+    print("\n=== Testing Password Policy ===")
+
+    print("\n[1] Registering with a weak password returns every violated rule...")
+    response = register_user(f"policy_{uuid.uuid4().hex[:8]}@example.com", "aaaaaaa")
+    print_response(response)
+    assert response.status_code == 400
+    codes = {violation["code"] for violation in response.json().get("violations", [])}
+    assert {"too_short", "missing_uppercase", "missing_digit", "repeated_characters"} <= codes
+
+    print("\n[2] Registering with a common password...")
+    response = register_user(f"policy_{uuid.uuid4().hex[:8]}@example.com", "Password123")
+    print_response(response)
+    assert response.status_code == 400
+    assert [violation["code"] for violation in response.json()["violations"]] == ["breached"]
+
+    print("\n[3] Registering with a password containing the email...")
+    local_part = f"policy_{uuid.uuid4().hex[:8]}"
+    response = register_user(f"{local_part}@example.com", f"X1{local_part.upper()}x")
+    print_response(response)
+    assert response.status_code == 400
+    assert [violation["code"] for violation in response.json()["violations"]] == ["contains_email"]
+
+    print("\n[4] Changing to a breached password is rejected...")
+    access_token = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID).json().get("access_token")
+    response = requests.post(
+        f"{BASE_URL}/auth/change_password",
+        headers={"Authorization": f"Bearer {access_token}"},
+        json={"old_password": TEST_PASSWORD, "new_password": "Welcome1!"}
+    )
+    print_response(response)
+    assert response.status_code == 400
+    assert response.json()["violations"][0]["code"] == "breached"
+
+def test_legacy_password_rehash():
+    print("\n=== Testing Legacy Password Rehash ===")
+    from argon2.low_level import Type, hash_secret_raw
+
+    email = f"legacy_{uuid.uuid4().hex[:8]}@example.com"
+    user_id = register_user(email, TEST_PASSWORD).json().get("id")
+
+    print("\n[1] Storing the password in the legacy salt$hash format...")
+    salt = uuid.uuid4().bytes
+    digest = hash_secret_raw((TEST_PASSWORD + PEPPER_SECRET).encode(), salt, time_cost=1, memory_cost=64 * 1024, parallelism=4, hash_len=32, type=Type.ID)
+    encode = lambda raw: base64.b64encode(raw).decode().rstrip("=")
+    cql(f"UPDATE auth.accounts SET password_hash = '{encode(salt)}${encode(digest)}' WHERE id = {user_id}")
+
+    print("\n[2] Logging in with the legacy hash...")
+    response = login_user(email, TEST_PASSWORD, DEVICE_ID)
+    print_response(response)
+    assert response.status_code == 200
+
+    print("\n[3] Checking the stored hash was upgraded...")
+    output = cql(f"SELECT password_hash FROM auth.accounts WHERE id = {user_id}")
+    stored = [line.strip() for line in output.splitlines() if line.strip().startswith("$argon2id$")]
+    print(stored)
+    assert len(stored) == 1
+
+    print("\n[4] Logging in with the upgraded hash...")
+    response = login_user(email, TEST_PASSWORD, DEVICE_ID)
+    print_response(response)
+    assert response.status_code == 200
+
+def test_email_change():
+    print("\n=== Testing Email Change ===")
+
+    email = f"change_{uuid.uuid4().hex[:8]}@example.com"
+    register_user(email, TEST_PASSWORD)
+    access_token = login_user(email, TEST_PASSWORD, DEVICE_ID).json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}"}
+
+    print("\n[1] Requesting a change with the wrong password...")
+    response = requests.post(f"{BASE_URL}/auth/change_email", headers=headers,
+                             json={"password": "Wrong123#", "new_email": f"new_{uuid.uuid4().hex[:8]}@example.com"})
+    print_response(response)
+    assert response.status_code == 401
+
+    print("\n[2] Requesting a change to an address of another account...")
+    response = requests.post(f"{BASE_URL}/auth/change_email", headers=headers,
+                             json={"password": TEST_PASSWORD, "new_email": TEST_EMAIL})
+    print_response(response)
+    assert response.status_code == 409
+
+    print("\n[3] Requesting a valid change...")
+    response = requests.post(f"{BASE_URL}/auth/change_email", headers=headers,
+                             json={"password": TEST_PASSWORD, "new_email": f"new_{uuid.uuid4().hex[:8]}@example.com"})
+    print_response(response)
+    assert response.status_code == 202
+
+    print("\n[4] The old address stays active until the change is confirmed...")
+    response = login_user(email, TEST_PASSWORD, DEVICE_ID)
+    assert response.status_code == 200
+
+    print("\n[5] Confirming and cancelling with invalid tokens...")
+    response = requests.get(f"{BASE_URL}/auth/confirm_email_change?token=invalid")
+    print_response(response)
+    assert response.status_code == 400
+    response = requests.post(f"{BASE_URL}/auth/cancel_email_change", json={"token": "invalid"})
+    print_response(response)
+    assert response.status_code == 400
+
+def request_export(headers, export_format):
+    response = requests.post(f"{BASE_URL}/auth/export", headers=headers, json={"format": export_format})
+    if response.status_code != 202:
+        return response
+
+    # large exports are generated in the background
+    export_id = response.json()["id"]
+    for _ in range(30):
+        time.sleep(1)
+        status = requests.get(f"{BASE_URL}/auth/export?id={export_id}", headers=headers).json()["status"]
+        if status in ("ready", "failed"):
+            break
+    return requests.get(f"{BASE_URL}/auth/export/download?id={export_id}", headers=headers)
+
+def test_data_export():
+    print("\n=== Testing Data Export ===")
+
+    access_token = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID).json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": DEVICE_ID}
+
+    print("\n[1] Exporting as json...")
+    response = request_export(headers, "json")
+    print(f"Status: {response.status_code}")
+    assert response.status_code == 200
+    document = response.json()
+    assert document["sections"]["account"]["email"] == TEST_EMAIL
+    assert any(session["device_id"] == DEVICE_ID for session in document["sections"]["sessions"])
+    assert "qr_scans" in document["sections"]
+
+    print("\n[2] Exporting as zip...")
+    response = request_export(headers, "zip")
+    print(f"Status: {response.status_code}")
+    assert response.status_code == 200
+    assert response.headers["Content-Type"] == "application/zip"
+    assert response.content[:2] == b"PK"
+
+    print("\n[3] Listing exports...")
+    response = requests.get(f"{BASE_URL}/auth/export", headers=headers)
+    print_response(response)
+    assert response.status_code == 200
+    assert len(response.json()["exports"]) >= 2
+
+    print("\n[4] Unknown format and unknown export...")
+    response = requests.post(f"{BASE_URL}/auth/export", headers=headers, json={"format": "xml"})
+    assert response.status_code == 400
+    response = requests.get(f"{BASE_URL}/auth/export/download?id={uuid.uuid4()}", headers=headers)
+    assert response.status_code == 404
+
+def test_account_deletion():
+    print("\n=== Testing Account Deletion ===")
+
+    email = f"deleted_{uuid.uuid4().hex[:8]}@example.com"
+    device_id = str(uuid.uuid4())
+    assert register_user(email, TEST_PASSWORD).status_code == 201
+    access_token = login_user(email, TEST_PASSWORD, device_id).json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": device_id}
+
+    print("\n[1] Deleting account...")
+    response = delete_user(access_token, device_id)
+    print_response(response)
+    assert response.status_code == 200
+    assert response.json().get("status") == "account scheduled for deletion"
+
+    print("\n[2] Access token no longer works...")
+    response = requests.get(f"{BASE_URL}/auth/export", headers=headers)
+    print(f"Status: {response.status_code}")
+    assert response.status_code == 401
+
+    print("\n[3] Login is blocked during the grace period...")
+    response = login_user(email, TEST_PASSWORD, device_id)
+    print_response(response)
+    assert response.status_code == 403
+
+    print("\n[4] Email stays taken until the purge...")
+    response = register_user(email, TEST_PASSWORD)
+    print_response(response)
+    assert response.status_code == 400
+
+    print("\n[5] Invalid restore token...")
+    response = requests.get(f"{BASE_URL}/auth/restore_account", params={"token": "invalid"})
+    print_response(response)
+    assert response.status_code == 400
+
+def test_guest_accounts():
+    print("\n=== Testing Guest Accounts ===")
+
+    admin_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    action_id = create_qr_action(admin_token, DEVICE_ID, '{"type": "guest_action"}').json().get("qr_action_id")
+    first = create_qr_code(admin_token, DEVICE_ID, action_id).json()
+    second = create_qr_code(admin_token, DEVICE_ID, action_id).json()
+    first_code, second_code = first.get("qr_code_id"), second.get("qr_code_id")
+
+    print("\n[1] Starting as guest and scanning...")
+    guest_device = str(uuid.uuid4())
+    response = requests.post(f"{BASE_URL}/auth/guest", json={"device_id": guest_device})
+    print_response(response)
+    assert response.status_code == 200
+    guest_token = response.json().get("access_token")
+    response = get_qr_action(guest_token, guest_device, first.get("payload"))
+    print_response(response)
+    assert response.status_code == 200
+
+    print("\n[2] Guests cannot set a password without email...")
+    headers = {"Authorization": f"Bearer {guest_token}", "X-Device-ID": guest_device}
+    response = requests.post(f"{BASE_URL}/auth/change_password", headers=headers, json={"old_password": "", "new_password": TEST_PASSWORD})
+    print_response(response)
+    assert response.status_code == 403
+
+    print("\n[3] Claiming the guest account...")
+    email = f"guest_{uuid.uuid4().hex[:8]}@example.com"
+    response = requests.post(f"{BASE_URL}/auth/guest/claim", headers=headers, json={"email": email, "password": TEST_PASSWORD})
+    print_response(response)
+    assert response.status_code == 200
+    assert response.json().get("email") == email
+    response = request_export(headers, "json")
+    assert response.status_code == 200
+    assert any(scan["qr_code_id"] == first_code for scan in response.json()["sections"]["qr_scans"])
+    assert login_user(email, TEST_PASSWORD, str(uuid.uuid4())).status_code == 200
+
+    print("\n[4] A claimed account cannot be claimed again...")
+    response = requests.post(f"{BASE_URL}/auth/guest/claim", headers=headers, json={"email": email, "password": TEST_PASSWORD})
+    print_response(response)
+    assert response.status_code == 409
+
+    print("\n[5] Merging a guest into an existing account...")
+    guest_device = str(uuid.uuid4())
+    guest_token = requests.post(f"{BASE_URL}/auth/guest", json={"device_id": guest_device}).json().get("access_token")
+    assert get_qr_action(guest_token, guest_device, second.get("payload")).status_code == 200
+    headers = {"Authorization": f"Bearer {guest_token}", "X-Device-ID": guest_device}
+    response = requests.post(f"{BASE_URL}/auth/guest/merge", headers=headers, json={"email": TEST_EMAIL, "password": "wrong"})
+    print_response(response)
+    assert response.status_code == 401
+    response = requests.post(f"{BASE_URL}/auth/guest/merge", headers=headers, json={"email": TEST_EMAIL, "password": TEST_PASSWORD})
+    print_response(response)
+    assert response.status_code == 200
+    assert response.json().get("user_id") == login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID).json().get("user_id")
+    merged_headers = {"Authorization": f"Bearer {response.json().get('access_token')}", "X-Device-ID": guest_device}
+    response = request_export(merged_headers, "json")
+    assert response.status_code == 200
+    assert any(scan["qr_code_id"] == second_code for scan in response.json()["sections"]["qr_scans"])
+
+    print("\n[6] The merged guest is gone...")
+    response = requests.get(f"{BASE_URL}/auth/export", headers=headers)
+    print(f"Status: {response.status_code}")
+    assert response.status_code in (401, 403)
+
+    delete_qr_code(admin_token, DEVICE_ID, first_code)
+    delete_qr_code(admin_token, DEVICE_ID, second_code)
+    delete_qr_action(admin_token, DEVICE_ID, action_id)
+
+def test_magic_link():
+    print("\n=== Testing Magic Link Login ===")
+
+    email = f"magic_{uuid.uuid4().hex[:8]}@example.com"
+    assert register_user(email, TEST_PASSWORD).status_code == 201
+
+    print("\n[1] Requesting sign-in links until the email is rate limited...")
+    statuses = []
+    for _ in range(4):
+        response = requests.post(f"{BASE_URL}/auth/magic_link", json={"email": email, "device_id": DEVICE_ID})
+        statuses.append(response.status_code)
+    print(statuses)
+    assert statuses[:3] == [200] * 3
+    assert statuses[3] == 429
+    assert int(response.headers.get("Retry-After")) > 0
+
+    print("\n[2] Redeeming an invalid link...")
+    response = requests.post(f"{BASE_URL}/auth/magic_link/redeem", json={"token": "invalid", "device_id": DEVICE_ID})
+    print_response(response)
+    assert response.status_code == 401
+
+    print("\n[3] Links cannot be redeemed with GET...")
+    response = requests.get(f"{BASE_URL}/auth/magic_link/redeem", params={"token": "invalid"})
+    print(f"Status: {response.status_code}")
+    assert response.status_code == 405
+
+def totp_code(secret):
+    key = base64.b32decode(secret + "=" * (-len(secret) % 8))
+    digest = hmac.new(key, struct.pack(">Q", int(time.time()) // 30), hashlib.sha1).digest()
+    offset = digest[-1] & 0x0F
+    value = struct.unpack(">I", digest[offset:offset + 4])[0] & 0x7FFFFFFF
+    return f"{value % 1000000:06d}"
+
+def test_two_factor():
+    print("\n=== Testing Two Factor Authentication ===")
+
+    login_response = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID)
+    access_token = login_response.json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": DEVICE_ID}
+
+    print("\n[1] Enrolling authenticator...")
+    response = requests.post(f"{BASE_URL}/auth/2fa/enroll", headers=headers)
+    print_response(response)
+    assert response.status_code == 200
+    secret = response.json().get("secret")
+    assert response.json().get("otpauth_uri").startswith("otpauth://totp/")
+
+    print("\n[2] Confirming with wrong code...")
+    response = requests.post(f"{BASE_URL}/auth/2fa/confirm", headers=headers, json={"code": "000000" if totp_code(secret) != "000000" else "111111"})
+    print_response(response)
+    assert response.status_code == 401
+
+    print("\n[3] Confirming with valid code...")
+    response = requests.post(f"{BASE_URL}/auth/2fa/confirm", headers=headers, json={"code": totp_code(secret)})
+    print_response(response)
+    assert response.status_code == 200
+    recovery_codes = response.json().get("recovery_codes")
+    assert len(recovery_codes) == 10
+
+    print("\n[4] Logging in returns a challenge...")
+    response = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID)
+    print_response(response)
+    assert response.status_code == 200
+    assert response.json().get("two_factor_required") is True
+    assert response.json().get("access_token") is None
+    challenge = response.json().get("challenge_token")
+
+    print("\n[5] Completing challenge with recovery code...")
+    response = requests.post(f"{BASE_URL}/auth/login/2fa", json={"challenge_token": challenge, "recovery_code": recovery_codes[0]})
+    print_response(response)
+    assert response.status_code == 200
+    headers["Authorization"] = f"Bearer {response.json().get('access_token')}"
+
+    print("\n[6] Reusing recovery code fails...")
+    response = requests.post(f"{BASE_URL}/auth/login/2fa", json={"challenge_token": challenge, "recovery_code": recovery_codes[0]})
+    print_response(response)
+    assert response.status_code == 401
+
+    print("\n[7] Disabling two factor...")
+    response = requests.post(f"{BASE_URL}/auth/2fa/disable", headers=headers, json={"recovery_code": recovery_codes[1]})
+    print_response(response)
+    assert response.status_code == 200
+
+def test_permissions():
+    print("\n=== Testing Role Based Permissions ===")
+
+    print("\n[1] Regular user has no management permissions...")
+    access_token = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID).json().get("access_token")
+    response = requests.get(f"{BASE_URL}/auth/permissions", headers={"Authorization": f"Bearer {access_token}", "X-Device-ID": DEVICE_ID})
+    print_response(response)
+    assert response.status_code == 200
+    assert response.json().get("permissions") == []
+
+    response = get_qr_codes(access_token, DEVICE_ID)
+    print_response(response)
+    assert response.status_code == 403
+
+    print("\n[2] Admin account has management permissions...")
+    admin_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    response = requests.get(f"{BASE_URL}/auth/permissions", headers={"Authorization": f"Bearer {admin_token}", "X-Device-ID": DEVICE_ID})
+    print_response(response)
+    assert "qr.code.create" in response.json().get("permissions")
+
+def test_user_management():
+    print("\n=== Testing Admin User Management ===")
+
+    admin_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    admin_headers = {"Authorization": f"Bearer {admin_token}", "X-Device-ID": DEVICE_ID}
+
+    print("\n[1] Searching for the test user...")
+    response = requests.get(f"{BASE_URL}/admin/users", params={"q": TEST_EMAIL, "count": 10}, headers=admin_headers)
+    print_response(response)
+    assert response.status_code == 200
+    assert isinstance(response.json().get("truncated"), bool)
+    users = [user for user in response.json().get("users") if user.get("email") == TEST_EMAIL]
+    assert len(users) == 1
+    user_id = users[0].get("id")
+
+    print("\n[2] Viewing the test user...")
+    user_device = str(uuid.uuid4())
+    user_token = login_user(TEST_EMAIL, TEST_PASSWORD, user_device).json().get("access_token")
+    response = requests.get(f"{BASE_URL}/admin/users/get", params={"user_id": user_id}, headers=admin_headers)
+    print_response(response)
+    assert response.status_code == 200
+    assert any(session.get("device_id") == user_device for session in response.json().get("sessions"))
+
+    print("\n[3] Disabling the test user...")
+    response = requests.post(f"{BASE_URL}/admin/users/disable", json={"user_id": user_id}, headers=admin_headers)
+    print_response(response)
+    assert response.status_code == 200
+    assert protected_request(user_token, user_device).status_code in (401, 403)
+    assert login_user(TEST_EMAIL, TEST_PASSWORD, user_device).status_code == 403
+
+    print("\n[4] Re-enabling the test user...")
+    response = requests.post(f"{BASE_URL}/admin/users/enable", json={"user_id": user_id}, headers=admin_headers)
+    print_response(response)
+    assert response.status_code == 200
+    user_token = login_user(TEST_EMAIL, TEST_PASSWORD, user_device).json().get("access_token")
+    assert protected_request(user_token, user_device).status_code == 200
+
+    print("\n[5] Granting and revoking admin...")
+    response = requests.post(f"{BASE_URL}/admin/users/set_admin", json={"user_id": user_id, "admin": True}, headers=admin_headers)
+    print_response(response)
+    assert response.status_code == 200
+    assert get_qr_codes(user_token, user_device).status_code == 200
+    user_headers = {"Authorization": f"Bearer {user_token}", "X-Device-ID": user_device}
+    admin_id = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("user_id")
+    for action in ("disable", "logout"):
+        response = requests.post(f"{BASE_URL}/admin/users/{action}", json={"user_id": admin_id}, headers=user_headers)
+        print_response(response)
+        assert response.status_code == 403
+        response = requests.post(f"{BASE_URL}/admin/users/{action}", json={"user_id": user_id}, headers=user_headers)
+        assert response.status_code == 400
+    response = requests.post(f"{BASE_URL}/admin/users/set_admin", json={"user_id": user_id, "admin": False}, headers=admin_headers)
+    assert response.status_code == 200
+    assert get_qr_codes(user_token, user_device).status_code == 403
+
+    print("\n[6] Forcing a logout...")
+    response = requests.post(f"{BASE_URL}/admin/users/logout", json={"user_id": user_id}, headers=admin_headers)
+    print_response(response)
+    assert response.status_code == 200
+    assert protected_request(user_token, user_device).status_code == 401
+
+def test_api_keys():
+    print("\n=== Testing Service Accounts and API Keys ===")
+
+    login_response = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID)
+    admin_id = login_response.json().get("user_id")
+    admin_token = login_response.json().get("access_token")
+    admin_headers = {"Authorization": f"Bearer {admin_token}", "X-Device-ID": DEVICE_ID}
+
+    print("\n[1] Creating service account and key...")
+    response = requests.post(f"{BASE_URL}/admin/service_accounts/create", headers=admin_headers, json={"name": "kiosk", "description": "test kiosk"})
+    print_response(response)
+    assert response.status_code == 201
+    service_account_id = response.json().get("id")
+
+    response = requests.post(f"{BASE_URL}/admin/api_keys/create", headers=admin_headers,
+                             json={"service_account_id": service_account_id, "scopes": ["qr.scan", "qr.code.read", "qr.action.read"]})
+    print_response(response)
+    assert response.status_code == 201
+    key = response.json().get("key")
+    key_id = response.json().get("id")
+    assert key.startswith("mpg_" + key_id + "_")
+    key_headers = {"Authorization": f"Bearer {key}"}
+
+    print("\n[2] Unknown scopes cannot be granted...")
+    response = requests.post(f"{BASE_URL}/admin/api_keys/create", headers=admin_headers,
+                             json={"service_account_id": service_account_id, "scopes": ["everything"]})
+    assert response.status_code == 403
+
+    print("\n[3] Key works inside its scopes without device header...")
+    response = requests.get(f"{BASE_URL}/qr-mgmt/list_codes", headers=key_headers)
+    print_response(response)
+    assert response.status_code == 200
+    response = requests.post(f"{BASE_URL}/qr-mgmt/add_action", headers=key_headers, json={"action_json": "{}"})
+    assert response.status_code == 403
+    response = requests.get(f"{BASE_URL}/auth/sessions", headers=key_headers)
+    assert response.status_code == 403
+
+    print("\n[4] Scanning on behalf of a user...")
+    action_id = create_qr_action(admin_token, DEVICE_ID, '{"type": "kiosk"}').json().get("qr_action_id")
+    code_id = create_qr_code(admin_token, DEVICE_ID, action_id).json().get("qr_code_id")
+    response = requests.get(f"{BASE_URL}/qr/scan", params={"qr_code_id": code_id}, headers=key_headers)
+    assert response.status_code == 400
+    response = requests.get(f"{BASE_URL}/qr/scan", params={"qr_code_id": code_id}, headers={**key_headers, "X-On-Behalf-Of": admin_id})
+    print_response(response)
+    assert response.status_code == 200
+
+    print("\n[5] Rotating keeps the old key valid for the grace period...")
+    response = requests.post(f"{BASE_URL}/admin/api_keys/rotate", headers=admin_headers, json={"key_id": key_id})
+    print_response(response)
+    assert response.status_code == 201
+    new_key = response.json().get("key")
+    new_key_id = response.json().get("id")
+    assert response.json().get("replaced_key").get("expires_at") is not None
+    assert requests.get(f"{BASE_URL}/qr-mgmt/list_codes", headers=key_headers).status_code == 200
+    assert requests.get(f"{BASE_URL}/qr-mgmt/list_codes", headers={"Authorization": f"Bearer {new_key}"}).status_code == 200
+
+    print("\n[6] Revoked and tampered keys are rejected...")
+    response = requests.post(f"{BASE_URL}/admin/api_keys/revoke", headers=admin_headers, json={"key_id": key_id})
+    assert response.status_code == 200
+    assert requests.get(f"{BASE_URL}/qr-mgmt/list_codes", headers=key_headers).status_code == 401
+    tampered = new_key[:-2] + ("AA" if not new_key.endswith("AA") else "BB")
+    assert requests.get(f"{BASE_URL}/qr-mgmt/list_codes", headers={"Authorization": f"Bearer {tampered}"}).status_code == 401
+
+    response = requests.get(f"{BASE_URL}/admin/api_keys", params={"service_account_id": service_account_id}, headers=admin_headers)
+    print_response(response)
+    keys = {k["id"]: k for k in response.json().get("keys")}
+    assert keys[key_id]["active"] is False and keys[new_key_id]["active"] is True
+
+    print("\n[7] Deleting the service account revokes its keys...")
+    response = requests.post(f"{BASE_URL}/admin/service_accounts/delete", headers=admin_headers, json={"service_account_id": service_account_id})
+    assert response.status_code == 200
+    assert requests.get(f"{BASE_URL}/qr-mgmt/list_codes", headers={"Authorization": f"Bearer {new_key}"}).status_code == 401
+
+    delete_qr_code(admin_token, DEVICE_ID, code_id)
+    delete_qr_action(admin_token, DEVICE_ID, action_id)
+
 def test_qr_creation_and_scanning():
     print("\n=== Testing QR Code Creation and Scanning ===")
     
@@ -276,7 +1191,7 @@ def test_qr_creation_and_scanning():
     assert code_creation_response.status_code == 201
 
     print("\n[4] Scanning QR Code...")
-    code_action_response = get_qr_action(access_token, DEVICE_ID, code_creation_response.json().get("qr_code_id"))
+    code_action_response = get_qr_action(access_token, DEVICE_ID, code_creation_response.json().get("payload"))
     print_response(code_action_response)
     assert code_action_response.status_code == 200
 
@@ -296,12 +1211,285 @@ def test_qr_creation_and_scanning():
     print_response(action_deletion_response)
     assert action_deletion_response.status_code == 200
 
+def test_signed_qr_codes():
+    print("\n=== Testing Signed QR Codes ===")
+
+    access_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": DEVICE_ID}
+
+    print("\n[1] Creating QR Code...")
+    qr_action_id = create_qr_action(access_token, DEVICE_ID, '{"type": "signed_test"}').json().get("qr_action_id")
+    response = create_qr_code(access_token, DEVICE_ID, qr_action_id)
+    print_response(response)
+    assert response.status_code == 201
+    qr_code_id = response.json().get("qr_code_id")
+    payload = response.json().get("payload")
+    assert payload
+
+    print("\n[2] Checking the signing key is published...")
+    response = requests.get(f"{BASE_URL}/qr/keys")
+    print_response(response)
+    assert response.status_code == 200
+    data = b64url_decode(payload)
+    assert data[0] == 1 and uuid.UUID(bytes=data[2:18]) == uuid.UUID(qr_code_id)
+    key_offset = 22 if data[1] & 1 else 18
+    assert data[key_offset:key_offset + 4].hex() in [key.get("kid") for key in response.json().get("keys")]
+
+    print("\n[3] Scanning a tampered payload...")
+    tampered = bytearray(data)
+    tampered[5] ^= 1
+    response = requests.get(f"{BASE_URL}/qr/scan?payload={b64url(bytes(tampered))}", headers=headers)
+    print_response(response)
+    assert response.status_code == 400
+
+    print("\n[4] Scanning the signed payload...")
+    response = requests.get(f"{BASE_URL}/qr/scan?payload={payload}", headers=headers)
+    print_response(response)
+    assert response.status_code == 200
+
+    print("\n[5] Plain qr code ids are rejected by default...")
+    response = requests.get(f"{BASE_URL}/qr/scan?qr_code_id={qr_code_id}", headers=headers)
+    print_response(response)
+    assert response.status_code == 400
+
+    print("\n[6] Fetching the payload again...")
+    response = requests.get(f"{BASE_URL}/qr-mgmt/get_payload?qr_code_id={qr_code_id}", headers=headers)
+    print_response(response)
+    assert response.status_code == 200
+    assert response.json().get("payload")
+
+    delete_qr_code(access_token, DEVICE_ID, qr_code_id)
+    delete_qr_action(access_token, DEVICE_ID, qr_action_id)
+
+def tiny_png(width, height, rgb):
+    row = b"\x00" + bytes(rgb) * width
+    chunk = lambda kind, data: struct.pack(">I", len(data)) + kind + data + struct.pack(">I", zlib.crc32(kind + data))
+    return (b"\x89PNG\r\n\x1a\n"
+            + chunk(b"IHDR", struct.pack(">IIBBBBB", width, height, 8, 2, 0, 0, 0))
+            + chunk(b"IDAT", zlib.compress(row * height))
+            + chunk(b"IEND", b""))
+
+def test_qr_images():
+    print("\n=== Testing QR Code Images ===")
+
+    access_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": DEVICE_ID}
+    qr_action_id = create_qr_action(access_token, DEVICE_ID, '{"type": "image_test"}').json().get("qr_action_id")
+    qr_code_id = create_qr_code(access_token, DEVICE_ID, qr_action_id).json().get("qr_code_id")
+    image_url = f"{BASE_URL}/qr-mgmt/codes/{qr_code_id}/image"
+
+    print("\n[1] Rendering png...")
+    response = requests.get(f"{image_url}?size=300&ecc=Q&fg=1a237e&bg=ffffff00&caption=Table%2012", headers=headers)
+    print(response.status_code, response.headers.get("Content-Type"))
+    assert response.status_code == 200
+    assert response.headers.get("Content-Type") == "image/png"
+    assert response.content.startswith(b"\x89PNG")
+    width, height = struct.unpack(">II", response.content[16:24])
+    assert width == 300 and height > 300 # caption band below the code
+
+    print("\n[2] Rendering svg...")
+    response = requests.get(f"{image_url}?format=svg&quiet_zone=2", headers=headers)
+    print(response.status_code, response.headers.get("Content-Type"))
+    assert response.status_code == 200
+    assert response.headers.get("Content-Type") == "image/svg+xml"
+    assert response.text.startswith("<svg")
+
+    print("\n[3] Rendering with a logo...")
+    logo = tiny_png(40, 20, (200, 30, 30))
+    response = requests.post(image_url, headers={**headers, "Content-Type": "image/png"}, data=logo)
+    print(response.status_code, response.headers.get("Content-Type"))
+    assert response.status_code == 200
+    response = requests.post(f"{image_url}?ecc=L", headers={**headers, "Content-Type": "image/png"}, data=logo)
+    print_response(response)
+    assert response.status_code == 400
+
+    print("\n[4] Rejecting invalid options...")
+    for query in ("format=gif", "size=10", "ecc=X", "fg=blue", "size=64&quiet_zone=16"):
+        response = requests.get(f"{image_url}?{query}", headers=headers)
+        print(query, response.status_code)
+        assert response.status_code == 400
+    response = requests.get(f"{BASE_URL}/qr-mgmt/codes/{uuid.uuid4()}/image", headers=headers)
+    assert response.status_code == 400
+
+    delete_qr_code(access_token, DEVICE_ID, qr_code_id)
+    delete_qr_action(access_token, DEVICE_ID, qr_action_id)
+
+def test_qr_print():
+    print("\n=== Testing QR Code Print Jobs ===")
+
+    access_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": DEVICE_ID}
+    qr_action_id = create_qr_action(access_token, DEVICE_ID, '{"name": "Print Test"}').json().get("qr_action_id")
+    qr_code_ids = [create_qr_code(access_token, DEVICE_ID, qr_action_id).json().get("qr_code_id") for _ in range(3)]
+
+    print("\n[1] Printing a pdf sheet by ids...")
+    response = requests.post(f"{BASE_URL}/qr-mgmt/print/pdf", headers=headers, json={
+        "qr_code_ids": qr_code_ids, "page_size": "letter", "columns": 2, "rows": 2, "margin_mm": 8})
+    print(response.status_code, response.headers.get("Content-Type"))
+    assert response.status_code == 200
+    assert response.content.startswith(b"%PDF-") and b"/Count 1" in response.content
+
+    print("\n[2] Printing all codes of the action on single code pages...")
+    response = requests.post(f"{BASE_URL}/qr-mgmt/print/pdf", headers=headers, json={
+        "action_id": qr_action_id, "columns": 1, "rows": 1, "cut_marks": False, "labels": False})
+    print(response.status_code, response.headers.get("Content-Type"))
+    assert response.status_code == 200
+    assert b"/Count 3" in response.content
+
+    print("\n[3] Downloading a zip of svg files...")
+    response = requests.post(f"{BASE_URL}/qr-mgmt/print/zip", headers=headers, json={
+        "qr_code_ids": qr_code_ids, "format": "svg", "size": 256})
+    print(response.status_code, response.headers.get("Content-Type"))
+    assert response.status_code == 200
+    names = zipfile.ZipFile(io.BytesIO(response.content)).namelist()
+    assert sorted(names) == sorted(f"qr-{qr_code_id}.svg" for qr_code_id in qr_code_ids)
+
+    print("\n[4] Rejecting invalid print jobs...")
+    for body in ({"qr_code_ids": qr_code_ids, "action_id": qr_action_id},
+                 {"qr_code_ids": [str(uuid.uuid4())]},
+                 {"qr_code_ids": qr_code_ids, "page_size": "b5"},
+                 {"qr_code_ids": qr_code_ids, "columns": 20, "rows": 20},
+                 {"qr_code_ids": qr_code_ids, "margin_mm": 2}):
+        response = requests.post(f"{BASE_URL}/qr-mgmt/print/pdf", headers=headers, json=body)
+        print(response.status_code, response.text.strip())
+        assert response.status_code == 400
+
+    for qr_code_id in qr_code_ids:
+        delete_qr_code(access_token, DEVICE_ID, qr_code_id)
+    delete_qr_action(access_token, DEVICE_ID, qr_action_id)
+
+def test_qr_bulk_creation():
+    print("\n=== Testing Bulk QR Code Creation ===")
+
+    access_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": DEVICE_ID}
+    qr_action_id = create_qr_action(access_token, DEVICE_ID, '{"name": "Bulk Test"}').json().get("qr_action_id")
+    batch = {"batch_id": str(uuid.uuid4()), "action_id": qr_action_id, "qr_code_type": 0, "max_usages": 1, "expire_mins": 600, "count": 5}
+
+    print("\n[1] Creating a batch...")
+    response = requests.post(f"{BASE_URL}/qr-mgmt/add_codes", headers=headers, json=batch)
+    print_response(response)
+    assert response.status_code == 201
+    codes = response.json().get("codes")
+    assert len(codes) == 5 and all(code.get("payload") for code in codes)
+
+    print("\n[2] Retrying the same batch...")
+    response = requests.post(f"{BASE_URL}/qr-mgmt/add_codes", headers=headers, json=batch)
+    print(response.status_code)
+    assert response.status_code == 200
+    assert response.json().get("codes") == codes
+
+    print("\n[3] Reusing the batch id with other settings...")
+    response = requests.post(f"{BASE_URL}/qr-mgmt/add_codes", headers=headers, json={**batch, "count": 6})
+    print_response(response)
+    assert response.status_code == 409
+
+    print("\n[4] Getting the manifest as csv...")
+    response = requests.post(f"{BASE_URL}/qr-mgmt/add_codes", headers=headers, json={**batch, "format": "csv"})
+    print(response.status_code, response.headers.get("Content-Type"))
+    assert response.status_code == 200
+    assert response.headers.get("Content-Type") == "text/csv"
+    rows = list(csv.reader(io.StringIO(response.text)))
+    assert rows[0] == ["qr_code_id", "payload", "expires_at"]
+    assert [row[0] for row in rows[1:]] == [code.get("qr_code_id") for code in codes]
+
+    print("\n[5] Scanning a bulk created code...")
+    response = requests.get(f"{BASE_URL}/qr/scan?payload={codes[0].get('payload')}", headers=headers)
+    print_response(response)
+    assert response.status_code == 200
+
+    print("\n[6] Rejecting invalid batches...")
+    for body in ({**batch, "batch_id": str(uuid.uuid4()), "count": 1000000},
+                 {**batch, "batch_id": str(uuid.uuid4()), "count": 0},
+                 {**batch, "batch_id": str(uuid.uuid4()), "expire_mins": 0},
+                 {**batch, "batch_id": "not-a-uuid"}):
+        response = requests.post(f"{BASE_URL}/qr-mgmt/add_codes", headers=headers, json=body)
+        print(response.status_code, response.text.strip())
+        assert response.status_code == 400
+
+    for code in codes:
+        delete_qr_code(access_token, DEVICE_ID, code.get("qr_code_id"))
+    delete_qr_action(access_token, DEVICE_ID, qr_action_id)
+
+def test_qr_global_limit():
+    print("\n=== Testing Global QR Code Limits Under Load ===")
+
+    access_token = login_user(ADMIN_EMAIL, ADMIN_PASSWORD, DEVICE_ID).json().get("access_token")
+    headers = {"Authorization": f"Bearer {access_token}", "X-Device-ID": DEVICE_ID}
+    qr_action_id = create_qr_action(access_token, DEVICE_ID, '{"name": "Global Limit Test"}').json().get("qr_action_id")
+    max_usages = 5
+
+    print("\n[1] Creating a global code...")
+    response = requests.post(f"{BASE_URL}/qr-mgmt/add_code", headers=headers, json={"action_id": qr_action_id, "qr_code_type": 1, "max_usages": max_usages, "expire_mins": 600})
+    print_response(response)
+    assert response.status_code == 201
+    qr_code_id = response.json().get("qr_code_id")
+    payload = response.json().get("payload")
+
+    print("\n[2] Starting guests...")
+    scanners = []
+    for _ in range(12):
+        guest_device = str(uuid.uuid4())
+        response = requests.post(f"{BASE_URL}/auth/guest", json={"device_id": guest_device})
+        assert response.status_code == 200
+        scanners.append({"Authorization": f"Bearer {response.json().get('access_token')}", "X-Device-ID": guest_device})
+
+    print("\n[3] Scanning concurrently, every guest twice...")
+    def scan(scanner_headers):
+        # 503 only means the claim lost too often against concurrent ones, retry until the answer is final
+        for _ in range(20):
+            response = requests.get(f"{BASE_URL}/qr/scan", params={"payload": payload}, headers=scanner_headers)
+            if response.status_code != 503:
+                return response
+            time.sleep(float(response.headers.get("Retry-After", "1")))
+        return response
+
+    with ThreadPoolExecutor(max_workers=24) as pool:
+        responses = list(pool.map(scan, scanners * 2))
+    print([response.status_code for response in responses])
+    assert sum(response.status_code == 200 for response in responses) == max_usages
+    for response in responses:
+        if response.status_code != 200:
+            assert response.status_code == 400
+            assert "maximum number of uses" in response.json().get("error")
+
+    print("\n[4] The limit holds afterwards...")
+    response = requests.get(f"{BASE_URL}/qr/scan", params={"payload": payload}, headers=headers)
+    print_response(response)
+    assert response.status_code == 400
+
+    delete_qr_code(access_token, DEVICE_ID, qr_code_id)
+    delete_qr_action(access_token, DEVICE_ID, qr_action_id)
+
 if __name__ == "__main__":
     test_full_authentication_flow()
+    test_jwks()
+    test_oidc_login()
+    test_passkeys()
     test_invalid_credentials()
+    test_login_lockout()
     test_device_binding()
+    test_refresh_token_reuse()
     test_concurrent_sessions()
+    test_unverified_scan_blocked()
+    test_password_reset()
+    test_magic_link()
+    test_password_policy()
+    test_legacy_password_rehash()
+    test_email_change()
+    test_data_export()
+    test_account_deletion()
+    test_guest_accounts()
+    test_two_factor()
+    test_permissions()
+    test_user_management()
+    test_api_keys()
     test_qr_creation_and_scanning()
+    test_signed_qr_codes()
+    test_qr_images()
+    test_qr_print()
+    test_qr_bulk_creation()
+    test_qr_global_limit()
 
     print("\n[!1] Logging in...")
     login_response = login_user(TEST_EMAIL, TEST_PASSWORD, DEVICE_ID)